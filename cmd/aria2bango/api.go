@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lbl1m/aria2bango/internal/bans"
+	"github.com/lbl1m/aria2bango/internal/control"
+	"github.com/lbl1m/aria2bango/internal/detector"
+	"github.com/lbl1m/aria2bango/internal/peerid"
+)
+
+// banView is the control API representation of a ban
+type banView struct {
+	ID              string    `json:"id"`
+	PreviousID      string    `json:"previous_id,omitempty"`
+	IP              string    `json:"ip"`
+	PeerID          string    `json:"peer_id"`
+	ClientName      string    `json:"client_name"`
+	Reason          string    `json:"reason"`
+	InfoHash        string    `json:"info_hash,omitempty"`
+	Torrent         string    `json:"torrent,omitempty"`
+	Instance        string    `json:"instance,omitempty"`
+	Violations      int       `json:"violations"`
+	Duration        string    `json:"duration"`
+	Start           time.Time `json:"start"`
+	Expires         time.Time `json:"expires"`
+	Remaining       string    `json:"remaining"`
+	BytesDownloaded int64     `json:"bytes_downloaded"`
+	BytesUploaded   int64     `json:"bytes_uploaded"`
+	CreditBalance   *int64    `json:"credit_balance,omitempty"` // 当前上传额度余额，开启detection.credit时
+	DryRun          bool      `json:"dry_run,omitempty"`        // 假设屏蔽，未写入nftables
+}
+
+// banView converts a ban for API output
+func (d *daemon) banView(b *bans.Ban, now time.Time) banView {
+	v := banView{
+		ID:              b.ID,
+		PreviousID:      b.PreviousID,
+		IP:              b.IP,
+		PeerID:          b.PeerID,
+		ClientName:      b.ClientName,
+		Reason:          b.Reason,
+		InfoHash:        b.InfoHash,
+		Torrent:         b.Torrent,
+		Instance:        b.Instance,
+		Violations:      b.Violations,
+		Duration:        detector.FormatDuration(b.Duration),
+		Start:           b.Start,
+		Expires:         b.Expires,
+		Remaining:       remaining(b, now),
+		BytesDownloaded: b.BytesDownloaded,
+		BytesUploaded:   b.BytesUploaded,
+		DryRun:          b.DryRun,
+	}
+	if d.cfg.Detection.Credit.Enabled {
+		credit := int64(d.detector.GetCredit(b.IP))
+		v.CreditBalance = &credit
+	}
+	return v
+}
+
+// creditView is the control API representation of a credit balance
+type creditView struct {
+	IP        string `json:"ip"`
+	Balance   int64  `json:"balance"`
+	Allowance int64  `json:"allowance"`
+}
+
+// instanceView is the control API representation of an aria2 instance
+type instanceView struct {
+	Name         string     `json:"name,omitempty"`
+	Addr         string     `json:"addr"`
+	PollInterval string     `json:"poll_interval"`
+	LastPoll     *time.Time `json:"last_poll,omitempty"`
+	Error        string     `json:"error,omitempty"` // 上次轮询失败的原因
+	Torrents     int        `json:"torrents"`
+	Peers        int        `json:"peers"`
+}
+
+// view returns the state of an instance for API output
+func (i *instance) view() instanceView {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	v := instanceView{
+		Name:         i.Name,
+		Addr:         i.Addr(),
+		PollInterval: i.PollInterval.String(),
+		Torrents:     i.torrents,
+		Peers:        i.peers,
+	}
+	if !i.lastPoll.IsZero() {
+		lastPoll := i.lastPoll
+		v.LastPoll = &lastPoll
+	}
+	if i.lastErr != nil {
+		v.Error = i.lastErr.Error()
+	}
+	return v
+}
+
+// remaining formats the time left of a ban
+func remaining(b *bans.Ban, now time.Time) string {
+	if b.Duration == detector.Permanent {
+		return detector.FormatDuration(detector.Permanent)
+	}
+	return b.Expires.Sub(now).Round(time.Second).String()
+}
+
+// registerAPI registers the control API endpoints:
+//
+//	GET        /log/level   current operational log level
+//	PUT        /log/level   change it, body {"level":"debug"}
+//	GET        /bans        active bans (hypothetical ones in dry-run mode),
+//	                        filtered by ?client=<substring>&version=<range>
+//	                        &instance=<name>
+//	DELETE     /bans/<ip>   remove a ban early (logged as unblocked_manual)
+//	GET        /instances   monitored aria2 instances and their last poll
+//	GET        /credits/<ip> upload credit balance of an IP
+func (d *daemon) registerAPI(srv *control.Server, level zap.AtomicLevel) {
+	srv.Handle("/log/level", level)
+	srv.HandleFunc("/bans", d.handleBans)
+	srv.HandleFunc("/bans/", d.handleBan)
+	srv.HandleFunc("/instances", d.handleInstances)
+	srv.HandleFunc("/credits/", d.handleCredit)
+}
+
+// handleCredit shows the upload credit balance of an IP
+func (d *daemon) handleCredit(w http.ResponseWriter, r *http.Request) {
+	if !control.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	ip := strings.TrimPrefix(r.URL.Path, "/credits/")
+	if ip == "" {
+		control.WriteError(w, http.StatusBadRequest, errors.New("missing IP"))
+		return
+	}
+	if !d.cfg.Detection.Credit.Enabled {
+		control.WriteError(w, http.StatusNotFound, errors.New("the credit ledger is disabled (detection.credit.enabled)"))
+		return
+	}
+	control.WriteJSON(w, http.StatusOK, creditView{
+		IP:        ip,
+		Balance:   int64(d.detector.GetCredit(ip)),
+		Allowance: d.cfg.Detection.Credit.Allowance,
+	})
+}
+
+// handleInstances lists the monitored aria2 instances
+func (d *daemon) handleInstances(w http.ResponseWriter, r *http.Request) {
+	if !control.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	views := make([]instanceView, 0, len(d.instances))
+	for _, inst := range d.instances {
+		views = append(views, inst.view())
+	}
+	control.WriteJSON(w, http.StatusOK, views)
+}
+
+// handleBans lists active bans
+func (d *daemon) handleBans(w http.ResponseWriter, r *http.Request) {
+	if !control.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	client := strings.ToLower(r.URL.Query().Get("client"))
+	instance := r.URL.Query().Get("instance")
+	var versions *peerid.Range
+	if expr := r.URL.Query().Get("version"); expr != "" {
+		parsed, err := peerid.ParseRange(expr)
+		if err != nil {
+			control.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		versions = &parsed
+	}
+
+	now := time.Now()
+	active := d.bans.Active()
+	views := make([]banView, 0, len(active))
+	for _, b := range active {
+		if client != "" && !strings.Contains(strings.ToLower(b.ClientName), client) {
+			continue
+		}
+		if versions != nil && !versions.MatchString(peerid.Parse(b.PeerID).Version) {
+			continue
+		}
+		if instance != "" && b.Instance != instance {
+			continue
+		}
+		views = append(views, d.banView(b, now))
+	}
+	control.WriteJSON(w, http.StatusOK, views)
+}
+
+// handleBan shows or removes the ban of a single IP
+func (d *daemon) handleBan(w http.ResponseWriter, r *http.Request) {
+	if !control.AllowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+
+	ip := strings.TrimPrefix(r.URL.Path, "/bans/")
+	if ip == "" {
+		control.WriteError(w, http.StatusBadRequest, errors.New("missing IP"))
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		ban := d.bans.Get(ip)
+		if ban == nil {
+			control.WriteError(w, http.StatusNotFound, fmt.Errorf("%s: %w", ip, errNotBanned))
+			return
+		}
+		control.WriteJSON(w, http.StatusOK, d.banView(ban, time.Now()))
+		return
+	}
+
+	ban, err := d.unblock(ip, r.URL.Query().Get("reason"))
+	if errors.Is(err, errNotBanned) {
+		control.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		control.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	control.WriteJSON(w, http.StatusOK, d.banView(ban, time.Now()))
+}
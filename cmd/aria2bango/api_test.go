@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// serveAPI sends a request to a daemon handler and decodes the response
+func serveAPI(t *testing.T, handler http.HandlerFunc, method, url string, v interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, url, nil))
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("Invalid response %s: %v", w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func TestBansAPI(t *testing.T) {
+	d, inst := newTestDaemon(t, false)
+	detectAll(d, inst, testTorrent())
+
+	var list []banView
+	if status := serveAPI(t, d.handleBans, http.MethodGet, "/bans?client=xunlei", &list); status != http.StatusOK || len(list) != 1 {
+		t.Fatalf("GET /bans = %d %+v", status, list)
+	}
+	if ban := list[0]; ban.IP != "10.0.0.1" || ban.Reason != "low_share_ratio" || ban.InfoHash != "abcdef" || ban.Violations != 1 {
+		t.Errorf("Unexpected ban: %+v", ban)
+	}
+	if serveAPI(t, d.handleBans, http.MethodGet, "/bans?client=qbittorrent", &list); len(list) != 0 {
+		t.Errorf("Expected client filter to drop the ban, got %+v", list)
+	}
+	if status := serveAPI(t, d.handleBans, http.MethodGet, "/bans?version=bad+range", nil); status != http.StatusBadRequest {
+		t.Errorf("GET /bans with an invalid range = %d, want 400", status)
+	}
+
+	var ban banView
+	if status := serveAPI(t, d.handleBan, http.MethodGet, "/bans/10.0.0.1", &ban); status != http.StatusOK || ban.IP != "10.0.0.1" {
+		t.Errorf("GET /bans/10.0.0.1 = %d %+v", status, ban)
+	}
+	if status := serveAPI(t, d.handleBan, http.MethodGet, "/bans/10.0.0.2", nil); status != http.StatusNotFound {
+		t.Errorf("GET of an IP without a ban = %d, want 404", status)
+	}
+
+	// Unblocking lifts the ban and forgives the violations
+	if status := serveAPI(t, d.handleBan, http.MethodDelete, "/bans/10.0.0.1?reason=false_positive", &ban); status != http.StatusOK {
+		t.Fatalf("DELETE /bans/10.0.0.1 = %d", status)
+	}
+	if blocked, _ := d.firewall.ListBlocked(); len(blocked) != 0 {
+		t.Errorf("Expected the firewall to be empty, got %v", blocked)
+	}
+	if d.detector.GetViolationCount("10.0.0.1") != 0 {
+		t.Error("Expected violations to be reset")
+	}
+	if status := serveAPI(t, d.handleBan, http.MethodDelete, "/bans/10.0.0.1", nil); status != http.StatusNotFound {
+		t.Errorf("Second DELETE = %d, want 404", status)
+	}
+
+	events := readEvents(t, d)
+	if got := eventTypes(events); !reflect.DeepEqual(got, []string{"blocked", "unblocked_manual"}) {
+		t.Fatalf("Events = %v", got)
+	}
+	if event := events[1]; event.Reason != "false_positive" || event.BanID != ban.ID {
+		t.Errorf("Unexpected unblock event: %+v", event)
+	}
+}
+
+func TestCreditAPI(t *testing.T) {
+	d, inst := newTestDaemon(t, false)
+	if status := serveAPI(t, d.handleCredit, http.MethodGet, "/credits/10.0.0.1", nil); status != http.StatusNotFound {
+		t.Errorf("GET /credits without a credit ledger = %d, want 404", status)
+	}
+
+	// The leecher runs out of credit; the ban settles its debt
+	d.cfg.Detection.Credit.Enabled = true
+	detectAll(d, inst, testTorrent())
+
+	var credit creditView
+	if status := serveAPI(t, d.handleCredit, http.MethodGet, "/credits/10.0.0.1", &credit); status != http.StatusOK {
+		t.Fatalf("GET /credits/10.0.0.1 = %d", status)
+	}
+	if allowance := d.cfg.Detection.Credit.Allowance; credit.IP != "10.0.0.1" || credit.Allowance != allowance || credit.Balance != allowance {
+		t.Errorf("Unexpected credit: %+v", credit)
+	}
+
+	var list []banView
+	serveAPI(t, d.handleBans, http.MethodGet, "/bans", &list)
+	if len(list) != 1 || list[0].Reason != "credit_exhausted" || list[0].CreditBalance == nil || *list[0].CreditBalance != credit.Balance {
+		t.Errorf("Expected a credit ban showing balance %d, got %+v", credit.Balance, list)
+	}
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/bans"
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/detector"
+	"github.com/lbl1m/aria2bango/internal/firewall"
+	"github.com/lbl1m/aria2bango/internal/logger"
+	"github.com/lbl1m/aria2bango/internal/peerid"
+	"github.com/lbl1m/aria2bango/internal/trace"
+)
+
+// errNotBanned is returned when unblocking an IP without an active ban
+var errNotBanned = errors.New("not banned")
+
+// daemon wires the aria2 instances, detector, firewall and block log together
+type daemon struct {
+	cfg       *config.Config
+	instances []*instance
+	detector  *detector.Detector // shared by all instances
+	firewall  firewall.Firewall  // in-memory in dry-run mode
+	blockLog  *logger.Logger
+	bans      *bans.Tracker
+	trace     *trace.Writer // nil when tracing is disabled
+	log       *zap.SugaredLogger
+}
+
+// instance is an aria2 instance monitored by the daemon
+type instance struct {
+	config.Instance
+	aria2    *aria2.Client
+	detector *detector.Detector // judges by the instance's settings, shares the daemon's state
+	log      *zap.SugaredLogger // tagged with the instance name
+
+	mutex    sync.Mutex
+	lastPoll time.Time
+	lastErr  error
+	torrents int
+	peers    int
+}
+
+// newInstance connects the daemon to an aria2 instance
+func (d *daemon) newInstance(cfg config.Instance) *instance {
+	inst := &instance{
+		Instance: cfg,
+		aria2:    aria2.NewClient(cfg.Host, cfg.Port, cfg.Secret),
+		log:      d.log,
+	}
+	inst.detector = d.detector.WithConfig(&inst.Instance.Detection)
+	inst.detector.SetPollInterval(cfg.PollInterval)
+	if cfg.Name != "" {
+		inst.log = d.log.With("instance", cfg.Name)
+	}
+	return inst
+}
+
+// run polls an instance until ctx is done
+func (d *daemon) run(ctx context.Context, inst *instance) {
+	ticker := time.NewTicker(inst.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.monitorPeers(ctx, inst); err != nil {
+				inst.log.Errorf("Error monitoring peers: %v", err)
+			}
+		}
+	}
+}
+
+// monitorPeers polls an aria2 instance once and acts on every detection
+// result
+func (d *daemon) monitorPeers(ctx context.Context, inst *instance) error {
+	// Get all peers from active downloads
+	poll, err := inst.aria2.Poll(ctx)
+	inst.record(poll, err)
+	if err != nil {
+		return fmt.Errorf("failed to get peers: %w", err)
+	}
+	torrents := poll.Torrents
+
+	if d.trace != nil {
+		if err := d.trace.Write(trace.NewRecord(inst.Name, poll)); err != nil {
+			inst.log.Warnf("Failed to record trace: %v", err)
+		}
+	}
+
+	// Check each peer
+	totalUpload := aria2.TotalUploadSpeed(torrents)
+	for i := range torrents {
+		torrent := &torrents[i]
+		for _, peer := range torrent.Peers {
+			d.observe(inst, peer)
+
+			// Detect leecher behavior, pass base duration for cumulative punishment
+			if result := inst.detector.Detect(peer, d.cfg.Blocking.BaseDuration); result != nil {
+				d.act(result, inst, torrent)
+			}
+		}
+
+		// Then judge the peers of the torrent together
+		results := inst.detector.DetectSwarm(torrent, d.cfg.Blocking.BaseDuration)
+		results = append(results, inst.detector.DetectReciprocity(torrent, d.cfg.Blocking.BaseDuration)...)
+		results = append(results, inst.detector.DetectBurst(torrent, totalUpload, d.cfg.Blocking.BaseDuration)...)
+		for _, result := range results {
+			d.act(result, inst, torrent)
+		}
+	}
+
+	return nil
+}
+
+// record keeps the outcome of a poll for the control API
+func (i *instance) record(poll *aria2.PollResult, err error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.lastErr = err
+	if err != nil {
+		return
+	}
+	i.lastPoll = poll.Time
+	i.torrents, i.peers = len(poll.Torrents), 0
+	for _, torrent := range poll.Torrents {
+		i.peers += len(torrent.Peers)
+	}
+}
+
+// act carries out a detection result
+func (d *daemon) act(result *detector.DetectionResult, inst *instance, torrent *aria2.TorrentPeers) {
+	switch result.Action {
+	case detector.ActionBlock:
+		if d.block(result, inst, torrent) {
+			// Log gid for debugging
+			inst.log.Debugf("Blocked peer from download %s", torrent.Download.Gid)
+		}
+	case detector.ActionForgive:
+		d.forgive(result, inst)
+	case detector.ActionThrottle:
+		d.throttle(result, inst, torrent)
+	case detector.ActionWatch:
+		d.watch(result, inst, torrent)
+	}
+}
+
+// observe accounts traffic of peers that are currently banned
+func (d *daemon) observe(inst *instance, peer aria2.Peer) {
+	// Speeds are bytes per second, sampled once per poll interval
+	seconds := inst.PollInterval.Seconds()
+	d.bans.Observe(peer.IP, int64(float64(peer.DownloadSpeed)*seconds), int64(float64(peer.UploadSpeed)*seconds))
+}
+
+// block bans a peer in the firewall and logs the block. In dry-run mode the
+// ban is only hypothetical and logged as would_block. It returns false if
+// the firewall rejected the ban.
+func (d *daemon) block(result *detector.DetectionResult, inst *instance, torrent *aria2.TorrentPeers) bool {
+	peer := result.Peer
+	dryRun := d.cfg.Blocking.DryRun
+
+	// Block the peer with the duration from the punishment policy.
+	// Permanent bans have no timeout in the firewall. The ban starts before
+	// the firewall timeout does, so it never outlives the firewall element.
+	start := time.Now()
+	fwDuration := result.BlockDuration
+	if fwDuration == detector.Permanent {
+		fwDuration = 0
+	}
+	if err := d.firewall.BlockIP(peer.IP, fwDuration); err != nil {
+		inst.log.Errorf("Failed to block IP %s: %v", peer.IP, err)
+		return false
+	}
+
+	clientName := peerid.GetNameWithVersion(peer.PeerID)
+	ban := d.bans.Start(bans.Ban{
+		IP:         peer.IP,
+		PeerID:     peer.PeerID,
+		ClientName: clientName,
+		Reason:     result.Reason,
+		InfoHash:   torrent.Download.InfoHash,
+		Torrent:    torrent.Name(),
+		Instance:   inst.Name,
+		Violations: result.Violations,
+		Duration:   result.BlockDuration,
+		Start:      start,
+		DryRun:     dryRun,
+	})
+
+	verb, eventType := "Blocked", logger.EventBlocked
+	if dryRun {
+		verb, eventType = "Would block", logger.EventWouldBlock
+	}
+	credit := ""
+	if d.cfg.Detection.Credit.Enabled {
+		credit = fmt.Sprintf(", credit: %d", int64(result.Credit))
+	}
+	inst.log.Infof("%s %s (reason: %s, violations: %d, duration: %s, share_ratio: %.4f%s, ban_id: %s)",
+		verb, peer.IP, result.Reason, result.Violations, detector.FormatDuration(result.BlockDuration), result.ShareRatio, credit, ban.ID)
+
+	// Log the block event
+	event := logger.BlockEvent{
+		Event:          eventType,
+		IP:             peer.IP,
+		PeerID:         peer.PeerID,
+		ClientName:     clientName,
+		Reason:         result.Reason,
+		Duration:       detector.FormatDuration(result.BlockDuration),
+		DownloadSpeed:  peer.DownloadSpeed,
+		UploadSpeed:    peer.UploadSpeed,
+		ShareRatio:     result.ShareRatio,
+		InfoHash:       ban.InfoHash,
+		TorrentName:    ban.Torrent,
+		Instance:       ban.Instance,
+		PeerUploaded:   result.PeerUploaded,
+		PeerDownloaded: result.PeerDownloaded,
+		MinShareRatio:  result.MinShareRatio,
+		BanID:          ban.ID,
+		Violations:     result.Violations,
+
+		LifetimeShareRatio: result.LifetimeShareRatio,
+		RatioConfidence:    result.RatioConfidence,
+
+		SwarmPeers:        result.SwarmPeers,
+		SwarmZScore:       result.SwarmZScore,
+		SwarmPercentile:   result.SwarmPercentile,
+		PieceAvailability: result.PieceAvailability,
+
+		ChokedUpload:  result.ChokedUpload,
+		CreditBalance: int64(result.Credit),
+
+		BurstPolls:     result.BurstPolls,
+		UploadFraction: result.UploadFraction,
+
+		PeerIDScore:     result.PeerIDScore,
+		PeerIDAnomalies: result.PeerIDAnomalies,
+	}
+	if result.ChokedFor > 0 {
+		event.ChokedFor = result.ChokedFor.String()
+	}
+	if dryRun {
+		// No escalated events in dry-run mode, link the previous ban here
+		event.PreviousBanID = ban.PreviousID
+	}
+	d.logEvent(event)
+
+	// Repeat offenders also get an escalation event linking to the previous ban
+	if !dryRun && result.Violations > 1 && ban.PreviousID != "" {
+		d.logEvent(logger.BlockEvent{
+			Event:         logger.EventEscalated,
+			IP:            peer.IP,
+			PeerID:        peer.PeerID,
+			ClientName:    clientName,
+			Reason:        result.Reason,
+			Duration:      detector.FormatDuration(result.BlockDuration),
+			ShareRatio:    result.ShareRatio,
+			InfoHash:      ban.InfoHash,
+			TorrentName:   ban.Torrent,
+			Instance:      ban.Instance,
+			BanID:         ban.ID,
+			PreviousBanID: ban.PreviousID,
+			Violations:    result.Violations,
+		})
+	}
+
+	return true
+}
+
+// throttle rate limits a peer matched by a client rule. In dry-run mode it
+// is logged as would_throttle only.
+func (d *daemon) throttle(result *detector.DetectionResult, inst *instance, torrent *aria2.TorrentPeers) {
+	peer := result.Peer
+	if err := d.firewall.ThrottleIP(peer.IP, result.BlockDuration); err != nil {
+		inst.log.Errorf("Failed to throttle IP %s: %v", peer.IP, err)
+		return
+	}
+
+	verb, eventType := "Throttled", logger.EventThrottled
+	if d.cfg.Blocking.DryRun {
+		verb, eventType = "Would throttle", logger.EventWouldThrottle
+	}
+	inst.log.Infof("%s %s to %d B/s (reason: %s, duration: %s)", verb, peer.IP, d.cfg.Blocking.ThrottleRate, result.Reason, detector.FormatDuration(result.BlockDuration))
+	d.logEvent(ruleEvent(eventType, result, inst, torrent))
+}
+
+// watch logs a peer matched by a watch-only client rule
+func (d *daemon) watch(result *detector.DetectionResult, inst *instance, torrent *aria2.TorrentPeers) {
+	inst.log.Infof("Watching %s (reason: %s)", result.Peer.IP, result.Reason)
+	d.logEvent(ruleEvent(logger.EventWatched, result, inst, torrent))
+}
+
+// ruleEvent builds the event logged for a throttle or watch rule match
+func ruleEvent(eventType string, result *detector.DetectionResult, inst *instance, torrent *aria2.TorrentPeers) logger.BlockEvent {
+	peer := result.Peer
+	event := logger.BlockEvent{
+		Event:          eventType,
+		IP:             peer.IP,
+		PeerID:         peer.PeerID,
+		ClientName:     peerid.GetNameWithVersion(peer.PeerID),
+		Reason:         result.Reason,
+		DownloadSpeed:  peer.DownloadSpeed,
+		UploadSpeed:    peer.UploadSpeed,
+		ShareRatio:     result.ShareRatio,
+		InfoHash:       torrent.Download.InfoHash,
+		TorrentName:    torrent.Name(),
+		Instance:       inst.Name,
+		PeerUploaded:   result.PeerUploaded,
+		PeerDownloaded: result.PeerDownloaded,
+
+		LifetimeShareRatio: result.LifetimeShareRatio,
+		RatioConfidence:    result.RatioConfidence,
+
+		ChokedUpload:  result.ChokedUpload,
+		CreditBalance: int64(result.Credit),
+
+		PeerIDScore:     result.PeerIDScore,
+		PeerIDAnomalies: result.PeerIDAnomalies,
+	}
+	if result.BlockDuration > 0 {
+		event.Duration = detector.FormatDuration(result.BlockDuration)
+	}
+	if result.ChokedFor > 0 {
+		event.ChokedFor = result.ChokedFor.String()
+	}
+	return event
+}
+
+// forgive logs that a peer's violations were reset after it behaved again
+func (d *daemon) forgive(result *detector.DetectionResult, inst *instance) {
+	peer := result.Peer
+	event := logger.BlockEvent{
+		Event:      logger.EventForgiven,
+		IP:         peer.IP,
+		PeerID:     peer.PeerID,
+		ClientName: peerid.GetNameWithVersion(peer.PeerID),
+		Reason:     result.Reason,
+		ShareRatio: result.ShareRatio,
+		Violations: result.Violations,
+		Instance:   inst.Name,
+
+		LifetimeShareRatio: result.LifetimeShareRatio,
+		RatioConfidence:    result.RatioConfidence,
+	}
+	if last := d.bans.Forget(peer.IP); last != nil {
+		event.BanID = last.ID
+	}
+
+	inst.log.Infof("Forgave %s after %d violations (share_ratio: %.4f)", peer.IP, result.Violations, result.ShareRatio)
+	d.logLifecycleEvent(event)
+}
+
+// forget logs that the violation ledger dropped IPs whose violations
+// decayed to nothing or are older than forget_after
+func (d *daemon) forget(ips []string) {
+	for _, ip := range ips {
+		event := logger.BlockEvent{
+			Event: logger.EventForgotten,
+			IP:    ip,
+		}
+		if last := d.bans.Forget(ip); last != nil {
+			event.BanID = last.ID
+			event.PeerID = last.PeerID
+			event.ClientName = last.ClientName
+			event.Instance = last.Instance
+		}
+
+		d.log.Infof("Forgot the violations of %s", ip)
+		d.logLifecycleEvent(event)
+	}
+}
+
+// checkInterval returns how often bans are checked: the poll interval of
+// the most frequently polled instance
+func (d *daemon) checkInterval() time.Duration {
+	interval := d.instances[0].PollInterval
+	for _, inst := range d.instances[1:] {
+		if inst.PollInterval < interval {
+			interval = inst.PollInterval
+		}
+	}
+	return interval
+}
+
+// checkBans logs bans that expired or were removed from the firewall by hand
+func (d *daemon) checkBans() {
+	now := time.Now()
+	for _, ban := range d.bans.Expire(now) {
+		d.log.Infof("Ban %s of %s expired after %s", ban.ID, ban.IP, ban.BannedFor(now))
+		d.logLifecycleEvent(banEndEvent(logger.EventExpired, ban))
+	}
+
+	if len(d.bans.Active()) == 0 {
+		return
+	}
+
+	blocked, err := d.firewall.ListBlocked()
+	if err != nil {
+		d.log.Errorf("Failed to list blocked IPs: %v", err)
+		return
+	}
+	present := make(map[string]bool, len(blocked))
+	for _, ip := range blocked {
+		present[ip] = true
+	}
+
+	// nftables starts the timeout a moment before the ban is recorded, allow
+	// a check interval for the element to time out first
+	for _, ban := range d.bans.Reconcile(present, now, d.checkInterval()) {
+		// Removed outside of aria2bango, treat it as an intentional pardon
+		d.detector.ResetViolations(ban.IP)
+		d.log.Infof("Ban %s of %s was removed from nftables manually", ban.ID, ban.IP)
+		d.logLifecycleEvent(banEndEvent(logger.EventUnblockedManual, ban))
+	}
+}
+
+// logEvent writes a lifecycle event to the block log
+func (d *daemon) logEvent(event logger.BlockEvent) {
+	if err := d.blockLog.Log(event); err != nil {
+		d.log.Errorf("Failed to log %s event: %v", event.Event, err)
+	}
+}
+
+// logLifecycleEvent logs an event that follows a ban. Dry-run mode only
+// logs would_block: hypothetical bans must not show up as expired or
+// forgiven, or history and backfill would take them for real ones.
+func (d *daemon) logLifecycleEvent(event logger.BlockEvent) {
+	if d.cfg.Blocking.DryRun {
+		d.log.Debugf("Dry run, not logging %s event of %s", event.Event, event.IP)
+		return
+	}
+	d.logEvent(event)
+}
+
+// banEndEvent builds the event logged when a ban ends
+func banEndEvent(eventType string, ban *bans.Ban) logger.BlockEvent {
+	return logger.BlockEvent{
+		Timestamp:       ban.Ended,
+		Event:           eventType,
+		IP:              ban.IP,
+		PeerID:          ban.PeerID,
+		ClientName:      ban.ClientName,
+		Reason:          ban.Reason,
+		Duration:        detector.FormatDuration(ban.Duration),
+		InfoHash:        ban.InfoHash,
+		TorrentName:     ban.Torrent,
+		Instance:        ban.Instance,
+		BanID:           ban.ID,
+		Violations:      ban.Violations,
+		BannedFor:       ban.BannedFor(ban.Ended).String(),
+		BytesDownloaded: ban.BytesDownloaded,
+		BytesUploaded:   ban.BytesUploaded,
+	}
+}
+
+// unblock removes a ban before it expires and clears the peer's violations
+func (d *daemon) unblock(ip string, reason string) (*bans.Ban, error) {
+	ban := d.bans.Get(ip)
+	if ban == nil {
+		return nil, fmt.Errorf("%s: %w", ip, errNotBanned)
+	}
+
+	if err := d.firewall.UnblockIP(ip); err != nil {
+		return nil, err
+	}
+	ban = d.bans.End(ip, time.Now())
+	if ban == nil {
+		// Expired concurrently, the poll loop logs it
+		return nil, fmt.Errorf("%s: %w", ip, errNotBanned)
+	}
+	d.detector.ResetViolations(ip)
+
+	d.log.Infof("Ban %s of %s removed manually (reason: %s)", ban.ID, ip, reason)
+	event := banEndEvent(logger.EventUnblockedManual, ban)
+	if reason != "" {
+		event.Reason = reason
+	}
+	d.logLifecycleEvent(event)
+	return ban, nil
+}
+
+// backfill restores violation counts and ban links from the block log, so
+// repeat offenders keep escalating across restarts
+func (d *daemon) backfill() {
+	lookback := d.cfg.Detection.Backfill.Lookback
+	since := time.Time{}
+	if lookback > 0 {
+		since = time.Now().Add(-lookback)
+	}
+
+	var events []logger.BlockEvent
+	stats, err := logger.ReadEvents(d.cfg.Logging.File, since, func(event logger.BlockEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		d.log.Warnf("Backfill from block log failed: %v", err)
+		return
+	}
+
+	restored := detector.Backfill(events)
+	now := time.Now()
+	maxViolations, resumed := 0, 0
+	for i := range restored {
+		h := &restored[i]
+		if h.Violations > maxViolations {
+			maxViolations = h.Violations
+		}
+		if h.BlockedUntil.After(now) {
+			if d.resume(h) {
+				resumed++
+				continue
+			}
+			// Not in the firewall, the peer is judged again right away
+			h.BlockedUntil = now
+		}
+		if h.LastBanID != "" {
+			d.bans.RestoreLast(bans.Ban{ID: h.LastBanID, IP: h.IP, Start: h.LastBlocked, Ended: h.BlockedUntil})
+		}
+	}
+	d.detector.RestoreViolations(restored)
+
+	d.log.Infof("Backfill: read %d events from %d block log files (%d unparseable lines) since %s, restored violations of %d IPs (max %d) and %d running bans",
+		stats.Events, stats.Files, stats.Skipped, formatSince(since), len(restored), maxViolations, resumed)
+	for _, h := range restored {
+		d.log.Debugf("Backfill: %s has %d violations, last blocked %s", h.IP, h.Violations, h.LastBlocked.Format(time.RFC3339))
+	}
+}
+
+// resume puts a ban from before the restart that has not expired yet back
+// into the firewall, since the firewall is emptied on exit. Dry-run mode
+// leaves it to be judged again. It returns false if the ban was not resumed.
+func (d *daemon) resume(h *detector.ViolationHistory) bool {
+	if d.cfg.Blocking.DryRun || h.LastBanID == "" {
+		return false
+	}
+
+	fwDuration := time.Until(h.BlockedUntil)
+	if h.Duration == detector.Permanent {
+		fwDuration = 0
+	}
+	if err := d.firewall.BlockIP(h.IP, fwDuration); err != nil {
+		d.log.Errorf("Failed to restore ban %s of %s: %v", h.LastBanID, h.IP, err)
+		return false
+	}
+
+	event := h.LastBan
+	d.bans.Resume(bans.Ban{
+		ID:         h.LastBanID,
+		IP:         h.IP,
+		PeerID:     event.PeerID,
+		ClientName: event.ClientName,
+		Reason:     event.Reason,
+		InfoHash:   event.InfoHash,
+		Torrent:    event.TorrentName,
+		Instance:   event.Instance,
+		Violations: h.Violations,
+		Duration:   h.Duration,
+		Start:      h.LastBlocked,
+		Expires:    h.BlockedUntil,
+	})
+	d.log.Infof("Restored ban %s of %s (reason: %s, duration: %s)", h.LastBanID, h.IP, event.Reason, detector.FormatDuration(h.Duration))
+	return true
+}
+
+// formatSince formats the start of a lookback window for logging
+func formatSince(since time.Time) string {
+	if since.IsZero() {
+		return "the beginning"
+	}
+	return since.Format(time.RFC3339)
+}
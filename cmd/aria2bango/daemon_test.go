@@ -0,0 +1,244 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/bans"
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/detector"
+	"github.com/lbl1m/aria2bango/internal/firewall"
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// newTestDaemon creates a daemon with an in-memory firewall and a block
+// log in a temporary directory, and its single aria2 instance
+func newTestDaemon(t *testing.T, dryRun bool) (*daemon, *instance) {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Blocking.DryRun = dryRun
+	cfg.Logging.File = filepath.Join(t.TempDir(), "blocked.log")
+	cfg.Detection.Behavior.MinObservation = 0
+	cfg.Detection.Behavior.MinConfidence = 0
+
+	blockLog, err := logger.NewLogger(&cfg.Logging)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { blockLog.Close() })
+
+	d := &daemon{
+		cfg:      cfg,
+		detector: detector.NewDetector(&cfg.Detection),
+		firewall: firewall.NewMemoryFirewall(),
+		blockLog: blockLog,
+		bans:     bans.NewTracker(),
+		log:      zap.NewNop().Sugar(),
+	}
+	instances, err := cfg.Instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst := d.newInstance(instances[0])
+	d.instances = []*instance{inst}
+	return d, inst
+}
+
+// testTorrent is a torrent with a leecher taking 20MB per poll while
+// giving nothing back
+func testTorrent() *aria2.TorrentPeers {
+	torrent := &aria2.TorrentPeers{Peers: []aria2.Peer{
+		{IP: "10.0.0.1", PeerID: "-XL0019-abcdefghijkl", UploadSpeed: 20 << 20},
+	}}
+	torrent.Download.InfoHash = "abcdef"
+	return torrent
+}
+
+// detectAll runs the detector over a torrent and acts on the results
+func detectAll(d *daemon, inst *instance, torrent *aria2.TorrentPeers) {
+	for _, peer := range torrent.Peers {
+		if result := inst.detector.Detect(peer, d.cfg.Blocking.BaseDuration); result != nil {
+			d.act(result, inst, torrent)
+		}
+	}
+}
+
+// readEvents returns the events written to the block log
+func readEvents(t *testing.T, d *daemon) []logger.BlockEvent {
+	t.Helper()
+	var events []logger.BlockEvent
+	_, err := logger.ReadEvents(d.cfg.Logging.File, time.Time{}, func(event logger.BlockEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// eventTypes returns the types of events in order
+func eventTypes(events []logger.BlockEvent) []string {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Event)
+	}
+	return types
+}
+
+func TestDryRun(t *testing.T) {
+	d, inst := newTestDaemon(t, true)
+	torrent := testTorrent()
+	peer := torrent.Peers[0]
+	detectAll(d, inst, torrent)
+
+	// A ban removed from the firewall by hand
+	d.firewall.UnblockIP(peer.IP)
+	d.checkBans()
+
+	// A repeat offence, then expiry and forgiveness
+	d.act(&detector.DetectionResult{
+		Action:        detector.ActionBlock,
+		Peer:          peer,
+		Reason:        "low_share_ratio",
+		Violations:    2,
+		BlockDuration: time.Nanosecond,
+	}, inst, torrent)
+	time.Sleep(time.Millisecond)
+	d.checkBans()
+	d.act(&detector.DetectionResult{Action: detector.ActionForgive, Peer: peer, Violations: 2}, inst, torrent)
+
+	events := readEvents(t, d)
+	if got, want := eventTypes(events), []string{logger.EventWouldBlock, logger.EventWouldBlock}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Events = %v, want %v", got, want)
+	}
+	if events[1].PreviousBanID == "" || events[1].PreviousBanID != events[0].BanID {
+		t.Errorf("Expected the repeat ban to link to %s, got %q", events[0].BanID, events[1].PreviousBanID)
+	}
+
+	// Hypothetical bans are not restored as violations
+	restarted, _ := newTestDaemon(t, true)
+	restarted.cfg.Logging.File = d.cfg.Logging.File
+	restarted.backfill()
+	if n := restarted.detector.GetViolationCount(peer.IP); n != 0 {
+		t.Errorf("Expected backfill to ignore would_block, got %d violations", n)
+	}
+
+	// Unlike real ones
+	live, liveInst := newTestDaemon(t, false)
+	detectAll(live, liveInst, testTorrent())
+	if got := eventTypes(readEvents(t, live)); !reflect.DeepEqual(got, []string{logger.EventBlocked}) {
+		t.Fatalf("Events = %v, want [blocked]", got)
+	}
+	restarted.cfg.Logging.File = live.cfg.Logging.File
+	restarted.backfill()
+	if n := restarted.detector.GetViolationCount(peer.IP); n != 1 {
+		t.Errorf("Expected backfill to restore 1 violation of blocked, got %d", n)
+	}
+}
+
+func TestBackfillResumesBans(t *testing.T) {
+	d, inst := newTestDaemon(t, false)
+	now := time.Now()
+	for _, event := range []logger.BlockEvent{
+		{Timestamp: now.Add(-2 * time.Hour), Event: logger.EventBlocked, IP: "10.0.0.1", Reason: "low_share_ratio", Duration: detector.FormatDuration(detector.Permanent), Violations: 3, BanID: "p1"},
+		{Timestamp: now.Add(-5 * time.Minute), Event: logger.EventBlocked, IP: "10.0.0.2", Reason: "low_share_ratio", Duration: "1h0m0s", Violations: 1, BanID: "r1"},
+		{Timestamp: now.Add(-2 * time.Hour), Event: logger.EventBlocked, IP: "10.0.0.3", Reason: "low_share_ratio", Duration: "1h0m0s", Violations: 1, BanID: "e1"},
+	} {
+		if err := d.blockLog.Log(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.backfill()
+
+	blocked, _ := d.firewall.ListBlocked()
+	sort.Strings(blocked)
+	if !reflect.DeepEqual(blocked, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Expected running bans back in the firewall, got %v", blocked)
+	}
+	if ban := d.bans.Get("10.0.0.1"); ban == nil || ban.ID != "p1" || ban.Duration != detector.Permanent {
+		t.Errorf("Expected permanent ban p1 to be active, got %+v", ban)
+	}
+	if ban := d.bans.Get("10.0.0.3"); ban != nil {
+		t.Errorf("Expected expired ban to stay ended, got %+v", ban)
+	}
+
+	// Resumed bans are not judged again, ended ones are and escalate
+	peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-XL0019-abcdefghijkl", UploadSpeed: 20 << 20}
+	if result := inst.detector.Detect(peer, d.cfg.Blocking.BaseDuration); result != nil {
+		t.Errorf("Expected no result for a resumed ban, got %+v", result)
+	}
+	peer.IP = "10.0.0.3"
+	if result := inst.detector.Detect(peer, d.cfg.Blocking.BaseDuration); result == nil || result.Violations != 2 {
+		t.Errorf("Expected a second violation, got %+v", result)
+	}
+
+	// Ending a resumed ban is logged under its ID
+	if _, err := d.unblock("10.0.0.1", ""); err != nil {
+		t.Fatal(err)
+	}
+	events := readEvents(t, d)
+	if last := events[len(events)-1]; last.Event != logger.EventUnblockedManual || last.BanID != "p1" {
+		t.Errorf("Unexpected end event %+v", last)
+	}
+
+	// Dry-run mode leaves the firewall alone
+	dry, _ := newTestDaemon(t, true)
+	dry.cfg.Logging.File = d.cfg.Logging.File
+	dry.backfill()
+	if blocked, _ := dry.firewall.ListBlocked(); len(blocked) != 0 {
+		t.Errorf("Expected no bans restored in dry-run mode, got %v", blocked)
+	}
+}
+
+func TestObserveSubSecondPoll(t *testing.T) {
+	d, inst := newTestDaemon(t, false)
+	detectAll(d, inst, testTorrent())
+
+	inst.PollInterval = 1500 * time.Millisecond
+	d.observe(inst, aria2.Peer{IP: "10.0.0.1", DownloadSpeed: 1000, UploadSpeed: 10})
+	inst.PollInterval = 500 * time.Millisecond
+	d.observe(inst, aria2.Peer{IP: "10.0.0.1", DownloadSpeed: 1000, UploadSpeed: 10})
+
+	ban := d.bans.Get("10.0.0.1")
+	if ban.BytesDownloaded != 2000 || ban.BytesUploaded != 20 {
+		t.Errorf("Expected 2000/20 bytes observed, got %d/%d", ban.BytesDownloaded, ban.BytesUploaded)
+	}
+}
+
+func TestForgottenViolations(t *testing.T) {
+	d, inst := newTestDaemon(t, false)
+	torrent := testTorrent()
+	detectAll(d, inst, torrent)
+
+	// The ban ends, a month later the ledger drops the IP
+	later := time.Now().Add(d.cfg.Detection.Violations.ForgetAfter + time.Hour)
+	d.bans.Expire(later)
+	d.detector.SetClock(func() time.Time { return later })
+	d.forget(d.detector.PruneViolations())
+
+	events := readEvents(t, d)
+	if got, want := eventTypes(events), []string{logger.EventBlocked, logger.EventForgotten}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Events = %v, want %v", got, want)
+	}
+	if events[1].BanID != events[0].BanID {
+		t.Errorf("Expected forgotten to link to ban %s, got %q", events[0].BanID, events[1].BanID)
+	}
+
+	// Backfill does not bring the forgotten violation back
+	restarted, _ := newTestDaemon(t, false)
+	restarted.cfg.Logging.File = d.cfg.Logging.File
+	restarted.backfill()
+	if n := restarted.detector.GetViolationCount(torrent.Peers[0].IP); n != 0 {
+		t.Errorf("Expected backfill to honour forgotten, got %d violations", n)
+	}
+	if blocked, _ := restarted.firewall.ListBlocked(); len(blocked) != 0 {
+		t.Errorf("Expected no resumed bans, got %v", blocked)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/history"
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// historyUsage is printed for "aria2bango history -h"
+const historyUsage = `Usage: aria2bango history [flags]
+
+Query the ban history database.
+
+Examples:
+  aria2bango history -ip 192.168.1.0/24 -since 7d
+  aria2bango history -client xunlei -reason low_share_ratio -format csv
+  aria2bango history -client qbittorrent -version ">=4.0 <4.4"
+  aria2bango history -aggregate daily -since 30d
+  aria2bango history -aggregate top -top 20 -format json
+  aria2bango history -aggregate daily -dry-run
+
+Flags:
+`
+
+// runHistory implements the history subcommand
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), historyUsage)
+		fs.PrintDefaults()
+	}
+
+	cfgPath := fs.String("config", "/etc/aria2bango/config.yaml", "Path to configuration file")
+	dbPath := fs.String("db", "", "History database path (default: history.path from config)")
+	ip := fs.String("ip", "", "Filter by IP or CIDR")
+	client := fs.String("client", "", "Filter by client name (substring, case-insensitive)")
+	clientVersion := fs.String("version", "", "Filter by client version range, e.g. \">=4.0 <4.4\" or 2.x")
+	reason := fs.String("reason", "", "Filter by reason")
+	events := fs.String("event", "", "Filter by event types, comma-separated (default: all)")
+	torrent := fs.String("torrent", "", "Filter by info hash prefix or torrent name substring")
+	instance := fs.String("instance", "", "Filter by aria2 instance name")
+	banID := fs.String("ban", "", "Filter by ban ID")
+	since := fs.String("since", "", "Start of time range: duration before now (24h, 7d) or date (2006-01-02, RFC 3339)")
+	until := fs.String("until", "", "End of time range (exclusive), same formats as -since; a duration is also before now (-until 7d: up to 7 days ago)")
+	limit := fs.Int("limit", 0, "Maximum number of events (0 = no limit)")
+	format := fs.String("format", "table", "Output format: table, json or csv")
+	aggregate := fs.String("aggregate", "", "Aggregate instead of listing events: daily or top")
+	top := fs.Int("top", 10, "Number of offenders for -aggregate top")
+	dryRun := fs.Bool("dry-run", false, "Count would_block events of dry-run mode as bans in aggregates")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := *dbPath
+	if path == "" {
+		cfg, err := config.LoadWithoutSecrets(*cfgPath)
+		if err != nil {
+			return fmt.Errorf("failed to load config (use -db to skip it): %w", err)
+		}
+		path = cfg.History.Path
+	}
+
+	store, err := history.OpenExisting(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	filter := history.Filter{
+		IP:       *ip,
+		Client:   *client,
+		Version:  *clientVersion,
+		Reason:   *reason,
+		Torrent:  *torrent,
+		Instance: *instance,
+		BanID:    *banID,
+		Limit:    *limit,
+	}
+	if *events != "" {
+		filter.Events = strings.Split(*events, ",")
+	}
+	if filter.Since, err = parseTimeArg(*since, now); err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+	if filter.Until, err = parseTimeArg(*until, now); err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+
+	switch *aggregate {
+	case "":
+	case "daily", "top":
+		// Aggregates need the whole range
+		filter.Limit = 0
+	default:
+		return fmt.Errorf("unknown aggregate %q (want daily or top)", *aggregate)
+	}
+
+	result, err := store.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	switch *aggregate {
+	case "daily":
+		return writeDaily(out, *format, history.BansPerDay(result, *dryRun))
+	case "top":
+		return writeTop(out, *format, history.TopOffenders(result, *top, *dryRun))
+	default:
+		return writeEvents(out, *format, result)
+	}
+}
+
+// parseTimeArg parses "" (zero time), a duration before now such as 24h
+// or 7d, a date or an RFC 3339 timestamp
+func parseTimeArg(arg string, now time.Time) (time.Time, error) {
+	if arg == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(arg, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(arg, "d")); err == nil {
+			if days < 0 {
+				return time.Time{}, fmt.Errorf("invalid time %q: duration must not be negative", arg)
+			}
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(arg); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("invalid time %q: duration must not be negative", arg)
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", arg, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, arg)
+}
+
+// eventColumns are the columns of table and CSV event output
+var eventColumns = []string{"timestamp", "event", "ip", "client_name", "reason", "duration", "violations", "share_ratio", "banned_for", "torrent", "ban_id"}
+
+// eventRow formats an event for table and CSV output
+func eventRow(e logger.BlockEvent) []string {
+	torrent := e.TorrentName
+	if torrent == "" {
+		torrent = e.InfoHash
+	}
+	return []string{
+		e.Timestamp.Local().Format(time.RFC3339),
+		e.Event,
+		e.IP,
+		e.ClientName,
+		e.Reason,
+		e.Duration,
+		strconv.Itoa(e.Violations),
+		strconv.FormatFloat(e.ShareRatio, 'f', 4, 64),
+		e.BannedFor,
+		torrent,
+		e.BanID,
+	}
+}
+
+// writeEvents writes events in the requested format
+func writeEvents(w io.Writer, format string, events []logger.BlockEvent) error {
+	rows := make([][]string, 0, len(events))
+	for _, e := range events {
+		rows = append(rows, eventRow(e))
+	}
+	if events == nil {
+		events = []logger.BlockEvent{}
+	}
+	return writeOutput(w, format, events, eventColumns, rows)
+}
+
+// writeDaily writes bans per day in the requested format
+func writeDaily(w io.Writer, format string, days []history.DayCount) error {
+	rows := make([][]string, 0, len(days))
+	for _, d := range days {
+		rows = append(rows, []string{d.Day, strconv.Itoa(d.Bans), strconv.Itoa(d.IPs)})
+	}
+	return writeOutput(w, format, days, []string{"day", "bans", "ips"}, rows)
+}
+
+// writeTop writes the top offenders in the requested format
+func writeTop(w io.Writer, format string, offenders []history.Offender) error {
+	rows := make([][]string, 0, len(offenders))
+	for _, o := range offenders {
+		rows = append(rows, []string{
+			o.IP,
+			strconv.Itoa(o.Bans),
+			strconv.Itoa(o.MaxViolations),
+			o.TotalBanned.String(),
+			o.LastClientName,
+			o.LastReason,
+			o.LastBan.Local().Format(time.RFC3339),
+		})
+	}
+	columns := []string{"ip", "bans", "max_violations", "total_banned", "last_client_name", "last_reason", "last_ban"}
+	return writeOutput(w, format, offenders, columns, rows)
+}
+
+// writeOutput writes v as JSON, or rows as CSV or an aligned table
+func writeOutput(w io.Writer, format string, v interface{}, columns []string, rows [][]string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return err
+		}
+		return cw.WriteAll(rows)
+
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		if _, err := fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t"))); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+				return err
+			}
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unknown format %q (want table, json or csv)", format)
+	}
+}
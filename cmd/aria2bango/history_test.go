@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeArg(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		arg  string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2024-01-10T08:00:00Z", time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeArg(tt.arg, now)
+		if err != nil {
+			t.Errorf("parseTimeArg(%q) failed: %v", tt.arg, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTimeArg(%q): expected %s, got %s", tt.arg, tt.want, got)
+		}
+	}
+
+	for _, arg := range []string{"-3d", "-24h", "soon"} {
+		if _, err := parseTimeArg(arg, now); err == nil {
+			t.Errorf("Expected error for %q", arg)
+		}
+	}
+}
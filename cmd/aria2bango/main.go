@@ -7,30 +7,54 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
-	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/bans"
 	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/control"
 	"github.com/lbl1m/aria2bango/internal/detector"
 	"github.com/lbl1m/aria2bango/internal/firewall"
+	"github.com/lbl1m/aria2bango/internal/history"
 	"github.com/lbl1m/aria2bango/internal/logger"
+	"github.com/lbl1m/aria2bango/internal/notify"
 	"github.com/lbl1m/aria2bango/internal/peerid"
+	"github.com/lbl1m/aria2bango/internal/trace"
 )
 
 var (
 	configPath  = flag.String("config", "/etc/aria2bango/config.yaml", "Path to configuration file")
 	cleanupMode = flag.Bool("cleanup", false, "Cleanup nftables rules and exit")
+	dryRun      = flag.Bool("dry-run", false, "Log would_block decisions without touching nftables")
 	version     = "dev"
 )
 
+// subcommands run instead of the daemon when named as the first argument
+var subcommands = map[string]func(args []string) error{
+	"history":  runHistory,
+	"simulate": runSimulate,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				if err != flag.ErrHelp {
+					fmt.Fprintf(os.Stderr, "aria2bango %s: %v\n", os.Args[1], err)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
-	// Setup logger
+	// Setup a bootstrap logger, replaced once the configuration is loaded
 	zapConfig := zap.NewProductionConfig()
 	zapConfig.EncoderConfig.TimeKey = "timestamp"
 	zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -41,6 +65,7 @@ func main() {
 	}
 	defer zapLogger.Sync()
 	log := zapLogger.Sugar()
+	logLevel := zapConfig.Level
 
 	// Cleanup mode
 	if *cleanupMode {
@@ -58,24 +83,88 @@ func main() {
 	if err != nil {
 		log.Warnf("Failed to load config from %s, using defaults: %v", *configPath, err)
 		cfg = config.DefaultConfig()
+		// Environment and secret file overrides still apply to the defaults
+		if err := cfg.ApplyOverrides(); err != nil {
+			log.Fatalf("Failed to apply config overrides: %v", err)
+		}
+	}
+
+	// Setup the operational logger as configured
+	opLogger, opLevel, err := logger.NewOperational(&cfg.Logging)
+	if err != nil {
+		log.Warnf("Failed to setup logging as configured, using stderr: %v", err)
+	} else {
+		defer opLogger.Sync()
+		log = opLogger.Sugar()
+		logLevel = opLevel
 	}
 
 	// Initialize components
-	aria2Client := aria2.NewClient(cfg.Aria2.Host, cfg.Aria2.Port, cfg.Aria2.Secret)
+	instances, err := cfg.Instances()
+	if err != nil {
+		log.Fatalf("Invalid aria2 instances: %v", err)
+	}
+	if cfg.Detection.ClientDatabase != "" {
+		n, err := loadClientDatabase(cfg.Detection.ClientDatabase)
+		if err != nil {
+			log.Fatalf("Failed to load client database: %v", err)
+		}
+		log.Infof("Loaded %d clients from %s", n, cfg.Detection.ClientDatabase)
+	}
+	for _, inst := range instances {
+		if err := detector.Check(inst.Detection); err != nil {
+			if inst.Name != "" {
+				log.Fatalf("Invalid detection settings of aria2 instance %s: %v", inst.Name, err)
+			}
+			log.Fatalf("Invalid detection settings: %v", err)
+		}
+	}
 	det := detector.NewDetector(&cfg.Detection)
-
-	// Initialize nftables manager
-	nftMgr, err := firewall.NewNftablesManager(cfg.Blocking.NftTable)
+	if err := det.LoadCredits(); err != nil {
+		log.Fatalf("Failed to load credits: %v", err)
+	}
+	rules, err := detector.NewClientRules(cfg.Detection.ClientRules)
 	if err != nil {
-		log.Fatalf("Failed to initialize nftables: %v", err)
+		log.Fatalf("Invalid client rules: %v", err)
 	}
-	defer func() {
-		log.Info("Cleaning up nftables rules...")
-		if err := nftMgr.Destroy(); err != nil {
-			log.Errorf("Failed to cleanup nftables: %v", err)
+	det.SetClientRules(rules)
+	if rules.Len() > 0 {
+		log.Infof("Loaded %d client rules", rules.Len())
+	}
+	punishment, err := detector.NewPunishment(cfg.Blocking.Punishment)
+	if err != nil {
+		log.Fatalf("Invalid punishment policy: %v", err)
+	}
+	det.SetPunishment(punishment)
+
+	// Initialize the firewall. Dry-run mode keeps bans in memory only, so
+	// it needs no root.
+	if *dryRun {
+		cfg.Blocking.DryRun = true
+	}
+	var fw firewall.Firewall
+	if cfg.Blocking.DryRun {
+		log.Warn("Dry-run mode: decisions are logged as would_block, nftables is not touched")
+		fw = firewall.NewMemoryFirewall()
+	} else {
+		// The rate limiting rules are only installed when something throttles
+		throttleRate := cfg.Blocking.ThrottleRate
+		if !cfg.Detection.UsesThrottle() {
+			throttleRate = 0
 		}
-		nftMgr.Close()
-	}()
+		nftMgr, err := firewall.NewNftablesManager(cfg.Blocking.NftTable, throttleRate)
+		if err != nil {
+			log.Fatalf("Failed to initialize nftables: %v", err)
+		}
+		defer func() {
+			log.Info("Cleaning up nftables rules...")
+			if err := nftMgr.Destroy(); err != nil {
+				log.Errorf("Failed to cleanup nftables: %v", err)
+			}
+			nftMgr.Close()
+		}()
+		fw = nftMgr
+	}
 
 	// Initialize logger
 	blockLogger, err := logger.NewLogger(&cfg.Logging)
@@ -84,25 +173,153 @@ func main() {
 	}
 	defer blockLogger.Close()
 
+	// Forward block events to journald
+	if cfg.Logging.Journald.Enabled {
+		sink, err := logger.NewJournalSink(cfg.Logging.Journald.Socket)
+		if err != nil {
+			log.Warnf("Failed to connect block log to journald: %v", err)
+		} else {
+			blockLogger.AddSink(sink)
+		}
+	}
+
+	// Forward block events to a syslog collector
+	if cfg.Logging.Syslog.Enabled {
+		sink, err := logger.NewSyslogSink(cfg.Logging.Syslog)
+		if err != nil {
+			log.Fatalf("Failed to initialize syslog output: %v", err)
+		}
+		blockLogger.AddSink(sink)
+		log.Infof("Sending block events to syslog %s://%s", cfg.Logging.Syslog.Network, cfg.Logging.Syslog.Address)
+	}
+
+	// Record block events in the history database
+	if cfg.History.Enabled {
+		store, err := history.Open(cfg.History.Path)
+		if err != nil {
+			log.Warnf("Failed to open ban history, history disabled: %v", err)
+		} else {
+			blockLogger.AddSink(history.NewSink(store, func(err error) {
+				log.Warnf("History: %v", err)
+			}))
+		}
+	}
+
+	// Deliver block events to webhooks
+	if cfg.Notifications.Enabled {
+		notifier, err := notify.New(&cfg.Notifications, func(err error) {
+			log.Warnf("Notification: %v", err)
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize notifications: %v", err)
+		}
+		blockLogger.AddSink(notifier)
+		log.Infof("Notifications enabled for %d webhooks (%d queued for retry)", len(cfg.Notifications.Webhooks), notifier.Pending())
+	}
+
+	// Record raw aria2 polls for later replay
+	var traceWriter *trace.Writer
+	if cfg.Trace.Enabled {
+		traceWriter, err = trace.NewWriter(cfg.Trace)
+		if err != nil {
+			log.Warnf("Failed to open trace file, tracing disabled: %v", err)
+		} else {
+			defer traceWriter.Close()
+			log.Infof("Recording aria2 polls to %s", cfg.Trace.Path)
+		}
+	}
+
 	log.Infof("aria2bango %s started", version)
-	log.Infof("Monitoring aria2 at %s:%d", cfg.Aria2.Host, cfg.Aria2.Port)
-	log.Infof("Base block duration: %s (cumulative punishment enabled)", cfg.Blocking.BaseDuration)
+	for _, inst := range instances {
+		if inst.Name == "" {
+			log.Infof("Monitoring aria2 at %s", inst.Addr())
+			continue
+		}
+		log.Infof("Monitoring aria2 instance %s at %s every %s", inst.Name, inst.Addr(), inst.PollInterval)
+	}
+	log.Infof("Base block duration: %s (%s punishment)", cfg.Blocking.BaseDuration, cfg.Blocking.Punishment.Policy)
 
 	// Setup signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGHUP)
 
 	go func() {
-		sig := <-sigChan
-		log.Infof("Received signal %v, shutting down...", sig)
-		cancel()
+		for sig := range sigChan {
+			// SIGUSR1: reopen the block log after logrotate moved it away
+			if sig == syscall.SIGUSR1 {
+				log.Info("Received SIGUSR1, reopening block log")
+				if err := blockLogger.Reopen(); err != nil {
+					log.Errorf("Failed to reopen block log, still writing to the previous file: %v", err)
+				}
+				continue
+			}
+			// SIGHUP: reload the client database, keeping the old one on error
+			if sig == syscall.SIGHUP {
+				if cfg.Detection.ClientDatabase == "" {
+					continue
+				}
+				n, err := loadClientDatabase(cfg.Detection.ClientDatabase)
+				if err != nil {
+					log.Errorf("Failed to reload client database: %v", err)
+					continue
+				}
+				log.Infof("Reloaded %d clients from %s", n, cfg.Detection.ClientDatabase)
+				continue
+			}
+			log.Infof("Received signal %v, shutting down...", sig)
+			cancel()
+			return
+		}
 	}()
 
-	// Main monitoring loop
-	ticker := time.NewTicker(cfg.Aria2.PollInterval)
+	d := &daemon{
+		cfg:      cfg,
+		detector: det,
+		firewall: fw,
+		blockLog: blockLogger,
+		bans:     bans.NewTracker(),
+		trace:    traceWriter,
+		log:      log,
+	}
+	for _, inst := range instances {
+		d.instances = append(d.instances, d.newInstance(inst))
+	}
+
+	// Restore violation counts lost with the previous process
+	if cfg.Detection.Backfill.Enabled {
+		d.backfill()
+	}
+
+	// Start the control API
+	if cfg.Control.Enabled {
+		srv, err := control.NewServer(cfg.Control.Listen)
+		if err != nil {
+			log.Fatalf("Failed to start control API: %v", err)
+		}
+		d.registerAPI(srv, logLevel)
+		go func() {
+			if err := srv.Serve(); err != nil {
+				log.Errorf("Control API stopped: %v", err)
+			}
+		}()
+		defer srv.Close()
+		log.Infof("Control API listening on %s", srv.Addr())
+	}
+
+	// Poll every instance on its own, checking bans as often as the most
+	// frequent one
+	var wg sync.WaitGroup
+	for _, inst := range d.instances {
+		wg.Add(1)
+		go func(inst *instance) {
+			defer wg.Done()
+			d.run(ctx, inst)
+		}(inst)
+	}
+	ticker := time.NewTicker(d.checkInterval())
 	defer ticker.Stop()
 
 	// Periodic cleanup of stale peer stats
@@ -113,19 +330,40 @@ func main() {
 		select {
 		case <-ctx.Done():
 			log.Info("Shutting down...")
+			wg.Wait()
+			if err := det.SaveCredits(); err != nil {
+				log.Errorf("Failed to save credits: %v", err)
+			}
 			return
 
 		case <-cleanupTicker.C:
 			det.CleanupStaleStats(30 * time.Minute)
+			d.forget(det.PruneViolations())
+			if err := det.SaveCredits(); err != nil {
+				log.Errorf("Failed to save credits: %v", err)
+			}
+			// Ban links are kept as long as the violations they escalate
+			if forget := cfg.Detection.Violations.ForgetAfter; forget > 0 {
+				d.bans.Prune(forget)
+			}
 
 		case <-ticker.C:
-			if err := monitorPeers(ctx, aria2Client, det, nftMgr, blockLogger, cfg, log); err != nil {
-				log.Errorf("Error monitoring peers: %v", err)
-			}
+			d.checkBans()
 		}
 	}
 }
 
+// loadClientDatabase loads the external client database and makes peer
+// ID parsing use it
+func loadClientDatabase(path string) (int, error) {
+	db, err := peerid.LoadDatabase(path)
+	if err != nil {
+		return 0, err
+	}
+	peerid.SetDatabase(db)
+	return db.Len(), nil
+}
+
 func loadConfig(path string) (*config.Config, error) {
 	// Check if config file exists
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -134,50 +372,3 @@ func loadConfig(path string) (*config.Config, error) {
 
 	return config.Load(path)
 }
-
-func monitorPeers(ctx context.Context, aria2Client *aria2.Client, det *detector.Detector, nftMgr *firewall.NftablesManager, blockLogger *logger.Logger, cfg *config.Config, log *zap.SugaredLogger) error {
-	// Get all peers from active downloads
-	allPeers, err := aria2Client.GetAllPeers(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get peers: %w", err)
-	}
-
-	// Check each peer
-	for gid, peers := range allPeers {
-		for _, peer := range peers {
-			// Detect leecher behavior, pass base duration for cumulative punishment
-			result := det.Detect(peer, cfg.Blocking.BaseDuration)
-			if result == nil {
-				continue
-			}
-
-			// Block the peer with calculated duration (violations * base_duration)
-			if err := nftMgr.BlockIP(peer.IP, result.BlockDuration); err != nil {
-				log.Errorf("Failed to block IP %s: %v", peer.IP, err)
-				continue
-			}
-
-			log.Infof("Blocked %s (reason: %s, violations: %d, duration: %s, share_ratio: %.4f)",
-				peer.IP, result.Reason, result.Violations, result.BlockDuration, result.ShareRatio)
-
-			// Log the block event
-			if err := blockLogger.LogBlock(logger.BlockEvent{
-				IP:            peer.IP,
-				PeerID:        peer.PeerID,
-				ClientName:    peerid.GetNameWithVersion(peer.PeerID),
-				Reason:        result.Reason,
-				Duration:      result.BlockDuration.String(),
-				DownloadSpeed: peer.DownloadSpeed,
-				UploadSpeed:   peer.UploadSpeed,
-				ShareRatio:    result.ShareRatio,
-			}); err != nil {
-				log.Errorf("Failed to log block event: %v", err)
-			}
-
-			// Log gid for debugging
-			log.Debugf("Blocked peer from download %s", gid)
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/detector"
+	"github.com/lbl1m/aria2bango/internal/simulate"
+	"github.com/lbl1m/aria2bango/internal/trace"
+)
+
+// simulateUsage is printed for "aria2bango simulate -h"
+const simulateUsage = `Usage: aria2bango simulate -trace FILE [flags]
+
+Replay a recorded peer trace through the detector and show which IPs would
+be banned, when and for how long. Nothing is written to nftables.
+
+The config file is always replayed as scenario "current". Each -compare
+adds another config file, each -set adds a variant of the config file with
+fields overridden by their YAML path.
+
+Examples:
+  aria2bango simulate -trace /var/lib/aria2bango/trace.bin
+  aria2bango simulate -trace peers.jsonl
+  aria2bango simulate -trace peers.jsonl -set strict:detection.behavior.min_share_ratio=0.3
+  aria2bango simulate -trace peers.jsonl \
+    -set lenient:detection.behavior.min_share_ratio=0.05,detection.behavior.min_data_threshold=52428800 \
+    -compare /etc/aria2bango/candidate.yaml -format json
+
+Flags:
+`
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, " ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runSimulate implements the simulate subcommand
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), simulateUsage)
+		fs.PrintDefaults()
+	}
+
+	var compares, sets stringList
+	cfgPath := fs.String("config", "/etc/aria2bango/config.yaml", "Path to configuration file (defaults are used if it does not exist)")
+	tracePath := fs.String("trace", "", "Peer trace to replay: a trace recorded by the daemon or JSON lines of poll snapshots")
+	format := fs.String("format", "table", "Output format: table or json")
+	fs.Var(&compares, "compare", "Also replay with this config file (repeatable)")
+	fs.Var(&sets, "set", "Also replay with a variant NAME:KEY=VALUE[,KEY=VALUE...] of the config file (repeatable)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tracePath == "" {
+		fs.Usage()
+		return errors.New("-trace is required")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q (want table or json)", *format)
+	}
+
+	base, err := loadSimulateConfig(*cfgPath)
+	if err != nil {
+		return err
+	}
+	// The client database is shared by all scenarios
+	if base.Detection.ClientDatabase != "" {
+		if _, err := loadClientDatabase(base.Detection.ClientDatabase); err != nil {
+			return err
+		}
+	}
+	scenarios := []simulate.Scenario{{Name: "current", Config: base}}
+	for _, path := range compares {
+		cfg, err := config.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		scenarios = append(scenarios, simulate.Scenario{Name: name, Config: cfg})
+	}
+	for _, spec := range sets {
+		cfg, err := loadSimulateConfig(*cfgPath)
+		if err != nil {
+			return err
+		}
+		name, err := applyVariant(cfg, spec)
+		if err != nil {
+			return fmt.Errorf("invalid -set %q: %w", spec, err)
+		}
+		scenarios = append(scenarios, simulate.Scenario{Name: name, Config: cfg})
+	}
+
+	snapshots, err := readSnapshots(*tracePath)
+	if err != nil {
+		return err
+	}
+
+	results := make([]*simulate.Result, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		result, err := simulate.Run(snapshots, scenario)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+
+	if *format == "json" {
+		return writeOutput(os.Stdout, "json", results, nil, nil)
+	}
+	return writeSimulation(os.Stdout, snapshots, scenarios, results)
+}
+
+// readSnapshots reads a trace recorded by the daemon, together with its
+// rotated files, or a JSON lines trace
+func readSnapshots(path string) ([]simulate.Snapshot, error) {
+	if trace.IsTrace(path) {
+		return simulate.ReadTrace(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace: %w", err)
+	}
+	defer f.Close()
+	return simulate.ReadSnapshots(f)
+}
+
+// loadSimulateConfig loads the config file, or the defaults if it does not exist
+func loadSimulateConfig(path string) (*config.Config, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return config.DefaultConfig(), nil
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return cfg, nil
+}
+
+// applyVariant applies a NAME:KEY=VALUE[,KEY=VALUE...] spec and returns the name
+func applyVariant(cfg *config.Config, spec string) (string, error) {
+	name, assignments, ok := strings.Cut(spec, ":")
+	if !ok || name == "" {
+		return "", errors.New("missing scenario name")
+	}
+	for _, assignment := range strings.Split(assignments, ",") {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return "", fmt.Errorf("expected KEY=VALUE, got %q", assignment)
+		}
+		if err := cfg.Set(strings.TrimSpace(key), value); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// writeSimulation writes the bans of each scenario and, when comparing,
+// a per-IP side-by-side view
+func writeSimulation(w io.Writer, snapshots []simulate.Snapshot, scenarios []simulate.Scenario, results []*simulate.Result) error {
+	if len(snapshots) == 0 {
+		fmt.Fprintln(w, "Trace is empty")
+		return nil
+	}
+	first, last := snapshots[0].Time, snapshots[len(snapshots)-1].Time
+	fmt.Fprintf(w, "Replayed %d polls from %s to %s (%s)\n",
+		len(snapshots), first.Local().Format(time.RFC3339), last.Local().Format(time.RFC3339), last.Sub(first).Round(time.Second))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, r := range results {
+		behavior := scenarios[i].Config.Detection.Behavior
+		fmt.Fprintf(w, "\n== %s: min_share_ratio=%g min_data_threshold=%d base_duration=%s ==\n",
+			r.Scenario, behavior.MinShareRatio, behavior.MinDataThreshold, scenarios[i].Config.Blocking.BaseDuration)
+		fmt.Fprintf(w, "%d bans of %d IPs, %s banned in total, %d forgiven, %d throttled, %d watched\n",
+			len(r.Bans), r.IPs(), detector.FormatDuration(r.BanTime()), r.Forgiven, r.Throttled, r.Watched)
+		if len(r.Bans) == 0 {
+			continue
+		}
+		fmt.Fprintln(tw, "TIME\tIP\tCLIENT_NAME\tVIOLATIONS\tDURATION\tSHARE_RATIO\tTORRENT")
+		for _, b := range r.Bans {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%.4f\t%s\n",
+				b.Time.Local().Format(time.RFC3339), b.IP, b.ClientName, b.Violations, detector.FormatDuration(b.Duration), b.ShareRatio, b.Torrent)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(results) < 2 {
+		return nil
+	}
+
+	// Side by side: bans and total ban time of every IP per scenario
+	type cell struct {
+		bans  int
+		total time.Duration
+	}
+	perIP := make(map[string][]cell)
+	for i, r := range results {
+		for _, b := range r.Bans {
+			if perIP[b.IP] == nil {
+				perIP[b.IP] = make([]cell, len(results))
+			}
+			perIP[b.IP][i].bans++
+			perIP[b.IP][i].total = simulate.AddBanTime(perIP[b.IP][i].total, b.Duration)
+		}
+	}
+	ips := make([]string, 0, len(perIP))
+	for ip := range perIP {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	fmt.Fprintln(w, "\n== comparison ==")
+	header := []string{"IP"}
+	for _, r := range results {
+		header = append(header, strings.ToUpper(r.Scenario))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, ip := range ips {
+		row := []string{ip}
+		for _, c := range perIP[ip] {
+			if c.bans == 0 {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, strconv.Itoa(c.bans)+"x "+detector.FormatDuration(c.total))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
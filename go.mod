@@ -4,17 +4,18 @@ go 1.21
 
 require (
 	github.com/google/nftables v0.2.0
-	github.com/mdlayher/netlink v1.7.2
+	go.etcd.io/bbolt v1.3.10
 	go.uber.org/zap v1.27.0
+	golang.org/x/sys v0.18.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/josharian/native v1.1.0 // indirect
+	github.com/mdlayher/netlink v1.7.2 // indirect
 	github.com/mdlayher/socket v0.5.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sync v0.6.0 // indirect
-	golang.org/x/sys v0.18.0 // indirect
 )
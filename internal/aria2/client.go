@@ -4,6 +4,7 @@ package aria2
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -102,6 +103,36 @@ type DownloadStatus struct {
 	UploadSpeed     int64  `json:"uploadSpeed,string"`
 	InfoHash        string `json:"infoHash"`
 	Dir             string `json:"dir"`
+	Bitfield        string `json:"bitfield"`         // 已下载分片，十六进制
+	NumPieces       int    `json:"numPieces,string"` // 分片数量
+	Bittorrent      struct {
+		Info struct {
+			Name string `json:"name"`
+		} `json:"info"`
+	} `json:"bittorrent"`
+}
+
+// TorrentPeers holds an active BT download together with its peers
+type TorrentPeers struct {
+	Download DownloadStatus `json:"download"`
+	Peers    []Peer         `json:"peers"`
+}
+
+// Name returns the torrent name, or the info hash if aria2 did not report one
+func (t *TorrentPeers) Name() string {
+	if t.Download.Bittorrent.Info.Name != "" {
+		return t.Download.Bittorrent.Info.Name
+	}
+	return t.Download.InfoHash
+}
+
+// TotalUploadSpeed returns our upload speed over all torrents
+func TotalUploadSpeed(torrents []TorrentPeers) int64 {
+	var total int64
+	for i := range torrents {
+		total += torrents[i].Download.UploadSpeed
+	}
+	return total
 }
 
 // call makes a JSON-RPC call
@@ -179,23 +210,139 @@ func (c *Client) GetPeers(ctx context.Context, gid string) ([]Peer, error) {
 
 // GetAllPeers returns all peers from all active downloads
 func (c *Client) GetAllPeers(ctx context.Context) (map[string][]Peer, error) {
-	downloads, err := c.GetActiveDownloads(ctx)
+	torrents, err := c.GetAllTorrentPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allPeers := make(map[string][]Peer, len(torrents))
+	for _, t := range torrents {
+		allPeers[t.Download.Gid] = t.Peers
+	}
+
+	return allPeers, nil
+}
+
+// GetAllTorrentPeers returns all active BT downloads with their peers
+func (c *Client) GetAllTorrentPeers(ctx context.Context) ([]TorrentPeers, error) {
+	poll, err := c.Poll(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return poll.Torrents, nil
+}
+
+// PollResult is one poll of aria2 with the raw RPC results it was decoded
+// from, so it can be recorded as is
+type PollResult struct {
+	Time     time.Time
+	Active   json.RawMessage            // aria2.tellActive 原始结果
+	Peers    map[string]json.RawMessage // gid -> aria2.getPeers 原始结果
+	Torrents []TorrentPeers
+}
+
+// Poll fetches all active downloads and the peers of active BT downloads
+func (c *Client) Poll(ctx context.Context) (*PollResult, error) {
+	poll := &PollResult{Time: time.Now(), Peers: make(map[string]json.RawMessage)}
+
+	active, err := c.call(ctx, "aria2.tellActive", []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	poll.Active = active
+
+	var downloads []DownloadStatus
+	if err := json.Unmarshal(active, &downloads); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal downloads: %w", err)
+	}
 
-	allPeers := make(map[string][]Peer)
 	for _, download := range downloads {
 		// Only get peers for active BT downloads
-		if download.Status == "active" && download.InfoHash != "" {
-			peers, err := c.GetPeers(ctx, download.Gid)
-			if err != nil {
-				// Log error but continue with other downloads
-				continue
-			}
-			allPeers[download.Gid] = peers
+		if !isActiveTorrent(download) {
+			continue
+		}
+		peers, err := c.call(ctx, "aria2.getPeers", []interface{}{download.Gid})
+		if err != nil {
+			// Log error but continue with other downloads
+			continue
 		}
+		poll.Peers[download.Gid] = peers
 	}
 
-	return allPeers, nil
+	poll.Torrents, err = ParseTorrentPeers(poll.Active, poll.Peers)
+	if err != nil {
+		return nil, err
+	}
+	return poll, nil
+}
+
+// ParseTorrentPeers decodes raw tellActive and getPeers results into active
+// BT downloads with their peers. Downloads without a getPeers result are
+// left out.
+func ParseTorrentPeers(active json.RawMessage, peers map[string]json.RawMessage) ([]TorrentPeers, error) {
+	var downloads []DownloadStatus
+	if err := json.Unmarshal(active, &downloads); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal downloads: %w", err)
+	}
+
+	var torrents []TorrentPeers
+	for _, download := range downloads {
+		raw, ok := peers[download.Gid]
+		if !ok || !isActiveTorrent(download) {
+			continue
+		}
+		var list []Peer
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal peers of %s: %w", download.Gid, err)
+		}
+		torrents = append(torrents, TorrentPeers{Download: download, Peers: list})
+	}
+	return torrents, nil
+}
+
+// Bitfield is a piece bitfield, the highest bit of the first byte being
+// piece 0
+type Bitfield []byte
+
+// ParseBitfield decodes a hex bitfield as reported by aria2
+func ParseBitfield(s string) (Bitfield, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bitfield: %w", err)
+	}
+	return b, nil
+}
+
+// Has reports whether piece i is set
+func (b Bitfield) Has(i int) bool {
+	return i >= 0 && i/8 < len(b) && b[i/8]&(0x80>>(i%8)) != 0
+}
+
+// Availability counts the pieces we lack and how many of them the peer
+// has. ok is false if aria2 reported no usable bitfields.
+func (t *TorrentPeers) Availability(peer Peer) (has, missing int, ok bool) {
+	n := t.Download.NumPieces
+	ours, err := ParseBitfield(t.Download.Bitfield)
+	if err != nil || n <= 0 || len(ours)*8 < n {
+		return 0, 0, false
+	}
+	theirs, err := ParseBitfield(peer.Bitfield)
+	if err != nil || len(theirs)*8 < n {
+		return 0, 0, false
+	}
+	for i := 0; i < n; i++ {
+		if ours.Has(i) {
+			continue
+		}
+		missing++
+		if theirs.Has(i) {
+			has++
+		}
+	}
+	return has, missing, true
+}
+
+// isActiveTorrent reports whether a download is an active BT download
+func isActiveTorrent(download DownloadStatus) bool {
+	return download.Status == "active" && download.InfoHash != ""
 }
@@ -0,0 +1,258 @@
+// Package bans tracks the lifecycle of bans from block to expiry
+package bans
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban represents a single ban of an IP
+type Ban struct {
+	ID         string
+	PreviousID string // 上一次屏蔽的ID（累加惩罚时）
+	IP         string
+	PeerID     string
+	ClientName string
+	Reason     string
+	InfoHash   string
+	Torrent    string // 种子名称
+	Instance   string // aria2实例名称
+	Violations int
+	Duration   time.Duration
+	Start      time.Time
+	Expires    time.Time
+	Ended      time.Time
+	DryRun     bool // 观察模式下的假设屏蔽，未写入防火墙
+
+	// Traffic observed while the ban was active. Only outgoing packets are
+	// dropped, so the peer may keep sending to us.
+	BytesDownloaded int64 // peer's upload to us
+	BytesUploaded   int64 // what still reached the peer
+}
+
+// BannedFor returns how long the ban was (or has been) in effect
+func (b *Ban) BannedFor(now time.Time) time.Duration {
+	if !b.Ended.IsZero() {
+		return b.Ended.Sub(b.Start)
+	}
+	return now.Sub(b.Start)
+}
+
+// Tracker keeps active bans and the most recent ban of each IP
+type Tracker struct {
+	active map[string]*Ban // IP -> active ban
+	last   map[string]*Ban // IP -> most recent ended ban
+	mutex  sync.Mutex
+}
+
+// NewTracker creates a new ban tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		active: make(map[string]*Ban),
+		last:   make(map[string]*Ban),
+	}
+}
+
+// Start records a new ban and assigns it an ID. If the IP was banned before,
+// the new ban is linked to the previous one via PreviousID.
+func (t *Tracker) Start(ban Ban) *Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	b := ban
+	b.ID = newID()
+	if b.Start.IsZero() {
+		b.Start = time.Now()
+	}
+	if b.Expires.IsZero() {
+		b.Expires = b.Start.Add(b.Duration)
+	}
+
+	// An IP banned again while still active (e.g. by a different rule)
+	// replaces the active ban
+	if prev, ok := t.active[b.IP]; ok {
+		b.PreviousID = prev.ID
+	} else if prev, ok := t.last[b.IP]; ok {
+		b.PreviousID = prev.ID
+	}
+
+	t.active[b.IP] = &b
+	copied := b
+	return &copied
+}
+
+// Observe adds traffic seen from a banned IP to its active ban
+func (t *Tracker) Observe(ip string, downloaded, uploaded int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if b, ok := t.active[ip]; ok {
+		b.BytesDownloaded += downloaded
+		b.BytesUploaded += uploaded
+	}
+}
+
+// Expire ends all bans whose expiry time has passed
+func (t *Tracker) Expire(now time.Time) []*Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var ended []*Ban
+	for ip, b := range t.active {
+		if !now.Before(b.Expires) {
+			ended = append(ended, t.endLocked(ip, b.Expires))
+		}
+	}
+	sortBans(ended)
+	return ended
+}
+
+// Reconcile ends active bans whose IP is no longer present in the firewall.
+// This catches bans removed by hand (e.g. with the nft command). Bans that
+// expire within grace are left to Expire, since the firewall may drop them
+// slightly earlier. present is keyed by the canonical form of each IP as
+// returned by net.IP.String.
+func (t *Tracker) Reconcile(present map[string]bool, now time.Time, grace time.Duration) []*Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var removed []*Ban
+	for ip, b := range t.active {
+		if !present[canonicalIP(ip)] && now.Add(grace).Before(b.Expires) {
+			removed = append(removed, t.endLocked(ip, now))
+		}
+	}
+	sortBans(removed)
+	return removed
+}
+
+// End ends the active ban of an IP, returning nil if there is none
+func (t *Tracker) End(ip string, now time.Time) *Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.active[ip]; !ok {
+		return nil
+	}
+	return t.endLocked(ip, now)
+}
+
+// Forget drops the ban history of an IP so its next ban is not linked to
+// earlier ones. It returns the most recent ban, or nil if there is none.
+func (t *Tracker) Forget(ip string) *Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	b, ok := t.last[ip]
+	if !ok {
+		return nil
+	}
+	delete(t.last, ip)
+	copied := *b
+	return &copied
+}
+
+// RestoreLast records a ban from before a restart as the most recent ban of
+// its IP, so the next ban links back to it
+func (t *Tracker) RestoreLast(ban Ban) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.last[ban.IP]; ok {
+		return
+	}
+	b := ban
+	t.last[ban.IP] = &b
+}
+
+// Resume records a ban from before a restart that is still in effect as
+// active, keeping its ID so its end is logged under the same ban
+func (t *Tracker) Resume(ban Ban) *Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	b := ban
+	if b.Expires.IsZero() {
+		b.Expires = b.Start.Add(b.Duration)
+	}
+	t.active[b.IP] = &b
+	copied := b
+	return &copied
+}
+
+// Prune drops history of bans that ended before maxAge ago
+func (t *Tracker) Prune(maxAge time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	for ip, b := range t.last {
+		if b.Ended.Before(cutoff) {
+			delete(t.last, ip)
+		}
+	}
+}
+
+// Active returns a snapshot of all active bans, ordered by start time
+func (t *Tracker) Active() []*Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := make([]*Ban, 0, len(t.active))
+	for _, b := range t.active {
+		copied := *b
+		result = append(result, &copied)
+	}
+	sortBans(result)
+	return result
+}
+
+// Get returns the active ban of an IP, or nil if it is not banned
+func (t *Tracker) Get(ip string) *Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if b, ok := t.active[ip]; ok {
+		copied := *b
+		return &copied
+	}
+	return nil
+}
+
+// endLocked moves an active ban to the history. Caller must hold the mutex.
+func (t *Tracker) endLocked(ip string, at time.Time) *Ban {
+	b := t.active[ip]
+	delete(t.active, ip)
+	b.Ended = at
+	t.last[ip] = b
+	copied := *b
+	return &copied
+}
+
+// sortBans orders bans by start time for stable event output
+func sortBans(list []*Ban) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+}
+
+// canonicalIP returns the canonical string form of an IP address
+func canonicalIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// newID returns a random 16-character hex ban ID
+func newID() string {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return time.Now().Format("20060102150405.000000")
+	}
+	return hex.EncodeToString(buf[:])
+}
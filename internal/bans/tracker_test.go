@@ -0,0 +1,66 @@
+package bans
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerLifecycle(t *testing.T) {
+	tr := NewTracker()
+	start := time.Now()
+
+	first := tr.Start(Ban{IP: "10.0.0.1", Reason: "low_share_ratio", Violations: 1, Duration: time.Minute, Start: start})
+	if first.ID == "" {
+		t.Fatal("Expected ban ID to be assigned")
+	}
+	if first.PreviousID != "" {
+		t.Errorf("Expected no previous ban, got %s", first.PreviousID)
+	}
+
+	tr.Observe("10.0.0.1", 100, 10)
+	tr.Observe("10.0.0.2", 999, 999)
+
+	if ended := tr.Expire(start.Add(30 * time.Second)); len(ended) != 0 {
+		t.Fatalf("Expected no expired bans yet, got %d", len(ended))
+	}
+
+	ended := tr.Expire(start.Add(2 * time.Minute))
+	if len(ended) != 1 {
+		t.Fatalf("Expected 1 expired ban, got %d", len(ended))
+	}
+	if ended[0].BytesDownloaded != 100 || ended[0].BytesUploaded != 10 {
+		t.Errorf("Unexpected observed bytes: %d/%d", ended[0].BytesDownloaded, ended[0].BytesUploaded)
+	}
+	if got := ended[0].BannedFor(time.Now()); got != time.Minute {
+		t.Errorf("Expected banned for 1m, got %s", got)
+	}
+
+	second := tr.Start(Ban{IP: "10.0.0.1", Violations: 2, Duration: 2 * time.Minute})
+	if second.PreviousID != first.ID {
+		t.Errorf("Expected escalation to link %s, got %s", first.ID, second.PreviousID)
+	}
+}
+
+func TestTrackerReconcile(t *testing.T) {
+	tr := NewTracker()
+	now := time.Now()
+	tr.Start(Ban{IP: "2001:db8:0::1", Duration: time.Hour, Start: now})
+	tr.Start(Ban{IP: "10.0.0.3", Duration: time.Hour, Start: now})
+
+	tr.Start(Ban{IP: "10.0.0.4", Duration: time.Minute + 5*time.Second, Start: now})
+
+	// 10.0.0.4 is about to expire, the firewall may have dropped it already
+	removed := tr.Reconcile(map[string]bool{"2001:db8::1": true}, now.Add(time.Minute), 10*time.Second)
+	if len(removed) != 1 || removed[0].IP != "10.0.0.3" {
+		t.Fatalf("Expected only 10.0.0.3 to be reconciled away, got %+v", removed)
+	}
+	if len(tr.Expire(now.Add(2*time.Minute))) != 1 {
+		t.Error("Expected 10.0.0.4 to be left to expire")
+	}
+	if len(tr.Active()) != 1 {
+		t.Errorf("Expected 1 active ban, got %d", len(tr.Active()))
+	}
+	if last := tr.Forget("10.0.0.3"); last == nil || last.ID != removed[0].ID {
+		t.Error("Expected Forget to return the ended ban")
+	}
+}
@@ -14,19 +14,96 @@ type Config struct {
 	Detection DetectionConfig `yaml:"detection"`
 	Blocking  BlockingConfig  `yaml:"blocking"`
 	Logging   LoggingConfig   `yaml:"logging"`
+	Control   ControlConfig   `yaml:"control"`
+
+	Notifications NotificationsConfig `yaml:"notifications"`
+	History       HistoryConfig       `yaml:"history"`
+	Trace         TraceConfig         `yaml:"trace"`
 }
 
-// Aria2Config holds aria2 RPC connection settings
+// Aria2Config holds aria2 RPC connection settings. With instances set,
+// the settings above them are the defaults of every instance.
 type Aria2Config struct {
+	Host         string          `yaml:"host"`
+	Port         int             `yaml:"port"`
+	Secret       string          `yaml:"secret"`
+	SecretFile   string          `yaml:"secret_file"` // 从文件读取密钥，优先于secret
+	PollInterval time.Duration   `yaml:"poll_interval"`
+	Instances    []Aria2Instance `yaml:"instances"` // 同时监控多个aria2实例
+}
+
+// Aria2Instance is one of several aria2 daemons monitored at once. Unset
+// connection settings are taken from aria2.
+type Aria2Instance struct {
+	Name         string        `yaml:"name"`
 	Host         string        `yaml:"host"`
 	Port         int           `yaml:"port"`
 	Secret       string        `yaml:"secret"`
+	SecretFile   string        `yaml:"secret_file"`
 	PollInterval time.Duration `yaml:"poll_interval"`
+	Detection    yaml.Node     `yaml:"detection,omitempty"` // 覆盖部分detection设置
 }
 
 // DetectionConfig holds detection rule settings
 type DetectionConfig struct {
-	Behavior BehaviorConfig `yaml:"behavior"`
+	Behavior       BehaviorConfig    `yaml:"behavior"`
+	PeerID         PeerIDConfig      `yaml:"peer_id"`
+	Swarm          SwarmConfig       `yaml:"swarm"`
+	Reciprocity    ReciprocityConfig `yaml:"reciprocity"`
+	Credit         CreditConfig      `yaml:"credit"`
+	Burst          BurstConfig       `yaml:"burst"`
+	Violations     ViolationsConfig  `yaml:"violations"`
+	Backfill       BackfillConfig    `yaml:"backfill"`
+	ClientRules    []ClientRule      `yaml:"client_rules"`
+	ClientDatabase string            `yaml:"client_database"` // 额外的客户端数据库（YAML/JSON），SIGHUP重新加载
+}
+
+// Client rule actions
+const (
+	RuleActionBan      = "ban"
+	RuleActionThrottle = "throttle"
+	RuleActionWatch    = "watch"
+)
+
+// ClientRule acts on peers by peer ID or parsed client, without waiting
+// for behavior analysis. All matchers that are set must match.
+type ClientRule struct {
+	Name         string        `yaml:"name"`
+	PeerIDPrefix string        `yaml:"peer_id_prefix"` // 原始peer ID前缀
+	PeerIDRegex  string        `yaml:"peer_id_regex"`  // 原始peer ID正则
+	Client       string        `yaml:"client"`         // 解析出的客户端名称，不区分大小写
+	Tag          string        `yaml:"tag"`            // 客户端标签，如offline_download
+	Version      string        `yaml:"version"`        // 版本范围表达式，如 ">=4.0 <4.4" 或 "2.x"
+	MinVersion   string        `yaml:"min_version"`    // 客户端版本下限（包含），等同于 version: ">=min"
+	MaxVersion   string        `yaml:"max_version"`    // 客户端版本上限（不包含），等同于 version: "<max"
+	Action       string        `yaml:"action"`         // ban, throttle 或 watch
+	Duration     time.Duration `yaml:"duration"`       // ban/throttle时长，0则按违规次数累加base_duration
+}
+
+// UsesThrottle reports whether a client rule or the credit ledger may
+// throttle peers, so the firewall needs its rate limiting rules
+func (d *DetectionConfig) UsesThrottle() bool {
+	for _, rule := range d.ClientRules {
+		if rule.Action == RuleActionThrottle {
+			return true
+		}
+	}
+	return d.Credit.Enabled && d.Credit.Action == RuleActionThrottle
+}
+
+// ViolationsConfig holds settings of the violation ledger, which keeps
+// violation counts apart from traffic statistics
+type ViolationsConfig struct {
+	HalfLife          time.Duration `yaml:"half_life"`           // 违规次数衰减一半所需时间，0表示不衰减
+	ForgetAfter       time.Duration `yaml:"forget_after"`        // 超过该时间没有新违规则清除记录，0表示不清除
+	ForgiveOnRecovery bool          `yaml:"forgive_on_recovery"` // 屏蔽期满后分享率恢复时立即清零
+}
+
+// BackfillConfig holds settings for restoring violation counts from the
+// block log on startup
+type BackfillConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Lookback time.Duration `yaml:"lookback"` // 只读取这段时间内的事件
 }
 
 // BehaviorConfig holds behavior analysis settings
@@ -34,21 +111,195 @@ type BehaviorConfig struct {
 	Enabled          bool    `yaml:"enabled"`
 	MinShareRatio    float64 `yaml:"min_share_ratio"`
 	MinDataThreshold int64   `yaml:"min_data_threshold"`
+
+	Ratio          string        `yaml:"ratio"`           // 分享率计算方式：window、ewma或lifetime
+	Window         time.Duration `yaml:"window"`          // window方式的滑动窗口长度
+	EWMAHalfLife   time.Duration `yaml:"ewma_half_life"`  // ewma方式中旧数据权重减半所需时间
+	MinObservation time.Duration `yaml:"min_observation"` // 观察时间不足时不判断
+	MinConfidence  float64       `yaml:"min_confidence"`  // 置信度（0-1）不足时不判断
+}
+
+// SwarmConfig holds settings for judging peers against the other peers of
+// the same torrent instead of a fixed share ratio
+type SwarmConfig struct {
+	Enabled         bool    `yaml:"enabled"`
+	MinPeers        int     `yaml:"min_peers"`        // 可比较的peer少于该数量时不判断
+	ZScore          float64 `yaml:"z_score"`          // 低于平均值这么多个标准差时视为异常，0表示不检查
+	Percentile      float64 `yaml:"percentile"`       // 处于最低的这一百分位时视为异常，0表示不检查
+	MinAvailability float64 `yaml:"min_availability"` // peer拥有我们所缺分片的比例低于该值时不判断
+	MaxShareRatio   float64 `yaml:"max_share_ratio"`  // 分享率不低于该值的peer不视为异常，0表示不限制
+}
+
+// ReciprocityConfig holds settings for flagging peers that keep choking us
+// while we unchoke and upload to them
+type ReciprocityConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MinDuration time.Duration `yaml:"min_duration"` // peer持续choke我们的时间
+	MinUploaded int64         `yaml:"min_uploaded"` // 期间我们至少上传给peer的量（字节）
+	Action      string        `yaml:"action"`       // ban或watch
+}
+
+// CreditConfig holds settings of the upload credit ledger: every IP may
+// take a free allowance from us, after which it has to give back in
+// proportion. Amounts are bytes, speeds times the poll interval.
+type CreditConfig struct {
+	Enabled     bool               `yaml:"enabled"`
+	Allowance   int64              `yaml:"allowance"`   // 免费上传额度（字节）
+	Multiplier  float64            `yaml:"multiplier"`  // peer每上传给我们1字节换得的额度
+	Multipliers map[string]float64 `yaml:"multipliers"` // 按客户端标签覆盖multiplier，匹配多个时取最小值
+	HalfLife    time.Duration      `yaml:"half_life"`   // 余额与allowance之差减半所需时间，0表示不回归
+	MaxCredit   int64              `yaml:"max_credit"`  // 余额上限，0表示不限制
+	Action      string             `yaml:"action"`      // 额度耗尽时ban或throttle
+	File        string             `yaml:"file"`        // 持久化文件，空表示只保存在内存中
+}
+
+// BurstConfig holds settings for banning peers that drain our upload at
+// full speed while giving nothing back, before min_data_threshold is
+// reached
+type BurstConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MinFraction float64       `yaml:"min_fraction"` // peer占我们总上传速度的比例
+	MinSpeed    int64         `yaml:"min_speed"`    // 我们上传给peer的速度下限（字节/秒）
+	MaxReturn   int64         `yaml:"max_return"`   // peer上传给我们的速度不超过该值视为没有回报（字节/秒）
+	Polls       int           `yaml:"polls"`        // 连续满足条件的轮询次数
+	FirstBan    time.Duration `yaml:"first_ban"`    // 第一次屏蔽的时长，之后按惩罚策略，0表示一直按惩罚策略
+}
+
+// Share ratio modes
+const (
+	RatioWindow   = "window"   // 滑动窗口内的数据
+	RatioEWMA     = "ewma"     // 指数加权移动平均
+	RatioLifetime = "lifetime" // 连接以来的全部数据
+)
+
+// PeerIDConfig holds settings for treating malformed or spoofed peer IDs
+// as a detection signal
+type PeerIDConfig struct {
+	Enabled              bool    `yaml:"enabled"`
+	SuspectScore         float64 `yaml:"suspect_score"`          // 异常分数达到该值的peer视为可疑
+	SuspectDataThreshold int64   `yaml:"suspect_data_threshold"` // 可疑peer的min_data_threshold，更早进行分享率判断
+	BanScore             float64 `yaml:"ban_score"`              // 异常分数达到该值直接屏蔽，0表示不直接屏蔽
 }
 
 // BlockingConfig holds blocking settings
 type BlockingConfig struct {
 	BaseDuration time.Duration `yaml:"base_duration"` // 基础屏蔽时长，累加惩罚的基数
 	NftTable     string        `yaml:"nft_table"`
+	DryRun       bool          `yaml:"dry_run"`       // 观察模式：只记录would_block，不修改nftables
+	ThrottleRate int64         `yaml:"throttle_rate"` // 限速peer每个IP的上传速率上限（字节/秒）
+
+	Punishment PunishmentConfig `yaml:"punishment"`
+}
+
+// Punishment policies
+const (
+	PolicyLinear      = "linear"      // 违规次数 * base_duration
+	PolicyExponential = "exponential" // base_duration * factor^(违规次数-1)
+	PolicySteps       = "steps"       // 按steps表取值
+)
+
+// PunishmentConfig selects how ban durations grow with violations.
+// Reasons overrides the policy for single detection reasons, e.g.
+// low_share_ratio or client_rule:<name>.
+type PunishmentConfig struct {
+	Policy         string          `yaml:"policy"`          // linear, exponential 或 steps
+	Factor         float64         `yaml:"factor"`          // exponential的倍数
+	Steps          []time.Duration `yaml:"steps"`           // 第N次违规的时长，超出后使用最后一项
+	Max            time.Duration   `yaml:"max"`             // 时长上限，0表示不限制
+	Jitter         float64         `yaml:"jitter"`          // 随机浮动比例，0.1表示±10%
+	PermanentAfter int             `yaml:"permanent_after"` // 第N次违规起永久屏蔽，0表示从不
+
+	Reasons map[string]PunishmentConfig `yaml:"reasons"`
 }
 
-// LoggingConfig holds logging settings
+// LoggingConfig holds logging settings.
+// Level, Format and Output apply to the operational log, File and the
+// rotation settings to the block event log.
 type LoggingConfig struct {
-	Level      string `yaml:"level"`
+	Level      string `yaml:"level"`  // debug, info, warn, error
+	Format     string `yaml:"format"` // json 或 console
+	Output     string `yaml:"output"` // stderr, stdout, journald 或文件路径
 	File       string `yaml:"file"`
-	MaxSize    int    `yaml:"max_size"`
-	MaxBackups int    `yaml:"max_backups"`
-	MaxAge     int    `yaml:"max_age"`
+	MaxSize    int    `yaml:"max_size"`    // MB，超过后自动轮转，0表示不轮转
+	MaxBackups int    `yaml:"max_backups"` // 保留的旧文件数量，0表示不限制
+	MaxAge     int    `yaml:"max_age"`     // 旧文件保留天数，0表示不限制
+	Compress   bool   `yaml:"compress"`    // 使用gzip压缩旧文件
+
+	Journald JournaldConfig `yaml:"journald"`
+	Syslog   SyslogConfig   `yaml:"syslog"`
+}
+
+// JournaldConfig holds settings for sending block events to journald
+type JournaldConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Socket  string `yaml:"socket"` // journald原生协议socket路径
+}
+
+// SyslogConfig holds settings for sending block events to a syslog collector
+type SyslogConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Network        string        `yaml:"network"` // udp, tcp, tls 或 unix
+	Address        string        `yaml:"address"` // host:port 或 unix socket路径
+	Facility       string        `yaml:"facility"`
+	AppName        string        `yaml:"app_name"`
+	Hostname       string        `yaml:"hostname"`    // 空则使用系统主机名
+	BufferSize     int           `yaml:"buffer_size"` // 待发送消息队列长度，满时丢弃
+	ReconnectDelay time.Duration `yaml:"reconnect_delay"`
+
+	// TLS settings, used when network is tls
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// HistoryConfig holds ban history database settings
+type HistoryConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// TraceConfig holds settings for recording raw aria2 polls
+type TraceConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Path       string `yaml:"path"`
+	MaxSize    int    `yaml:"max_size"`    // 单个文件最大大小（MB）
+	MaxBackups int    `yaml:"max_backups"` // 保留的轮转文件数
+}
+
+// ControlConfig holds control API settings
+type ControlConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"` // unix socket路径或 host:port
+}
+
+// NotificationsConfig holds webhook notification settings
+type NotificationsConfig struct {
+	Enabled   bool            `yaml:"enabled"`
+	QueueFile string          `yaml:"queue_file"` // 待重试通知的持久化队列，重启后继续发送
+	Webhooks  []WebhookConfig `yaml:"webhooks"`
+}
+
+// WebhookConfig holds settings of a single webhook
+type WebhookConfig struct {
+	Name        string            `yaml:"name"`
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"`
+	Headers     map[string]string `yaml:"headers"`
+	Events      []string          `yaml:"events"`       // 只发送这些事件类型，空表示全部
+	Format      string            `yaml:"format"`       // json 或 text
+	Template    string            `yaml:"template"`     // Go text/template，空则发送事件JSON
+	ContentType string            `yaml:"content_type"` // 默认根据format决定
+	Secret      string            `yaml:"secret"`       // HMAC-SHA256签名密钥
+	SecretFile  string            `yaml:"secret_file"`
+
+	BatchSize     int           `yaml:"batch_size"`     // 每次请求最多包含的事件数
+	BatchInterval time.Duration `yaml:"batch_interval"` // 收集事件的等待时间
+	MinInterval   time.Duration `yaml:"min_interval"`   // 两次请求的最小间隔（限速）
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxRetries    int           `yaml:"max_retries"`
+	RetryBackoff  time.Duration `yaml:"retry_backoff"` // 首次重试等待时间，之后翻倍
 }
 
 // DefaultConfig returns the default configuration
@@ -65,24 +316,123 @@ func DefaultConfig() *Config {
 				Enabled:          true,
 				MinShareRatio:    0.1,
 				MinDataThreshold: 10 * 1024 * 1024, // 10MB
+				Ratio:            RatioLifetime,
+				Window:           5 * time.Minute,
+				EWMAHalfLife:     2 * time.Minute,
+				MinObservation:   time.Minute,
+				MinConfidence:    0.2,
+			},
+			PeerID: PeerIDConfig{
+				Enabled:              false,
+				SuspectScore:         0.5,
+				SuspectDataThreshold: 2 * 1024 * 1024, // 2MB
+			},
+			Swarm: SwarmConfig{
+				MinPeers:        8,
+				ZScore:          2,
+				Percentile:      10,
+				MinAvailability: 0.05,
+				MaxShareRatio:   0.5,
+			},
+			Reciprocity: ReciprocityConfig{
+				MinDuration: 10 * time.Minute,
+				MinUploaded: 10 * 1024 * 1024, // 10MB
+				Action:      RuleActionBan,
+			},
+			Credit: CreditConfig{
+				Allowance:  50 * 1024 * 1024, // 50MB
+				Multiplier: 10,               // 与min_share_ratio 0.1相当
+				HalfLife:   24 * time.Hour,
+				Action:     RuleActionBan,
+				File:       "/var/lib/aria2bango/credits.json",
+			},
+			Burst: BurstConfig{
+				MinFraction: 0.5,
+				MinSpeed:    5 * 1024 * 1024, // 5MB/s
+				Polls:       6,
+				FirstBan:    time.Minute,
+			},
+			Violations: ViolationsConfig{
+				HalfLife:    7 * 24 * time.Hour,
+				ForgetAfter: 30 * 24 * time.Hour,
+			},
+			Backfill: BackfillConfig{
+				Enabled:  true,
+				Lookback: 30 * 24 * time.Hour, // 与forget_after相同
 			},
 		},
 		Blocking: BlockingConfig{
 			BaseDuration: 5 * time.Minute, // 基础屏蔽5分钟，累加惩罚
 			NftTable:     "aria2bango",
+			ThrottleRate: 100 * 1024, // 100KB/s
+			Punishment: PunishmentConfig{
+				Policy: PolicyLinear,
+				Factor: 2,
+			},
 		},
 		Logging: LoggingConfig{
 			Level:      "info",
+			Format:     "json",
+			Output:     "stderr",
 			File:       "/var/log/aria2bango/blocked.log",
 			MaxSize:    100,
 			MaxBackups: 3,
 			MaxAge:     30,
+			Compress:   true,
+			Journald: JournaldConfig{
+				Enabled: false,
+				Socket:  "/run/systemd/journal/socket",
+			},
+			Syslog: SyslogConfig{
+				Enabled:        false,
+				Network:        "udp",
+				Address:        "127.0.0.1:514",
+				Facility:       "daemon",
+				AppName:        "aria2bango",
+				BufferSize:     1000,
+				ReconnectDelay: time.Second,
+			},
+		},
+		Control: ControlConfig{
+			Enabled: false,
+			Listen:  "/run/aria2bango/control.sock",
+		},
+		History: HistoryConfig{
+			Enabled: true,
+			Path:    "/var/lib/aria2bango/history.db",
+		},
+		Trace: TraceConfig{
+			Enabled:    false,
+			Path:       "/var/lib/aria2bango/trace.bin",
+			MaxSize:    50,
+			MaxBackups: 5,
+		},
+		Notifications: NotificationsConfig{
+			Enabled:   false,
+			QueueFile: "/var/lib/aria2bango/notify-queue.json",
 		},
 	}
 }
 
-// Load loads configuration from a YAML file
+// Load loads configuration from a YAML file, then applies environment
+// variable and secret file overrides (see ApplyOverrides)
 func Load(path string) (*Config, error) {
+	config, err := LoadWithoutSecrets(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// LoadWithoutSecrets loads configuration like Load but leaves secret files
+// unread, for commands that need no secrets and may run without access to
+// them
+func LoadWithoutSecrets(path string) (*Config, error) {
 	config := DefaultConfig()
 
 	data, err := os.ReadFile(path)
@@ -94,12 +444,34 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
 // Save saves the configuration to a YAML file
+// Secrets loaded from secret_file are not written back.
 func (c *Config) Save(path string) error {
-	data, err := yaml.Marshal(c)
+	out := *c
+	if out.Aria2.SecretFile != "" {
+		out.Aria2.Secret = ""
+	}
+	out.Aria2.Instances = append([]Aria2Instance(nil), c.Aria2.Instances...)
+	for i := range out.Aria2.Instances {
+		if out.Aria2.Instances[i].SecretFile != "" {
+			out.Aria2.Instances[i].Secret = ""
+		}
+	}
+	out.Notifications.Webhooks = append([]WebhookConfig(nil), c.Notifications.Webhooks...)
+	for i := range out.Notifications.Webhooks {
+		if out.Notifications.Webhooks[i].SecretFile != "" {
+			out.Notifications.Webhooks[i].Secret = ""
+		}
+	}
+
+	data, err := yaml.Marshal(&out)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,325 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func envMap(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	cfg := DefaultConfig()
+	err := cfg.ApplyEnv(envMap(map[string]string{
+		"ARIA2BANGO_ARIA2_HOST":                         "10.0.0.2",
+		"ARIA2BANGO_ARIA2_PORT":                         "6801",
+		"ARIA2BANGO_ARIA2_POLL_INTERVAL":                "30s",
+		"ARIA2BANGO_DETECTION_BEHAVIOR_ENABLED":         "false",
+		"ARIA2BANGO_DETECTION_BEHAVIOR_MIN_SHARE_RATIO": "0.25",
+		"ARIA2BANGO_LOGGING_FILE":                       "/tmp/blocked.log",
+	}))
+	if err != nil {
+		t.Fatalf("ApplyEnv failed: %v", err)
+	}
+
+	if cfg.Aria2.Host != "10.0.0.2" {
+		t.Errorf("Expected host 10.0.0.2, got %s", cfg.Aria2.Host)
+	}
+	if cfg.Aria2.Port != 6801 {
+		t.Errorf("Expected port 6801, got %d", cfg.Aria2.Port)
+	}
+	if cfg.Aria2.PollInterval != 30*time.Second {
+		t.Errorf("Expected poll interval 30s, got %s", cfg.Aria2.PollInterval)
+	}
+	if cfg.Detection.Behavior.Enabled {
+		t.Error("Expected behavior detection to be disabled")
+	}
+	if cfg.Detection.Behavior.MinShareRatio != 0.25 {
+		t.Errorf("Expected min share ratio 0.25, got %f", cfg.Detection.Behavior.MinShareRatio)
+	}
+	if cfg.Logging.File != "/tmp/blocked.log" {
+		t.Errorf("Expected log file /tmp/blocked.log, got %s", cfg.Logging.File)
+	}
+}
+
+func TestApplyEnvComposite(t *testing.T) {
+	cfg := DefaultConfig()
+	err := cfg.ApplyEnv(envMap(map[string]string{
+		"ARIA2BANGO_DETECTION_CLIENT_RULES":      `[{"name":"xunlei","peer_id_prefix":"-XL","action":"throttle","duration":"1h"}]`,
+		"ARIA2BANGO_BLOCKING_PUNISHMENT_STEPS":   "10m, 1h",
+		"ARIA2BANGO_BLOCKING_PUNISHMENT_REASONS": `{"low_share_ratio": {"policy": "steps", "steps": ["1h"]}}`,
+		"ARIA2BANGO_NOTIFICATIONS_WEBHOOKS":      `[{"name":"ops","url":"https://example.com/hook"}]`,
+		"ARIA2BANGO_ARIA2_INSTANCES":             `[{"name":"alice","port":6800,"detection":{"behavior":{"min_share_ratio":0.3}}}]`,
+	}))
+	if err != nil {
+		t.Fatalf("ApplyEnv failed: %v", err)
+	}
+	if rules := cfg.Detection.ClientRules; len(rules) != 1 || rules[0].Name != "xunlei" || rules[0].Duration != time.Hour {
+		t.Errorf("Unexpected client rules %+v", rules)
+	}
+	if steps := cfg.Blocking.Punishment.Steps; len(steps) != 2 || steps[1] != time.Hour {
+		t.Errorf("Unexpected steps %v", steps)
+	}
+	if reasons := cfg.Blocking.Punishment.Reasons; len(reasons) != 1 || reasons["low_share_ratio"].Policy != "steps" {
+		t.Errorf("Unexpected punishment reasons %+v", reasons)
+	}
+	if hooks := cfg.Notifications.Webhooks; len(hooks) != 1 || hooks[0].URL != "https://example.com/hook" {
+		t.Errorf("Unexpected webhooks %+v", hooks)
+	}
+	instances, err := cfg.Instances()
+	if err != nil || len(instances) != 1 || instances[0].Detection.Behavior.MinShareRatio != 0.3 {
+		t.Errorf("Unexpected instances %+v, %v", instances, err)
+	}
+}
+
+// TestEveryFieldOverridable walks Config and overrides every field with
+// its own default value, so new fields cannot silently lose support
+func TestEveryFieldOverridable(t *testing.T) {
+	var walk func(v reflect.Value, path string)
+	walk = func(v reflect.Value, path string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" || !field.IsExported() {
+				continue
+			}
+			fv := v.Field(i)
+			name := path + "." + key
+			if fv.Kind() == reflect.Struct {
+				walk(fv, name)
+				continue
+			}
+
+			var raw string
+			switch {
+			case fv.Type() == durationType:
+				raw = time.Duration(fv.Int()).String()
+			case fv.Kind() == reflect.Slice && (fv.Type().Elem().Kind() == reflect.String || fv.Type().Elem() == durationType):
+				raw = "1s"
+				if fv.Type().Elem().Kind() == reflect.String {
+					raw = "a,b"
+				}
+			case fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map:
+				data, err := yaml.Marshal(fv.Interface())
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw = string(data)
+			default:
+				raw = fmt.Sprint(fv.Interface())
+			}
+			if err := setFromString(reflect.New(fv.Type()).Elem(), raw); err != nil {
+				t.Errorf("%s cannot be overridden: %v", name[1:], err)
+			}
+		}
+	}
+	walk(reflect.ValueOf(DefaultConfig()).Elem(), "")
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	cfg := DefaultConfig()
+	err := cfg.ApplyEnv(envMap(map[string]string{
+		"ARIA2BANGO_ARIA2_PORT": "not-a-port",
+	}))
+	if err == nil {
+		t.Error("Expected error for invalid port")
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "aria2-secret"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(CredentialsDirEnv, dir)
+
+	cfg := DefaultConfig()
+	cfg.Aria2.Secret = "from-yaml"
+	cfg.Aria2.SecretFile = "aria2-secret"
+	if err := cfg.resolveSecrets(); err != nil {
+		t.Fatalf("resolveSecrets failed: %v", err)
+	}
+	if cfg.Aria2.Secret != "s3cret" {
+		t.Errorf("Expected secret from credentials directory, got %q", cfg.Aria2.Secret)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("file-secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	yaml := "aria2:\n  host: yaml-host\n  port: 7000\n  secret: yaml-secret\n"
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ARIA2BANGO_ARIA2_PORT", "7001")
+	t.Setenv("ARIA2BANGO_ARIA2_SECRET_FILE", secretPath)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Aria2.Host != "yaml-host" {
+		t.Errorf("Expected host from YAML, got %s", cfg.Aria2.Host)
+	}
+	if cfg.Aria2.Port != 7001 {
+		t.Errorf("Expected port from environment, got %d", cfg.Aria2.Port)
+	}
+	if cfg.Aria2.Secret != "file-secret" {
+		t.Errorf("Expected secret from secret file, got %q", cfg.Aria2.Secret)
+	}
+}
+
+func TestLoadWithoutSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yaml := "aria2:\n  secret_file: " + filepath.Join(dir, "missing") + "\nhistory:\n  path: /tmp/history.db\n"
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected Load to fail on an unreadable secret file")
+	}
+	cfg, err := LoadWithoutSecrets(path)
+	if err != nil {
+		t.Fatalf("LoadWithoutSecrets failed: %v", err)
+	}
+	if cfg.History.Path != "/tmp/history.db" {
+		t.Errorf("Expected history path from YAML, got %s", cfg.History.Path)
+	}
+}
+
+func TestSet(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.Set("detection.behavior.min_share_ratio", "0.3"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := cfg.Set("blocking.base_duration", "10m"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if cfg.Detection.Behavior.MinShareRatio != 0.3 || cfg.Blocking.BaseDuration != 10*time.Minute {
+		t.Errorf("Fields not set: %+v %+v", cfg.Detection.Behavior, cfg.Blocking)
+	}
+	if err := cfg.Set("detection.nope", "1"); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	cfg, err := Load(filepath.Join("..", "..", "configs", "config.yaml"))
+	if err != nil {
+		t.Fatalf("Example config does not load: %v", err)
+	}
+	if cfg.Detection.Behavior.Window != 5*time.Minute || cfg.Detection.Credit.Allowance != 50<<20 {
+		t.Errorf("Unexpected example settings: %+v", cfg.Detection)
+	}
+}
+
+func TestUsesThrottle(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.Detection.UsesThrottle() {
+		t.Error("Expected the default config not to throttle")
+	}
+
+	cfg.Detection.Credit.Action = RuleActionThrottle
+	if cfg.Detection.UsesThrottle() {
+		t.Error("Expected a disabled credit ledger not to throttle")
+	}
+	cfg.Detection.Credit.Enabled = true
+	if !cfg.Detection.UsesThrottle() {
+		t.Error("Expected credit.action throttle to throttle")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Detection.ClientRules = []ClientRule{{Name: "xunlei", PeerIDPrefix: "-XL", Action: RuleActionThrottle}}
+	if !cfg.Detection.UsesThrottle() {
+		t.Error("Expected a throttle client rule to throttle")
+	}
+}
+
+func TestInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `aria2:
+  secret: shared
+  instances:
+    - name: alice
+      port: 6800
+    - name: bob
+      port: 6801
+      poll_interval: 30s
+      detection:
+        behavior:
+          min_share_ratio: 0.3
+        swarm:
+          enabled: true
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	instances, err := cfg.Instances()
+	if err != nil {
+		t.Fatalf("Instances failed: %v", err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("Expected 2 instances, got %+v", instances)
+	}
+
+	alice, bob := instances[0], instances[1]
+	if alice.Addr() != "127.0.0.1:6800" || alice.Secret != "shared" || alice.PollInterval != 10*time.Second {
+		t.Errorf("Expected alice to inherit the aria2 settings, got %+v", alice)
+	}
+	if alice.Detection.Behavior.MinShareRatio != 0.1 {
+		t.Errorf("Expected shared detection settings for alice, got %+v", alice.Detection.Behavior)
+	}
+	if bob.PollInterval != 30*time.Second || bob.Detection.Behavior.MinShareRatio != 0.3 || !bob.Detection.Swarm.Enabled {
+		t.Errorf("Expected overrides for bob, got %+v", bob)
+	}
+	if bob.Detection.Behavior.Window != 5*time.Minute || bob.Detection.Swarm.MinPeers != 8 {
+		t.Errorf("Expected settings bob does not override to be kept, got %+v", bob.Detection)
+	}
+	if cfg.Detection.Behavior.MinShareRatio != 0.1 || cfg.Detection.Swarm.Enabled {
+		t.Errorf("Overrides leaked into the shared settings: %+v", cfg.Detection)
+	}
+
+	// Without instances, aria2 itself is the only one
+	if instances, err := DefaultConfig().Instances(); err != nil || len(instances) != 1 || instances[0].Name != "" {
+		t.Errorf("Expected a single unnamed instance, got %+v, %v", instances, err)
+	}
+
+	cfg.Aria2.Instances[1].Port = 6800
+	if _, err := cfg.Instances(); err == nil {
+		t.Error("Expected error for two instances on one address")
+	}
+	cfg.Aria2.Instances[1].Port = 6801
+	cfg.Aria2.Instances[1].Detection.Content[0].Value = "credit"
+	if _, err := cfg.Instances(); err == nil {
+		t.Error("Expected error for overriding shared credit settings")
+	}
+
+	// How peer statistics are kept is shared, the thresholds are not
+	cfg.Aria2.Instances[1].Detection.Content[0].Value = "behavior"
+	cfg.Aria2.Instances[1].Detection.Content[1].Content[0].Value = "window"
+	if _, err := cfg.Instances(); err == nil || !strings.Contains(err.Error(), "detection.behavior.window") {
+		t.Errorf("Expected error for overriding behavior.window, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables that override config fields
+const EnvPrefix = "ARIA2BANGO_"
+
+// CredentialsDirEnv is set by systemd when LoadCredential= is used
+const CredentialsDirEnv = "CREDENTIALS_DIRECTORY"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyOverrides applies environment variable and secret file overrides.
+//
+// Precedence, lowest to highest:
+//  1. built-in defaults
+//  2. the YAML config file
+//  3. ARIA2BANGO_* environment variables
+//  4. *.secret_file (replaces the matching secret when set)
+func (c *Config) ApplyOverrides() error {
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return err
+	}
+	return c.resolveSecrets()
+}
+
+// ApplyEnv overrides config fields from ARIA2BANGO_* environment variables.
+// Variable names are the upper-cased YAML keys joined by underscores, e.g.
+// aria2.poll_interval is overridden by ARIA2BANGO_ARIA2_POLL_INTERVAL.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookup)
+}
+
+// Set overrides a single field by its dotted YAML path, e.g.
+// detection.behavior.min_share_ratio, parsing value like an environment
+// variable
+func (c *Config) Set(path, value string) error {
+	name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+	found := false
+	err := c.ApplyEnv(func(key string) (string, bool) {
+		if key != name {
+			return "", false
+		}
+		found = true
+		return value, true
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("unknown config key %s", path)
+	}
+	return nil
+}
+
+// applyEnv walks a struct and sets every supported field that has a matching variable
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" || !field.IsExported() {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(fv, raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setFromString parses raw into a field. Scalars are written as in YAML,
+// string and duration lists comma-separated, and other lists and maps as
+// inline YAML or JSON, e.g. [{"name":"xunlei","peer_id_prefix":"-XL"}].
+func setFromString(fv reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		elem := fv.Type().Elem()
+		if elem.Kind() != reflect.String && elem != durationType {
+			return setFromYAML(fv, raw)
+		}
+		items := reflect.MakeSlice(fv.Type(), 0, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			iv := reflect.New(elem).Elem()
+			if err := setFromString(iv, item); err != nil {
+				return err
+			}
+			items = reflect.Append(items, iv)
+		}
+		fv.Set(items)
+	case reflect.Map:
+		return setFromYAML(fv, raw)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// setFromYAML replaces a composite field with the value decoded from raw
+func setFromYAML(fv reflect.Value, raw string) error {
+	v := reflect.New(fv.Type())
+	if err := yaml.Unmarshal([]byte(raw), v.Interface()); err != nil {
+		return err
+	}
+	fv.Set(v.Elem())
+	return nil
+}
+
+// resolveSecrets loads secrets referenced by *_file settings
+func (c *Config) resolveSecrets() error {
+	if c.Aria2.SecretFile != "" {
+		secret, err := readSecretFile(c.Aria2.SecretFile)
+		if err != nil {
+			return fmt.Errorf("failed to read aria2 secret: %w", err)
+		}
+		c.Aria2.Secret = secret
+	}
+
+	for i := range c.Aria2.Instances {
+		inst := &c.Aria2.Instances[i]
+		if inst.SecretFile == "" {
+			continue
+		}
+		secret, err := readSecretFile(inst.SecretFile)
+		if err != nil {
+			return fmt.Errorf("failed to read secret of aria2 instance %s: %w", inst.Name, err)
+		}
+		inst.Secret = secret
+	}
+
+	for i := range c.Notifications.Webhooks {
+		hook := &c.Notifications.Webhooks[i]
+		if hook.SecretFile == "" {
+			continue
+		}
+		secret, err := readSecretFile(hook.SecretFile)
+		if err != nil {
+			return fmt.Errorf("failed to read secret of webhook %s: %w", hook.Name, err)
+		}
+		hook.Secret = secret
+	}
+	return nil
+}
+
+// readSecretFile reads a secret from a file, trimming the trailing newline.
+// Relative paths are resolved against $CREDENTIALS_DIRECTORY when systemd
+// provides one, so "secret_file: aria2-secret" works with LoadCredential=.
+func readSecretFile(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		if dir := os.Getenv(CredentialsDirEnv); dir != "" {
+			path = filepath.Join(dir, path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sharedDetection lists the detection settings that belong to the shared
+// detector and cannot differ between aria2 instances, by their dotted path
+// below detection. Peer statistics are shared, so is how they are kept.
+var sharedDetection = map[string]bool{
+	"violations":              true,
+	"backfill":                true,
+	"credit":                  true,
+	"client_rules":            true,
+	"client_database":         true,
+	"behavior.ratio":          true,
+	"behavior.window":         true,
+	"behavior.ewma_half_life": true,
+}
+
+// Instance is an aria2 instance to monitor with its settings resolved
+type Instance struct {
+	Name         string // 单实例配置时为空
+	Host         string
+	Port         int
+	Secret       string
+	PollInterval time.Duration
+	Detection    DetectionConfig
+}
+
+// Addr returns the host:port of the instance's RPC interface
+func (i *Instance) Addr() string {
+	return fmt.Sprintf("%s:%d", i.Host, i.Port)
+}
+
+// Instances returns the aria2 instances to monitor: those listed in
+// aria2.instances, or a single unnamed one made of the aria2 settings.
+// An instance's detection settings are the shared ones with its own
+// detection section applied on top.
+func (c *Config) Instances() ([]Instance, error) {
+	if len(c.Aria2.Instances) == 0 {
+		return []Instance{{
+			Host:         c.Aria2.Host,
+			Port:         c.Aria2.Port,
+			Secret:       c.Aria2.Secret,
+			PollInterval: c.Aria2.PollInterval,
+			Detection:    c.Detection,
+		}}, nil
+	}
+
+	names := make(map[string]bool)
+	addrs := make(map[string]string)
+	instances := make([]Instance, 0, len(c.Aria2.Instances))
+	for _, ai := range c.Aria2.Instances {
+		if ai.Name == "" {
+			return nil, fmt.Errorf("aria2 instance without a name")
+		}
+		if names[ai.Name] {
+			return nil, fmt.Errorf("duplicate aria2 instance %s", ai.Name)
+		}
+		names[ai.Name] = true
+
+		inst := Instance{
+			Name:         ai.Name,
+			Host:         ai.Host,
+			Port:         ai.Port,
+			Secret:       ai.Secret,
+			PollInterval: ai.PollInterval,
+		}
+		if inst.Host == "" {
+			inst.Host = c.Aria2.Host
+		}
+		if inst.Port == 0 {
+			inst.Port = c.Aria2.Port
+		}
+		if inst.Secret == "" {
+			inst.Secret = c.Aria2.Secret
+		}
+		if inst.PollInterval == 0 {
+			inst.PollInterval = c.Aria2.PollInterval
+		}
+		if inst.PollInterval <= 0 {
+			return nil, fmt.Errorf("aria2 instance %s: poll_interval must be positive", ai.Name)
+		}
+		if other, ok := addrs[inst.Addr()]; ok {
+			return nil, fmt.Errorf("aria2 instances %s and %s both use %s", other, ai.Name, inst.Addr())
+		}
+		addrs[inst.Addr()] = ai.Name
+
+		detection, err := c.instanceDetection(ai)
+		if err != nil {
+			return nil, fmt.Errorf("aria2 instance %s: %w", ai.Name, err)
+		}
+		inst.Detection = detection
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+// instanceDetection applies the detection section of an instance to a
+// copy of the shared detection settings
+func (c *Config) instanceDetection(ai Aria2Instance) (DetectionConfig, error) {
+	detection := c.Detection
+	if ai.Detection.IsZero() {
+		return detection, nil
+	}
+
+	if key := sharedKey(&ai.Detection, ""); key != "" {
+		return detection, fmt.Errorf("detection.%s applies to all instances and cannot be overridden", key)
+	}
+	if err := ai.Detection.Decode(&detection); err != nil {
+		return detection, fmt.Errorf("invalid detection settings: %w", err)
+	}
+	return detection, nil
+}
+
+// sharedKey returns the first shared setting set in a detection mapping
+// node, or below it for sections that are shared in part
+func sharedKey(node *yaml.Node, prefix string) string {
+	// Mapping nodes alternate keys and values
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := prefix + node.Content[i].Value
+		if sharedDetection[key] {
+			return key
+		}
+		if value := node.Content[i+1]; prefix == "" && value.Kind == yaml.MappingNode {
+			if key := sharedKey(value, key+"."); key != "" {
+				return key
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,148 @@
+// Package control provides the HTTP control API of the daemon
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Server serves the control API on a unix socket or TCP address
+type Server struct {
+	mux        *http.ServeMux
+	listener   net.Listener
+	httpServer *http.Server
+	socketPath string
+}
+
+// NewServer listens on addr. Addresses starting with "/" or "unix:" are unix
+// socket paths, anything else is a TCP host:port. The API has no
+// authentication, so TCP addresses must be on the loopback interface.
+func NewServer(addr string) (*Server, error) {
+	s := &Server{mux: http.NewServeMux()}
+
+	var err error
+	if path, ok := socketPath(addr); ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create socket directory: %w", err)
+		}
+		// Remove a stale socket left behind by a previous run
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+		s.listener, err = net.Listen("unix", path)
+		if err == nil {
+			s.socketPath = path
+			err = os.Chmod(path, 0660)
+		}
+	} else {
+		if err := checkLoopback(addr); err != nil {
+			return nil, err
+		}
+		s.listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s.httpServer = &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Handle registers a handler for the given pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers a handler function for the given pattern
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Serve serves requests until Close is called
+func (s *Server) Serve() error {
+	err := s.httpServer.Serve(s.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server and removes the unix socket
+func (s *Server) Close() error {
+	err := s.httpServer.Close()
+	if s.socketPath != "" {
+		os.Remove(s.socketPath)
+	}
+	return err
+}
+
+// socketPath returns the unix socket path of addr, if it is one
+func socketPath(addr string) (string, bool) {
+	if strings.HasPrefix(addr, "unix:") {
+		return strings.TrimPrefix(addr, "unix:"), true
+	}
+	if strings.HasPrefix(addr, "/") {
+		return addr, true
+	}
+	return "", false
+}
+
+// checkLoopback rejects TCP addresses that are not on the loopback
+// interface, as anyone who can reach the API can lift bans
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %s: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("listen address %s is not a loopback address, the control API has no authentication", addr)
+	}
+	return nil
+}
+
+// WriteJSON writes v as a JSON response
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// WriteError writes an error response as {"error": "..."}
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// AllowMethods rejects requests whose method is not in methods
+func AllowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	WriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
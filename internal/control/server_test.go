@@ -0,0 +1,113 @@
+package control
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// serve starts a server on addr and returns a client that talks to it
+func serve(t *testing.T, addr string, register func(s *Server)) (*http.Client, string) {
+	t.Helper()
+	srv, err := NewServer(addr)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	register(srv)
+	go srv.Serve()
+	t.Cleanup(func() { srv.Close() })
+
+	if srv.socketPath == "" {
+		return http.DefaultClient, "http://" + srv.Addr().String()
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", srv.socketPath)
+		},
+	}}
+	return client, "http://localhost"
+}
+
+// do sends a request and returns the status and body
+func do(t *testing.T, client *http.Client, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestLogLevel(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	client, base := serve(t, "unix:"+filepath.Join(t.TempDir(), "control.sock"), func(s *Server) {
+		s.Handle("/log/level", level)
+	})
+
+	if status, body := do(t, client, http.MethodGet, base+"/log/level", ""); status != http.StatusOK || !strings.Contains(body, `"info"`) {
+		t.Errorf("GET /log/level = %d %s", status, body)
+	}
+	if status, body := do(t, client, http.MethodPut, base+"/log/level", `{"level":"debug"}`); status != http.StatusOK {
+		t.Errorf("PUT /log/level = %d %s", status, body)
+	}
+	if level.Level() != zapcore.DebugLevel {
+		t.Errorf("Level = %s, want debug", level.Level())
+	}
+
+	if status, _ := do(t, client, http.MethodPut, base+"/log/level", `{"level":"loud"}`); status != http.StatusBadRequest {
+		t.Errorf("PUT with an unknown level = %d, want 400", status)
+	}
+	if status, _ := do(t, client, http.MethodPut, base+"/log/level", `not json`); status != http.StatusBadRequest {
+		t.Errorf("PUT with an invalid body = %d, want 400", status)
+	}
+	if level.Level() != zapcore.DebugLevel {
+		t.Errorf("Level changed by invalid requests to %s", level.Level())
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	client, base := serve(t, "127.0.0.1:0", func(s *Server) {
+		s.HandleFunc("/bans", func(w http.ResponseWriter, r *http.Request) {
+			if AllowMethods(w, r, http.MethodGet) {
+				WriteJSON(w, http.StatusOK, []string{})
+			}
+		})
+	})
+
+	if status, body := do(t, client, http.MethodGet, base+"/bans", ""); status != http.StatusOK || strings.TrimSpace(body) != "[]" {
+		t.Errorf("GET /bans = %d %s", status, body)
+	}
+	if status, body := do(t, client, http.MethodPost, base+"/bans", ""); status != http.StatusMethodNotAllowed || !strings.Contains(body, `"error"`) {
+		t.Errorf("POST /bans = %d %s", status, body)
+	}
+}
+
+func TestNewServerLoopbackOnly(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0", "192.0.2.1:6802"} {
+		if srv, err := NewServer(addr); err == nil {
+			srv.Close()
+			t.Errorf("Expected %s to be rejected", addr)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1:0", "localhost:0"} {
+		srv, err := NewServer(addr)
+		if err != nil {
+			t.Errorf("Expected %s to be accepted: %v", addr, err)
+			continue
+		}
+		srv.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package detector
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// ViolationHistory is the violation state of an IP reconstructed from the
+// block log
+type ViolationHistory struct {
+	IP           string
+	Violations   int
+	LastBanID    string
+	LastBlocked  time.Time
+	BlockedUntil time.Time
+	Duration     time.Duration     // 最后一次屏蔽的时长，可能为Permanent
+	LastBan      logger.BlockEvent // 最后一次屏蔽的blocked事件
+}
+
+// Backfill rebuilds per-IP violation state from block log events, which must
+// be in chronological order. It mirrors what the daemon did when the events
+// were logged: bans set the violation count, forgiveness, forgetting and
+// manual unblocks reset it.
+func Backfill(events []logger.BlockEvent) []ViolationHistory {
+	states := make(map[string]*ViolationHistory)
+	for _, event := range events {
+		state, ok := states[event.IP]
+		if !ok {
+			state = &ViolationHistory{IP: event.IP}
+			states[event.IP] = state
+		}
+
+		switch event.Event {
+		case logger.EventBlocked:
+			if event.Violations > 0 {
+				state.Violations = event.Violations
+			} else {
+				// Logged before violation counts were recorded
+				state.Violations++
+			}
+			state.LastBanID = event.BanID
+			state.LastBlocked = event.Timestamp
+			state.BlockedUntil = event.Timestamp
+			state.Duration = 0
+			state.LastBan = event
+			if d, err := ParseDuration(event.Duration); err == nil {
+				state.BlockedUntil = event.Timestamp.Add(d)
+				state.Duration = d
+			}
+		case logger.EventForgiven, logger.EventForgotten, logger.EventUnblockedManual:
+			state.Violations = 0
+			state.BlockedUntil = event.Timestamp
+		}
+	}
+
+	result := make([]ViolationHistory, 0, len(states))
+	for _, state := range states {
+		if state.Violations > 0 {
+			result = append(result, *state)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].IP < result[j].IP })
+	return result
+}
+
+// RestoreViolations seeds the detector with violation history, e.g. from
+// Backfill after a restart. Counts decay from the time of the last block.
+// IPs are taken as blocked until BlockedUntil; the caller cuts short bans
+// it did not put back into the firewall, so the peer is judged again
+// immediately and escalates from its restored count.
+func (d *Detector) RestoreViolations(history []ViolationHistory) {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	now := d.now()
+	for _, h := range history {
+		d.ledger.restore(h.IP, h.Violations, h.LastBlocked, h.BlockedUntil, now)
+	}
+}
@@ -0,0 +1,42 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+func TestBackfill(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	events := []logger.BlockEvent{
+		{Timestamp: start, Event: logger.EventBlocked, IP: "10.0.0.1", Duration: "5m0s", Violations: 1, BanID: "a"},
+		{Timestamp: start.Add(5 * time.Minute), Event: logger.EventExpired, IP: "10.0.0.1"},
+		{Timestamp: start.Add(10 * time.Minute), Event: logger.EventBlocked, IP: "10.0.0.1", Duration: "10m0s", Violations: 2, BanID: "b"},
+		{Timestamp: start, Event: logger.EventBlocked, IP: "10.0.0.2", Duration: "5m0s"},
+		{Timestamp: start.Add(time.Minute), Event: logger.EventBlocked, IP: "10.0.0.2", Duration: "5m0s"},
+		{Timestamp: start, Event: logger.EventBlocked, IP: "10.0.0.3", Duration: "5m0s", Violations: 1},
+		{Timestamp: start.Add(20 * time.Minute), Event: logger.EventForgiven, IP: "10.0.0.3"},
+		{Timestamp: start, Event: logger.EventBlocked, IP: "10.0.0.4", Duration: "5m0s", Violations: 1},
+		{Timestamp: start.Add(30 * time.Minute), Event: logger.EventForgotten, IP: "10.0.0.4"},
+	}
+
+	history := Backfill(events)
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 IPs with violations, got %+v", history)
+	}
+	if h := history[0]; h.IP != "10.0.0.1" || h.Violations != 2 || h.LastBanID != "b" ||
+		!h.BlockedUntil.Equal(start.Add(20*time.Minute)) {
+		t.Errorf("Unexpected history for 10.0.0.1: %+v", h)
+	}
+	if h := history[1]; h.IP != "10.0.0.2" || h.Violations != 2 {
+		t.Errorf("Expected legacy events to be counted, got %+v", h)
+	}
+
+	d := NewDetector(&config.DetectionConfig{})
+	d.RestoreViolations(history)
+	if got := d.GetViolationCount("10.0.0.1"); got != 2 {
+		t.Errorf("Expected 2 restored violations, got %d", got)
+	}
+}
@@ -0,0 +1,94 @@
+package detector
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// CheckBurst validates the burst-drain settings
+func CheckBurst(cfg config.BurstConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MinFraction <= 0 || cfg.MinFraction > 1 {
+		return fmt.Errorf("min_fraction must be greater than 0 and at most 1, got %v", cfg.MinFraction)
+	}
+	if cfg.MinSpeed < 0 || cfg.MaxReturn < 0 {
+		return fmt.Errorf("negative speed")
+	}
+	if cfg.Polls < 1 {
+		return fmt.Errorf("polls must be at least 1, got %d", cfg.Polls)
+	}
+	if cfg.FirstBan < 0 {
+		return fmt.Errorf("negative first_ban")
+	}
+	return nil
+}
+
+// DetectBurst bans peers of a torrent that take a large part of our total
+// upload speed for several polls in a row while giving nothing back. It
+// judges rates instead of amounts, so a fast leecher is stopped before
+// behavior analysis has seen min_data_threshold. The first ban lasts
+// first_ban, later ones follow the punishment policy.
+//
+// totalUpload is our upload speed over all torrents. Call it once per
+// torrent and poll.
+func (d *Detector) DetectBurst(torrent *aria2.TorrentPeers, totalUpload int64, baseBlockDuration time.Duration) []*DetectionResult {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	cfg := d.config.Burst
+	if !cfg.Enabled {
+		return nil
+	}
+	now := d.now()
+
+	var results []*DetectionResult
+	for _, peer := range torrent.Peers {
+		stats, ok := d.peerStats[peer.IP]
+		if !ok {
+			continue
+		}
+		var fraction float64
+		if totalUpload > 0 {
+			fraction = float64(peer.UploadSpeed) / float64(totalUpload)
+		}
+		// Polls while banned do not count, the streak starts after the ban
+		if peer.UploadSpeed < cfg.MinSpeed || fraction < cfg.MinFraction || peer.DownloadSpeed > cfg.MaxReturn || d.ledger.blocked(peer.IP, now) {
+			stats.BurstPolls = 0
+			continue
+		}
+		stats.BurstPolls++
+		if stats.BurstPolls < cfg.Polls {
+			continue
+		}
+
+		var duration time.Duration
+		if d.ledger.violations(peer.IP, now) == 0 {
+			duration = cfg.FirstBan
+		}
+		violations, blockDuration := d.violate(peer.IP, ReasonBurstDrain, duration, now, baseBlockDuration)
+		results = append(results, &DetectionResult{
+			Action:             ActionBlock,
+			Peer:               peer,
+			Reason:             ReasonBurstDrain,
+			ShareRatio:         stats.Ratio.ShareRatio,
+			Violations:         violations,
+			BlockDuration:      blockDuration,
+			PeerUploaded:       stats.TotalDownload,
+			PeerDownloaded:     stats.TotalUpload,
+			LifetimeShareRatio: stats.LifetimeShareRatio(),
+			RatioConfidence:    stats.Ratio.Confidence,
+			PeerIDScore:        stats.PeerIDScore,
+			PeerIDAnomalies:    stats.PeerIDAnomalies,
+			Credit:             stats.Credit,
+			BurstPolls:         stats.BurstPolls,
+			UploadFraction:     math.Round(fraction*100) / 100,
+		})
+	}
+	return results
+}
@@ -0,0 +1,76 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// pollBurst polls a torrent every 10s and returns the burst results with
+// the poll they came in
+func pollBurst(d *Detector, now *time.Time, torrent *aria2.TorrentPeers, polls int) map[int]*DetectionResult {
+	results := make(map[int]*DetectionResult)
+	for i := 1; i <= polls; i++ {
+		for _, peer := range torrent.Peers {
+			d.Detect(peer, 5*time.Minute)
+		}
+		torrents := []aria2.TorrentPeers{*torrent}
+		for _, result := range d.DetectBurst(torrent, aria2.TotalUploadSpeed(torrents), 5*time.Minute) {
+			results[i] = result
+		}
+		*now = now.Add(10 * time.Second)
+	}
+	return results
+}
+
+func TestDetectBurst(t *testing.T) {
+	cfg := config.DefaultConfig().Detection
+	cfg.Behavior.Enabled = false
+	cfg.Burst.Enabled = true
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	torrent := &aria2.TorrentPeers{Peers: []aria2.Peer{
+		{IP: "10.0.0.1", UploadSpeed: 50 << 20},                        // drains us
+		{IP: "10.0.0.2", UploadSpeed: 9 << 20, DownloadSpeed: 1 << 20}, // gives back
+	}}
+	torrent.Download.UploadSpeed = 60 << 20
+
+	// Short first ban after 6 polls in a row
+	results := pollBurst(d, &now, torrent, 6)
+	result := results[6]
+	if len(results) != 1 || result == nil || result.Peer.IP != "10.0.0.1" {
+		t.Fatalf("Expected a ban on poll 6, got %v", results)
+	}
+	if result.Reason != ReasonBurstDrain || result.BlockDuration != time.Minute || result.BurstPolls != 6 || result.UploadFraction != 0.83 {
+		t.Errorf("Unexpected first ban: %+v", result)
+	}
+
+	// The streak starts over once the ban expires on poll 6; later bans
+	// follow the punishment policy
+	results = pollBurst(d, &now, torrent, 12)
+	if len(results) != 1 || results[11] == nil || results[11].Violations != 2 || results[11].BlockDuration != 10*time.Minute {
+		t.Errorf("Expected an escalated ban on poll 11, got %v", results)
+	}
+
+	// A poll with some return starts over
+	d = NewDetector(&cfg)
+	pollBurst(d, &now, torrent, 5)
+	torrent.Peers[0].DownloadSpeed = 1
+	pollBurst(d, &now, torrent, 1)
+	torrent.Peers[0].DownloadSpeed = 0
+	if results := pollBurst(d, &now, torrent, 5); len(results) != 0 {
+		t.Errorf("Expected no ban after the streak was broken, got %v", results)
+	}
+
+	// A slow torrent is not a burst
+	d = NewDetector(&cfg)
+	slow := &aria2.TorrentPeers{Peers: []aria2.Peer{{IP: "10.0.0.3", UploadSpeed: 1 << 20}}}
+	slow.Download.UploadSpeed = 1 << 20
+	if results := pollBurst(d, &now, slow, 20); len(results) != 0 {
+		t.Errorf("Expected no ban below min_speed, got %v", results)
+	}
+}
@@ -0,0 +1,233 @@
+package detector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/peerid"
+)
+
+// creditLedger tracks what every IP may still take from us. A balance
+// starts at the allowance, loses what we upload and gains what the peer
+// gives us times the multiplier. Over time it returns to the allowance,
+// so debts are forgiven and hoarded credit fades. Guarded by the
+// detector's mutex.
+type creditLedger struct {
+	cfg     config.CreditConfig
+	entries map[string]*creditEntry
+}
+
+// creditEntry is the balance of an IP, also its format in the credit file
+type creditEntry struct {
+	Balance float64   `json:"balance"`
+	Updated time.Time `json:"updated"`
+}
+
+// CheckCredit validates the credit ledger settings
+func CheckCredit(cfg config.CreditConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Allowance < 0 {
+		return fmt.Errorf("negative allowance")
+	}
+	if cfg.Multiplier < 0 {
+		return fmt.Errorf("negative multiplier")
+	}
+	for tag, m := range cfg.Multipliers {
+		if m < 0 {
+			return fmt.Errorf("negative multiplier for %s", tag)
+		}
+	}
+	if cfg.HalfLife < 0 {
+		return fmt.Errorf("negative half_life")
+	}
+	if cfg.MaxCredit < 0 || (cfg.MaxCredit > 0 && cfg.MaxCredit < cfg.Allowance) {
+		return fmt.Errorf("max_credit must be 0 or at least the allowance")
+	}
+	switch cfg.Action {
+	case config.RuleActionBan, config.RuleActionThrottle:
+	default:
+		return fmt.Errorf("unknown action %q (want ban or throttle)", cfg.Action)
+	}
+	return nil
+}
+
+// newCreditLedger creates an empty credit ledger
+func newCreditLedger(cfg config.CreditConfig) *creditLedger {
+	return &creditLedger{cfg: cfg, entries: make(map[string]*creditEntry)}
+}
+
+// balance returns the balance of an IP at a point in time
+func (c *creditLedger) balance(ip string, now time.Time) float64 {
+	e, ok := c.entries[ip]
+	if !ok {
+		return float64(c.cfg.Allowance)
+	}
+	return c.decayed(e, now)
+}
+
+// decayed moves a balance towards the allowance for the time since its
+// last update
+func (c *creditLedger) decayed(e *creditEntry, now time.Time) float64 {
+	elapsed := now.Sub(e.Updated)
+	if c.cfg.HalfLife <= 0 || elapsed <= 0 {
+		return e.Balance
+	}
+	allowance := float64(c.cfg.Allowance)
+	return allowance + (e.Balance-allowance)*math.Pow(0.5, float64(elapsed)/float64(c.cfg.HalfLife))
+}
+
+// account books a poll: received is what the peer gave us, sent what we
+// uploaded to it. It returns the new balance.
+func (c *creditLedger) account(ip string, now time.Time, received, sent int64, multiplier float64) float64 {
+	e, ok := c.entries[ip]
+	if !ok {
+		e = &creditEntry{Balance: float64(c.cfg.Allowance), Updated: now}
+		c.entries[ip] = e
+	}
+	e.Balance = c.decayed(e, now) + float64(received)*multiplier - float64(sent)
+	if c.cfg.MaxCredit > 0 && e.Balance > float64(c.cfg.MaxCredit) {
+		e.Balance = float64(c.cfg.MaxCredit)
+	}
+	e.Updated = now
+	return e.Balance
+}
+
+// settle resets the balance of an IP to the allowance, after its debt was
+// paid with a ban
+func (c *creditLedger) settle(ip string, now time.Time) {
+	c.entries[ip] = &creditEntry{Balance: float64(c.cfg.Allowance), Updated: now}
+}
+
+// transferred returns the bytes moved in one poll at a speed
+func (d *Detector) transferred(speed int64) int64 {
+	return int64(float64(speed) * d.interval.Seconds())
+}
+
+// multiplier returns the credit multiplier for a peer ID, the lowest of
+// the multipliers of its client tags if any apply
+func (c *creditLedger) multiplier(peerID string) float64 {
+	m, found := c.cfg.Multiplier, false
+	for _, tag := range peerid.Parse(peerID).Tags {
+		if tm, ok := c.cfg.Multipliers[tag]; ok && (!found || tm < m) {
+			m, found = tm, true
+		}
+	}
+	return m
+}
+
+// prune drops balances that are back at the allowance (within a byte)
+func (c *creditLedger) prune(now time.Time) {
+	for ip, e := range c.entries {
+		if math.Abs(c.decayed(e, now)-float64(c.cfg.Allowance)) < 1 {
+			delete(c.entries, ip)
+		}
+	}
+}
+
+// checkCredit bans or throttles a peer that used up its credit. A ban
+// settles the debt, so the peer starts over with its allowance once the
+// ban ends instead of being banned again right away.
+func (d *Detector) checkCredit(stats *PeerStats, peer aria2.Peer, now time.Time, baseBlockDuration time.Duration) *DetectionResult {
+	cfg := d.shared.Credit
+	if !cfg.Enabled || stats.Credit > 0 {
+		return nil
+	}
+
+	result := &DetectionResult{
+		Peer:           peer,
+		Reason:         ReasonCreditExhausted,
+		ShareRatio:     stats.Ratio.ShareRatio,
+		Violations:     d.ledger.violations(peer.IP, now),
+		PeerUploaded:   stats.TotalDownload,
+		PeerDownloaded: stats.TotalUpload,
+	}
+	if cfg.Action == config.RuleActionThrottle {
+		if now.Before(stats.ThrottledUntil) {
+			return nil
+		}
+		stats.ThrottledUntil = now.Add(baseBlockDuration)
+		result.Action = ActionThrottle
+		result.BlockDuration = baseBlockDuration
+		return result
+	}
+
+	if d.ledger.blocked(peer.IP, now) {
+		return nil
+	}
+	result.Action = ActionBlock
+	result.Violations, result.BlockDuration = d.violate(peer.IP, ReasonCreditExhausted, 0, now, baseBlockDuration)
+	d.credits.settle(peer.IP, now)
+	return result
+}
+
+// LoadCredits reads the credit ledger from the credit file, starting empty
+// if it does not exist. Without a file credits are kept in memory only.
+func (d *Detector) LoadCredits() error {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	path := d.shared.Credit.File
+	if !d.shared.Credit.Enabled || path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read credit file: %w", err)
+	}
+	entries := make(map[string]*creditEntry)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("failed to parse credit file: %w", err)
+		}
+	}
+	d.credits.entries = entries
+	return nil
+}
+
+// SaveCredits writes the credit ledger to the credit file atomically,
+// dropping balances that are back at the allowance
+func (d *Detector) SaveCredits() error {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	path := d.shared.Credit.File
+	if !d.shared.Credit.Enabled || path == "" {
+		return nil
+	}
+	d.credits.prune(d.now())
+
+	data, err := json.Marshal(d.credits.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credits: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create credit directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credit file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write credit file: %w", err)
+	}
+	return nil
+}
+
+// GetCredit returns the credit balance of an IP
+func (d *Detector) GetCredit(ip string) float64 {
+	d.statsMutex.RLock()
+	defer d.statsMutex.RUnlock()
+	return d.credits.balance(ip, d.now())
+}
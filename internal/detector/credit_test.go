@@ -0,0 +1,149 @@
+package detector
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+func creditConfig(t *testing.T) config.DetectionConfig {
+	cfg := instantConfig()
+	cfg.Behavior.Enabled = false
+	cfg.Credit.Enabled = true
+	cfg.Credit.Allowance = 10 << 20
+	cfg.Credit.HalfLife = 0
+	cfg.Credit.File = filepath.Join(t.TempDir(), "credits.json")
+	return cfg
+}
+
+// pollUntilExhausted polls a peer until a result and returns the number
+// of polls, or -1
+func pollUntilExhausted(d *Detector, peer aria2.Peer, polls int) (int, *DetectionResult) {
+	for i := 1; i <= polls; i++ {
+		if result := d.Detect(peer, 5*time.Minute); result != nil {
+			return i, result
+		}
+	}
+	return -1, nil
+}
+
+func TestDetectCreditExhausted(t *testing.T) {
+	cfg := creditConfig(t)
+	cfg.Credit.Multipliers = map[string]float64{"offline_download": 0}
+	d := NewDetector(&cfg)
+
+	// Takes 1MB and gives 50KB per poll: 50KB*10 earned, about 0.5MB lost
+	peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 1 << 20, DownloadSpeed: 50 << 10}
+	polls, result := pollUntilExhausted(d, peer, 100)
+	if polls != 20 || result.Action != ActionBlock || result.Reason != ReasonCreditExhausted || result.Credit > 0 {
+		t.Fatalf("Exhausted after %d polls: %+v", polls, result)
+	}
+	if stats := d.GetStats(peer.IP); stats.Credit != result.Credit {
+		t.Errorf("Stats credit = %v, want %v", stats.Credit, result.Credit)
+	}
+
+	// Offline downloaders earn nothing for what they give
+	xl := aria2.Peer{IP: "10.0.0.2", PeerID: "-XL0019-abcdefghijkl", UploadSpeed: 1 << 20, DownloadSpeed: 50 << 10}
+	if polls, _ := pollUntilExhausted(d, xl, 100); polls != 10 {
+		t.Errorf("Offline downloader exhausted after %d polls, want 10", polls)
+	}
+
+	// Fair trade never runs out
+	fair := aria2.Peer{IP: "10.0.0.3", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 1 << 20, DownloadSpeed: 200 << 10}
+	if polls, result := pollUntilExhausted(d, fair, 1000); polls != -1 {
+		t.Errorf("Unexpected result for fair peer: %+v", result)
+	}
+}
+
+func TestCreditPollInterval(t *testing.T) {
+	cfg := creditConfig(t)
+	d := NewDetector(&cfg)
+	d.SetPollInterval(10 * time.Second)
+
+	// 100KB/s for 10s polls takes 1MB a poll
+	peer := aria2.Peer{IP: "10.0.0.1", UploadSpeed: 100 << 10}
+	d.Detect(peer, 5*time.Minute)
+	if credit := d.GetCredit(peer.IP); credit != 10<<20-1000<<10 {
+		t.Errorf("Credit after a poll = %v, want %v", credit, 10<<20-1000<<10)
+	}
+}
+
+func TestCreditBanSettlesDebt(t *testing.T) {
+	cfg := creditConfig(t)
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	// One deficit: the peer takes its allowance and a bit more, then stops
+	peer := aria2.Peer{IP: "10.0.0.1", UploadSpeed: 11 << 20}
+	if result := d.Detect(peer, 5*time.Minute); result == nil || result.Action != ActionBlock || result.Violations != 1 {
+		t.Fatalf("Expected a credit ban, got %+v", result)
+	}
+
+	// Once the ban is over the peer is not banned again for the same debt
+	peer.UploadSpeed = 1 << 20
+	for i := 0; i < 5; i++ {
+		now = now.Add(10 * time.Minute)
+		if result := d.Detect(peer, 5*time.Minute); result != nil {
+			t.Fatalf("Unexpected result after the ban: %+v", result)
+		}
+	}
+	if n := d.GetViolationCount(peer.IP); n != 1 {
+		t.Errorf("Violations = %d, want a single ban", n)
+	}
+}
+
+func TestDetectCreditThrottle(t *testing.T) {
+	cfg := creditConfig(t)
+	cfg.Credit.Action = config.RuleActionThrottle
+	d := NewDetector(&cfg)
+
+	peer := aria2.Peer{IP: "10.0.0.1", UploadSpeed: 1 << 20}
+	polls, result := pollUntilExhausted(d, peer, 100)
+	if polls != 10 || result.Action != ActionThrottle || result.BlockDuration != 5*time.Minute {
+		t.Fatalf("Exhausted after %d polls: %+v", polls, result)
+	}
+	if d.IsBlocked(peer.IP) {
+		t.Error("Expected throttle not to block")
+	}
+}
+
+func TestCreditDecayAndPersistence(t *testing.T) {
+	cfg := creditConfig(t)
+	cfg.Credit.HalfLife = time.Hour
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	// 4MB below the allowance, halfway back in an hour
+	d.Detect(aria2.Peer{IP: "10.0.0.1", UploadSpeed: 4 << 20}, 5*time.Minute)
+	d.Detect(aria2.Peer{IP: "10.0.0.2", UploadSpeed: 1}, 5*time.Minute)
+	now = now.Add(time.Hour)
+	if credit := d.GetCredit("10.0.0.1"); credit != 8<<20 {
+		t.Errorf("Credit after an hour = %v, want 8MB", credit)
+	}
+
+	// Balances survive a restart; those back at the allowance are dropped
+	now = now.Add(24 * time.Hour)
+	if err := d.SaveCredits(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewDetector(&cfg)
+	restarted.SetClock(func() time.Time { return now })
+	if err := restarted.LoadCredits(); err != nil {
+		t.Fatal(err)
+	}
+	if credit := restarted.GetCredit("10.0.0.1"); credit != d.GetCredit("10.0.0.1") {
+		t.Errorf("Credit after restart = %v, want %v", credit, d.GetCredit("10.0.0.1"))
+	}
+	if _, ok := restarted.credits.entries["10.0.0.2"]; ok {
+		t.Error("Expected balance back at the allowance to be dropped")
+	}
+
+	if err := CheckCredit(config.CreditConfig{Enabled: true, Allowance: 10, MaxCredit: 5, Action: config.RuleActionBan}); err == nil {
+		t.Error("Expected validation error for max_credit below allowance")
+	}
+}
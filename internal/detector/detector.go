@@ -2,27 +2,95 @@
 package detector
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/lbl1m/aria2bango/internal/aria2"
 	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/peerid"
+)
+
+// Detection reasons
+const (
+	ReasonLowShareRatio    = "low_share_ratio"
+	ReasonPeerIDAnomaly    = "peer_id_anomaly"   // peer ID格式异常或伪装
+	ReasonSwarmOutlier     = "swarm_outlier"     // 分享率远低于同一种子的其他peer
+	ReasonNonReciprocating = "non_reciprocating" // 持续choke我们，却从我们这里下载
+	ReasonCreditExhausted  = "credit_exhausted"  // 上传额度已用完
+	ReasonBurstDrain       = "burst_drain"       // 全速从我们这里下载，毫无回报
+)
+
+// Detection actions
+const (
+	ActionBlock    = "block"    // 屏蔽peer
+	ActionForgive  = "forgive"  // 分享率恢复，违规次数已清零
+	ActionThrottle = "throttle" // 限速peer（客户端规则）
+	ActionWatch    = "watch"    // 只记录，不处理（客户端规则）
 )
 
 // DetectionResult represents a detection result
 type DetectionResult struct {
+	Action        string
 	Peer          aria2.Peer
 	Reason        string
-	ShareRatio    float64
+	ShareRatio    float64       // 按behavior.ratio计算的分享率
 	Violations    int           // 违规次数
 	BlockDuration time.Duration // 本次屏蔽时长
+
+	// Evidence: accumulated totals from the peer's perspective and the
+	// threshold they were judged against
+	PeerUploaded   int64
+	PeerDownloaded int64
+	MinShareRatio  float64
+
+	// The share ratio over the peer's whole connection, and how much
+	// ShareRatio can be trusted
+	LifetimeShareRatio float64
+	RatioConfidence    float64
+
+	// How the peer compared with the other peers of its torrent, see
+	// DetectSwarm
+	SwarmPeers        int
+	SwarmZScore       float64
+	SwarmPercentile   float64
+	PieceAvailability float64 // peer拥有我们所缺分片的比例
+
+	// How long the peer kept choking us and what we uploaded to it
+	// meanwhile, see DetectReciprocity
+	ChokedFor    time.Duration
+	ChokedUpload int64
+
+	Credit float64 // 上传额度余额，见detection.credit
+
+	// Consecutive polls the peer drained our upload and its part of our
+	// total upload speed in the last one, see DetectBurst
+	BurstPolls     int
+	UploadFraction float64
+
+	// Structural problems of the peer ID, see peerid.Check
+	PeerIDScore     float64
+	PeerIDAnomalies []string
 }
 
 // Detector handles peer detection
 type Detector struct {
-	config     *config.DetectionConfig
+	config   *config.DetectionConfig
+	interval time.Duration // 轮询间隔，把速度换算为传输量
+	*state
+}
+
+// state is what detectors made by WithConfig share: peer statistics,
+// violations, credits, client rules and the punishment policy
+type state struct {
+	shared     *config.DetectionConfig // 共享部分的设置：统计方式、违规和额度
 	peerStats  map[string]*PeerStats
 	statsMutex sync.RWMutex
+	now        func() time.Time
+	rules      *ClientRules
+	punishment *Punishment
+	ledger     *ledger
+	credits    *creditLedger
 }
 
 // PeerStats tracks peer statistics for behavior analysis
@@ -32,38 +100,153 @@ type PeerStats struct {
 	TotalUpload   int64
 	FirstSeen     time.Time
 	LastSeen      time.Time
-	Violations    int       // 违规次数（累加惩罚）
-	LastBlocked   time.Time // 上次屏蔽时间
-	BlockedUntil  time.Time // 屏蔽到期时间
+
+	Ratio  Ratio      // 按behavior.ratio计算的分享率
+	Credit float64    // 上传额度余额
+	meter  ratioMeter // 最近的流量
+
+	ThrottledUntil time.Time // 限速到期时间
+	WatchedBy      string    // 已记录过的watch规则
+
+	ChokedSince  time.Time // peer开始持续choke我们的时间
+	ChokedUpload int64     // 此后我们上传给peer的量
+	ChokeWatched bool      // 已记录过本次choke
+	BurstPolls   int       // 连续抽干我们上传的轮询次数
+
+	PeerID          string   // 上次检查的peer ID
+	PeerIDScore     float64  // peer ID异常分数
+	PeerIDAnomalies []string // peer ID异常
 }
 
 // NewDetector creates a new detector
 func NewDetector(cfg *config.DetectionConfig) *Detector {
 	return &Detector{
-		config:    cfg,
-		peerStats: make(map[string]*PeerStats),
+		config:   cfg,
+		interval: time.Second,
+		state: &state{
+			shared:    cfg,
+			peerStats: make(map[string]*PeerStats),
+			now:       time.Now,
+			ledger:    newLedger(cfg.Violations),
+			credits:   newCreditLedger(cfg.Credit),
+		},
 	}
 }
 
-// Detect checks if a peer is a leecher based on behavior analysis
-func (d *Detector) Detect(peer aria2.Peer, baseBlockDuration time.Duration) *DetectionResult {
-	// Only use behavior analysis
-	if d.config.Behavior.Enabled {
-		return d.analyzeBehavior(peer, baseBlockDuration)
+// WithConfig returns a detector that judges peers by cfg but shares its
+// statistics, violations, credits, client rules and punishment policy
+// with d, e.g. for an aria2 instance with its own detection settings.
+// The violation and credit settings of cfg are not used, nor how the
+// share ratio is kept (behavior.ratio, window and ewma_half_life).
+func (d *Detector) WithConfig(cfg *config.DetectionConfig) *Detector {
+	return &Detector{config: cfg, interval: d.interval, state: d.state}
+}
+
+// SetPollInterval sets how often the peers judged by this detector are
+// polled, which turns their speeds into the bytes booked by the credit
+// ledger. It defaults to a second. Call it before the detector is used.
+func (d *Detector) SetPollInterval(interval time.Duration) {
+	d.interval = interval
+}
+
+// Check validates the settings of all detection strategies
+func Check(cfg config.DetectionConfig) error {
+	if err := CheckBehavior(cfg.Behavior); err != nil {
+		return fmt.Errorf("behavior: %w", err)
+	}
+	if err := CheckSwarm(cfg.Swarm); err != nil {
+		return fmt.Errorf("swarm: %w", err)
+	}
+	if err := CheckReciprocity(cfg.Reciprocity); err != nil {
+		return fmt.Errorf("reciprocity: %w", err)
+	}
+	if err := CheckCredit(cfg.Credit); err != nil {
+		return fmt.Errorf("credit: %w", err)
+	}
+	if err := CheckBurst(cfg.Burst); err != nil {
+		return fmt.Errorf("burst: %w", err)
 	}
 	return nil
 }
 
-// analyzeBehavior checks if peer exhibits leeching behavior
-func (d *Detector) analyzeBehavior(peer aria2.Peer, baseBlockDuration time.Duration) *DetectionResult {
+// SetClock replaces the clock used for statistics and block times, e.g. to
+// replay a recorded trace
+func (d *Detector) SetClock(now func() time.Time) {
 	d.statsMutex.Lock()
 	defer d.statsMutex.Unlock()
+	d.now = now
+}
+
+// SetClientRules replaces the client rules checked before behavior analysis
+func (d *Detector) SetClientRules(rules *ClientRules) {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+	d.rules = rules
+}
 
+// SetPunishment replaces the policy computing ban durations. Without one
+// bans escalate linearly (violations * base duration).
+func (d *Detector) SetPunishment(p *Punishment) {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+	d.punishment = p
+}
+
+// Detect checks a peer against the client rules, then whether it is a
+// leecher based on behavior analysis.
+// It returns nil if there is nothing to do for the peer.
+func (d *Detector) Detect(peer aria2.Peer, baseBlockDuration time.Duration) *DetectionResult {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	now := d.now()
+	stats := d.updateStats(peer, now)
+
+	result := d.detect(stats, peer, now, baseBlockDuration)
+	if result != nil {
+		result.PeerIDScore = stats.PeerIDScore
+		result.PeerIDAnomalies = stats.PeerIDAnomalies
+		result.LifetimeShareRatio = stats.LifetimeShareRatio()
+		result.RatioConfidence = stats.Ratio.Confidence
+		result.Credit = stats.Credit
+	}
+	return result
+}
+
+// detect applies client rules, the peer ID check and behavior analysis
+func (d *Detector) detect(stats *PeerStats, peer aria2.Peer, now time.Time, baseBlockDuration time.Duration) *DetectionResult {
+	if rule := d.rules.Match(peer.PeerID); rule != nil {
+		if result := d.applyRule(rule, stats, peer, now, baseBlockDuration); result != nil {
+			return result
+		}
+		if rule.Action == config.RuleActionBan {
+			// Banned by the rule, behavior does not matter
+			return nil
+		}
+	}
+
+	if result := d.checkPeerID(stats, peer, now, baseBlockDuration); result != nil {
+		return result
+	}
+
+	if result := d.checkCredit(stats, peer, now, baseBlockDuration); result != nil {
+		return result
+	}
+
+	// Only use behavior analysis
+	if d.config.Behavior.Enabled {
+		return d.analyzeBehavior(stats, peer, now, baseBlockDuration)
+	}
+	return nil
+}
+
+// updateStats accounts a poll of the peer's speeds
+func (d *Detector) updateStats(peer aria2.Peer, now time.Time) *PeerStats {
 	stats, exists := d.peerStats[peer.IP]
 	if !exists {
 		stats = &PeerStats{
 			IP:        peer.IP,
-			FirstSeen: time.Now(),
+			FirstSeen: now,
 		}
 		d.peerStats[peer.IP] = stats
 	}
@@ -76,54 +259,191 @@ func (d *Detector) analyzeBehavior(peer aria2.Peer, baseBlockDuration time.Durat
 	//   - peer's download = our upload to them
 	stats.TotalDownload += peer.DownloadSpeed // peer's upload (what they give us)
 	stats.TotalUpload += peer.UploadSpeed     // peer's download (what they take from us)
-	stats.LastSeen = time.Now()
+	stats.LastSeen = now
+	// Statistics are shared by all instances, keep them one way
+	stats.meter.add(d.shared.Behavior, now, peer.DownloadSpeed, peer.UploadSpeed)
+	stats.Ratio = stats.ratio(d.shared.Behavior, now)
+	if d.shared.Credit.Enabled {
+		received, sent := d.transferred(peer.DownloadSpeed), d.transferred(peer.UploadSpeed)
+		if d.ledger.blocked(peer.IP, now) {
+			// Nothing reaches a banned peer, whatever aria2 reports
+			sent = 0
+		}
+		stats.Credit = d.credits.account(peer.IP, now, received, sent, d.credits.multiplier(peer.PeerID))
+	}
+
+	// Peer IDs are fixed for a connection, check them once
+	if d.config.PeerID.Enabled && peer.PeerID != stats.PeerID {
+		report := peerid.Check(peer.PeerID)
+		stats.PeerID = peer.PeerID
+		stats.PeerIDScore = report.Score
+		stats.PeerIDAnomalies = report.Anomalies
+	}
+	return stats
+}
 
+// checkPeerID blocks a peer whose peer ID is anomalous enough on its own.
+// Blocks escalate like behavior blocks.
+func (d *Detector) checkPeerID(stats *PeerStats, peer aria2.Peer, now time.Time, baseBlockDuration time.Duration) *DetectionResult {
+	cfg := d.config.PeerID
+	if !cfg.Enabled || cfg.BanScore <= 0 || stats.PeerIDScore < cfg.BanScore {
+		return nil
+	}
+	if d.ledger.blocked(peer.IP, now) {
+		return nil
+	}
+
+	violations, blockDuration := d.violate(peer.IP, ReasonPeerIDAnomaly, 0, now, baseBlockDuration)
+	return &DetectionResult{
+		Action:         ActionBlock,
+		Peer:           peer,
+		Reason:         ReasonPeerIDAnomaly,
+		ShareRatio:     stats.Ratio.ShareRatio,
+		Violations:     violations,
+		BlockDuration:  blockDuration,
+		PeerUploaded:   stats.TotalDownload,
+		PeerDownloaded: stats.TotalUpload,
+	}
+}
+
+// violate records a violation of an IP and blocks it for the given
+// duration, or the duration from the punishment policy if it is zero
+func (d *Detector) violate(ip, reason string, duration time.Duration, now time.Time, baseBlockDuration time.Duration) (int, time.Duration) {
+	violations := d.ledger.add(ip, now)
+	if duration == 0 {
+		duration = d.punishment.Duration(reason, violations, baseBlockDuration)
+	}
+	d.ledger.block(ip, now.Add(duration))
+	return violations, duration
+}
+
+// suspect reports whether the peer ID makes the peer suspicious
+func (d *Detector) suspect(stats *PeerStats) bool {
+	cfg := d.config.PeerID
+	return cfg.Enabled && cfg.SuspectScore > 0 && stats.PeerIDScore >= cfg.SuspectScore
+}
+
+// applyRule acts on a peer matched by a client rule. Bans count as
+// violations and escalate like behavior bans unless the rule sets a fixed
+// duration; throttling is repeated when it expires; watch is reported once.
+func (d *Detector) applyRule(rule *config.ClientRule, stats *PeerStats, peer aria2.Peer, now time.Time, baseBlockDuration time.Duration) *DetectionResult {
+	result := &DetectionResult{
+		Peer:           peer,
+		Reason:         RuleReasonPrefix + rule.Name,
+		ShareRatio:     stats.Ratio.ShareRatio,
+		Violations:     d.ledger.violations(peer.IP, now),
+		PeerUploaded:   stats.TotalDownload,
+		PeerDownloaded: stats.TotalUpload,
+	}
+
+	switch rule.Action {
+	case config.RuleActionBan:
+		if d.ledger.blocked(peer.IP, now) {
+			return nil
+		}
+		result.Action = ActionBlock
+		result.Violations, result.BlockDuration = d.violate(peer.IP, result.Reason, rule.Duration, now, baseBlockDuration)
+
+	case config.RuleActionThrottle:
+		if now.Before(stats.ThrottledUntil) {
+			return nil
+		}
+		duration := rule.Duration
+		if duration == 0 {
+			duration = baseBlockDuration
+		}
+		stats.ThrottledUntil = now.Add(duration)
+		result.Action = ActionThrottle
+		result.BlockDuration = duration
+
+	case config.RuleActionWatch:
+		if stats.WatchedBy == rule.Name {
+			return nil
+		}
+		stats.WatchedBy = rule.Name
+		result.Action = ActionWatch
+
+	default:
+		return nil
+	}
+	return result
+}
+
+// LifetimeShareRatio returns the peer's upload divided by its download
+// since it was first seen
+func (s *PeerStats) LifetimeShareRatio() float64 {
+	if s.TotalUpload == 0 {
+		return 0
+	}
+	return float64(s.TotalDownload) / float64(s.TotalUpload)
+}
+
+// analyzeBehavior checks if peer exhibits leeching behavior
+func (d *Detector) analyzeBehavior(stats *PeerStats, peer aria2.Peer, now time.Time, baseBlockDuration time.Duration) *DetectionResult {
 	// Check if already blocked
-	if !stats.BlockedUntil.IsZero() && time.Now().Before(stats.BlockedUntil) {
+	if d.ledger.blocked(peer.IP, now) {
 		// Already blocked, skip
 		return nil
 	}
 
-	// Check if we have enough data
-	if stats.TotalUpload < d.config.Behavior.MinDataThreshold {
+	// Check if the peer was observed long enough to be judged
+	cfg := d.config.Behavior
+	if now.Sub(stats.FirstSeen) < cfg.MinObservation || stats.Ratio.Confidence < cfg.MinConfidence {
+		return nil
+	}
+
+	// Check if we have enough data in the window. Peers with a suspicious
+	// peer ID are judged earlier.
+	threshold := cfg.MinDataThreshold
+	if d.suspect(stats) && d.config.PeerID.SuspectDataThreshold < threshold {
+		threshold = d.config.PeerID.SuspectDataThreshold
+	}
+	if stats.Ratio.Upload < threshold {
 		return nil
 	}
 
-	// Calculate share ratio from peer's perspective
+	// Share ratio from peer's perspective over the window
 	// shareRatio = peer's upload / peer's download
 	// A leecher has low shareRatio (uploads little, downloads a lot)
-	shareRatio := float64(0)
-	if stats.TotalUpload > 0 {
-		shareRatio = float64(stats.TotalDownload) / float64(stats.TotalUpload)
-	}
+	shareRatio := stats.Ratio.ShareRatio
 
 	// Check if share ratio is below threshold
 	// Low shareRatio means peer downloads a lot but uploads little
-	if shareRatio < d.config.Behavior.MinShareRatio {
-		// Increment violation count
-		stats.Violations++
-
-		// Calculate block duration: violations * base_duration
+	if shareRatio < cfg.MinShareRatio {
+		// Increment violation count and calculate block duration with the
+		// punishment policy, by default violations * base_duration
 		// e.g., 1st: 1*5min, 2nd: 2*5min, 3rd: 3*5min
-		blockDuration := time.Duration(stats.Violations) * baseBlockDuration
-		stats.LastBlocked = time.Now()
-		stats.BlockedUntil = time.Now().Add(blockDuration)
+		violations, blockDuration := d.violate(peer.IP, ReasonLowShareRatio, 0, now, baseBlockDuration)
 
 		return &DetectionResult{
-			Peer:          peer,
-			Reason:        "low_share_ratio",
-			ShareRatio:    shareRatio,
-			Violations:    stats.Violations,
-			BlockDuration: blockDuration,
+			Action:         ActionBlock,
+			Peer:           peer,
+			Reason:         ReasonLowShareRatio,
+			ShareRatio:     shareRatio,
+			Violations:     violations,
+			BlockDuration:  blockDuration,
+			PeerUploaded:   stats.TotalDownload,
+			PeerDownloaded: stats.TotalUpload,
+			MinShareRatio:  cfg.MinShareRatio,
 		}
 	}
 
-	// Share ratio is normal - check if we should reset violations
-	// If previously blocked and now share ratio is normal, reset violations
-	// This gives the peer a fresh start
-	if stats.Violations > 0 && !stats.BlockedUntil.IsZero() && time.Now().After(stats.BlockedUntil) {
-		stats.Violations = 0
-		stats.BlockedUntil = time.Time{} // Clear block time
+	// Share ratio is normal - violations decay in the ledger. Only if
+	// configured, reset them once the block is over, giving the peer a
+	// fresh start at once.
+	if !d.shared.Violations.ForgiveOnRecovery {
+		return nil
+	}
+	if violations := d.ledger.violations(peer.IP, now); violations > 0 && d.ledger.blockExpired(peer.IP, now) {
+		d.ledger.reset(peer.IP)
+
+		return &DetectionResult{
+			Action:     ActionForgive,
+			Peer:       peer,
+			Reason:     "share_ratio_recovered",
+			ShareRatio: shareRatio,
+			Violations: violations,
+		}
 	}
 
 	return nil
@@ -133,28 +453,32 @@ func (d *Detector) analyzeBehavior(peer aria2.Peer, baseBlockDuration time.Durat
 func (d *Detector) GetViolationCount(ip string) int {
 	d.statsMutex.RLock()
 	defer d.statsMutex.RUnlock()
-	if stats, exists := d.peerStats[ip]; exists {
-		return stats.Violations
-	}
-	return 0
+	return d.ledger.violations(ip, d.now())
 }
 
 // ResetViolations resets the violation count for an IP
 func (d *Detector) ResetViolations(ip string) {
 	d.statsMutex.Lock()
 	defer d.statsMutex.Unlock()
-	if stats, exists := d.peerStats[ip]; exists {
-		stats.Violations = 0
-		stats.BlockedUntil = time.Time{} // Clear block time
-	}
+	d.ledger.reset(ip)
 }
 
-// CleanupStaleStats removes stale peer statistics
+// PruneViolations forgets violation counts that decayed to nothing or
+// are older than forget_after and returns the IPs forgotten. Independent
+// of CleanupStaleStats.
+func (d *Detector) PruneViolations() []string {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+	return d.ledger.prune(d.now())
+}
+
+// CleanupStaleStats removes stale peer statistics. Violation counts are
+// kept, see PruneViolations.
 func (d *Detector) CleanupStaleStats(maxAge time.Duration) {
 	d.statsMutex.Lock()
 	defer d.statsMutex.Unlock()
 
-	cutoff := time.Now().Add(-maxAge)
+	cutoff := d.now().Add(-maxAge)
 	for ip, stats := range d.peerStats {
 		if stats.LastSeen.Before(cutoff) {
 			delete(d.peerStats, ip)
@@ -184,8 +508,5 @@ func (d *Detector) GetAllStats() map[string]*PeerStats {
 func (d *Detector) IsBlocked(ip string) bool {
 	d.statsMutex.RLock()
 	defer d.statsMutex.RUnlock()
-	if stats, exists := d.peerStats[ip]; exists {
-		return !stats.BlockedUntil.IsZero() && time.Now().Before(stats.BlockedUntil)
-	}
-	return false
+	return d.ledger.blocked(ip, d.now())
 }
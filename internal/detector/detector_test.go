@@ -0,0 +1,109 @@
+package detector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// instantConfig returns the default detection settings, judging peers on
+// their first poll
+func instantConfig() config.DetectionConfig {
+	cfg := config.DefaultConfig().Detection
+	cfg.Behavior.MinObservation = 0
+	cfg.Behavior.MinConfidence = 0
+	return cfg
+}
+
+func TestDetectSuspectPeerID(t *testing.T) {
+	cfg := instantConfig()
+	cfg.PeerID.Enabled = true
+	d := NewDetector(&cfg)
+
+	// 4MB taken, nothing given: below min_data_threshold for a plausible
+	// peer ID, above suspect_data_threshold for a spoofed one
+	genuine := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 4 << 20}
+	if result := d.Detect(genuine, 5*time.Minute); result != nil {
+		t.Errorf("Expected no result for genuine peer ID, got %+v", result)
+	}
+
+	spoofed := aria2.Peer{IP: "10.0.0.2", PeerID: "-qB4250-\x8a\x01\xff\x10abcdefgh", UploadSpeed: 4 << 20}
+	result := d.Detect(spoofed, 5*time.Minute)
+	if result == nil || result.Action != ActionBlock || result.Reason != "low_share_ratio" {
+		t.Fatalf("Expected early behavior ban for spoofed peer ID, got %+v", result)
+	}
+	if result.PeerIDScore != 0.6 || strings.Join(result.PeerIDAnomalies, ",") != "bad_charset" {
+		t.Errorf("Unexpected peer ID evidence: %v %v", result.PeerIDScore, result.PeerIDAnomalies)
+	}
+
+	// Disabled, the default: judged like everyone else
+	cfg.PeerID.Enabled = false
+	d = NewDetector(&cfg)
+	if result := d.Detect(spoofed, 5*time.Minute); result != nil {
+		t.Errorf("Expected no result with peer ID check disabled, got %+v", result)
+	}
+}
+
+func TestDetectPeerIDBan(t *testing.T) {
+	cfg := config.DefaultConfig().Detection
+	cfg.PeerID.Enabled = true
+	cfg.PeerID.BanScore = 1
+	d := NewDetector(&cfg)
+
+	zero := aria2.Peer{IP: "10.0.0.1", PeerID: strings.Repeat("%00", 20)}
+	result := d.Detect(zero, 5*time.Minute)
+	if result == nil || result.Action != ActionBlock || result.Reason != ReasonPeerIDAnomaly {
+		t.Fatalf("Expected peer ID ban, got %+v", result)
+	}
+	if result.Violations != 1 || result.BlockDuration != 5*time.Minute || result.PeerIDScore != 1 {
+		t.Errorf("Unexpected ban: %+v", result)
+	}
+	if result := d.Detect(zero, 5*time.Minute); result != nil {
+		t.Errorf("Expected no result while banned, got %+v", result)
+	}
+
+	// Below ban_score: no ban without behavior
+	odd := aria2.Peer{IP: "10.0.0.2", PeerID: "-TR2940-ABCDEFGHIJKL"}
+	if result := d.Detect(odd, 5*time.Minute); result != nil {
+		t.Errorf("Expected no result below ban_score, got %+v", result)
+	}
+}
+
+func TestWithConfig(t *testing.T) {
+	cfg := instantConfig()
+	d := NewDetector(&cfg)
+	strictCfg := cfg
+	strictCfg.Behavior.MinShareRatio = 0.5
+	strict := d.WithConfig(&strictCfg)
+
+	// Share ratio 0.2 passes the shared settings but not the strict ones
+	peer := aria2.Peer{IP: "10.0.0.1", UploadSpeed: 20 << 20, DownloadSpeed: 4 << 20}
+	if result := d.Detect(peer, 5*time.Minute); result != nil {
+		t.Fatalf("Expected no result with the shared settings, got %+v", result)
+	}
+	result := strict.Detect(peer, 5*time.Minute)
+	if result == nil || result.Action != ActionBlock || result.MinShareRatio != 0.5 {
+		t.Fatalf("Expected a ban with the strict settings, got %+v", result)
+	}
+
+	// Statistics and bans are shared
+	if stats := d.GetStats(peer.IP); stats.TotalUpload != 40<<20 {
+		t.Errorf("Expected traffic of both detectors in the stats, got %d", stats.TotalUpload)
+	}
+	if !d.IsBlocked(peer.IP) || d.GetViolationCount(peer.IP) != 1 {
+		t.Error("Expected the ban to show in the shared detector")
+	}
+
+	// Credits follow the shared settings whatever the view says
+	cfg.Credit.Enabled = true
+	d = NewDetector(&cfg)
+	viewCfg := cfg
+	viewCfg.Credit.Enabled = false
+	d.WithConfig(&viewCfg).Detect(peer, 5*time.Minute)
+	if stats := d.GetStats(peer.IP); stats.Credit == 0 {
+		t.Errorf("Expected credit to be accounted by the shared settings, got %v", stats.Credit)
+	}
+}
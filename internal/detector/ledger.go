@@ -0,0 +1,129 @@
+package detector
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// minLedgerScore is the score below which an entry carries no information
+// and is dropped
+const minLedgerScore = 0.01
+
+// ledger keeps the violation counts of IPs apart from traffic statistics,
+// which are dropped soon after a peer disconnects. Counts decay with a
+// half-life, so a peer that leeches weekly keeps escalating while a one
+// time offender fades away. Guarded by the detector's mutex.
+type ledger struct {
+	halfLife    time.Duration
+	forgetAfter time.Duration
+	entries     map[string]*ledgerEntry
+}
+
+// ledgerEntry is the punishment state of an IP
+type ledgerEntry struct {
+	score        float64   // 违规次数，按半衰期衰减
+	updated      time.Time // score的计算时间
+	lastBlocked  time.Time // 上次屏蔽时间
+	blockedUntil time.Time // 屏蔽到期时间
+}
+
+// newLedger creates an empty ledger
+func newLedger(cfg config.ViolationsConfig) *ledger {
+	return &ledger{
+		halfLife:    cfg.HalfLife,
+		forgetAfter: cfg.ForgetAfter,
+		entries:     make(map[string]*ledgerEntry),
+	}
+}
+
+// decayed returns the score of an entry at a point in time, 0 once it is
+// to be forgotten
+func (l *ledger) decayed(e *ledgerEntry, now time.Time) float64 {
+	if l.forgetAfter > 0 && now.Sub(e.lastBlocked) >= l.forgetAfter {
+		return 0
+	}
+	elapsed := now.Sub(e.updated)
+	if l.halfLife <= 0 || elapsed <= 0 {
+		return e.score
+	}
+	return e.score * math.Pow(0.5, float64(elapsed)/float64(l.halfLife))
+}
+
+// violations returns the current violation count of an IP
+func (l *ledger) violations(ip string, now time.Time) int {
+	e, ok := l.entries[ip]
+	if !ok {
+		return 0
+	}
+	return int(math.Round(l.decayed(e, now)))
+}
+
+// add records a violation and returns the new violation count
+func (l *ledger) add(ip string, now time.Time) int {
+	e, ok := l.entries[ip]
+	if !ok {
+		e = &ledgerEntry{}
+		l.entries[ip] = e
+	}
+	e.score = l.decayed(e, now) + 1
+	e.updated = now
+	e.lastBlocked = now
+	return int(math.Round(e.score))
+}
+
+// block records until when an IP is blocked
+func (l *ledger) block(ip string, until time.Time) {
+	if e, ok := l.entries[ip]; ok {
+		e.blockedUntil = until
+	}
+}
+
+// blocked reports whether an IP is blocked
+func (l *ledger) blocked(ip string, now time.Time) bool {
+	e, ok := l.entries[ip]
+	return ok && now.Before(e.blockedUntil)
+}
+
+// blockExpired reports whether an IP was blocked and the block has ended
+func (l *ledger) blockExpired(ip string, now time.Time) bool {
+	e, ok := l.entries[ip]
+	return ok && !e.blockedUntil.IsZero() && now.After(e.blockedUntil)
+}
+
+// restore sets the state of an IP unless it already has more violations
+func (l *ledger) restore(ip string, violations int, lastBlocked, blockedUntil, now time.Time) {
+	if l.violations(ip, now) >= violations {
+		return
+	}
+	l.entries[ip] = &ledgerEntry{
+		score:        float64(violations),
+		updated:      lastBlocked,
+		lastBlocked:  lastBlocked,
+		blockedUntil: blockedUntil,
+	}
+}
+
+// reset forgets an IP
+func (l *ledger) reset(ip string) {
+	delete(l.entries, ip)
+}
+
+// prune forgets IPs without a violation for forgetAfter, or whose score
+// decayed to nothing, and returns them. Running blocks are kept.
+func (l *ledger) prune(now time.Time) []string {
+	var forgotten []string
+	for ip, e := range l.entries {
+		if now.Before(e.blockedUntil) {
+			continue
+		}
+		if l.decayed(e, now) < minLedgerScore {
+			delete(l.entries, ip)
+			forgotten = append(forgotten, ip)
+		}
+	}
+	sort.Strings(forgotten)
+	return forgotten
+}
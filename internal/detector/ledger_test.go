@@ -0,0 +1,105 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+const day = 24 * time.Hour
+
+func TestLedgerDecay(t *testing.T) {
+	l := newLedger(config.ViolationsConfig{HalfLife: 7 * day, ForgetAfter: 30 * day})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Weekly leecher: 1, then 0.5+1, 0.75+1, ... keeps escalating
+	if n := l.add("10.0.0.1", now); n != 1 {
+		t.Errorf("First violation = %d, want 1", n)
+	}
+	for week := 1; week <= 3; week++ {
+		if n := l.add("10.0.0.1", now.Add(time.Duration(week)*7*day)); n != 2 {
+			t.Errorf("Violation in week %d = %d, want 2", week, n)
+		}
+	}
+	if n := l.add("10.0.0.1", now.Add(21*day+time.Hour)); n != 3 {
+		t.Errorf("Violation right after = %d, want 3", n)
+	}
+
+	// One-time offender fades, then is forgotten
+	l.add("10.0.0.2", now)
+	if n := l.violations("10.0.0.2", now.Add(6*day)); n != 1 {
+		t.Errorf("Violations after 6 days = %d, want 1", n)
+	}
+	if n := l.violations("10.0.0.2", now.Add(8*day)); n != 0 {
+		t.Errorf("Violations after 8 days = %d, want 0", n)
+	}
+	l.prune(now.Add(29 * day))
+	if _, ok := l.entries["10.0.0.2"]; !ok {
+		t.Error("Expected entry to be kept before forget_after")
+	}
+	l.prune(now.Add(30 * day))
+	if _, ok := l.entries["10.0.0.2"]; ok {
+		t.Error("Expected entry to be forgotten after forget_after")
+	}
+	if n := l.add("10.0.0.2", now.Add(31*day)); n != 1 {
+		t.Errorf("Violation after forget_after = %d, want 1", n)
+	}
+
+	// Running blocks survive pruning
+	l.add("10.0.0.3", now)
+	l.block("10.0.0.3", now.Add(60*day))
+	l.prune(now.Add(40 * day))
+	if !l.blocked("10.0.0.3", now.Add(40*day)) {
+		t.Error("Expected running block to survive pruning")
+	}
+}
+
+func TestDetectViolationsOutliveStats(t *testing.T) {
+	cfg := instantConfig()
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 20 << 20}
+	if result := d.Detect(peer, 5*time.Minute); result == nil || result.Violations != 1 {
+		t.Fatalf("Unexpected first ban: %+v", result)
+	}
+
+	// The peer leaves, its stats are dropped, it comes back a day later
+	now = now.Add(day)
+	d.CleanupStaleStats(30 * time.Minute)
+	d.PruneViolations()
+	result := d.Detect(peer, 5*time.Minute)
+	if result == nil || result.Violations != 2 || result.BlockDuration != 10*time.Minute {
+		t.Fatalf("Expected escalated ban after stats cleanup, got %+v", result)
+	}
+	if n := d.GetViolationCount(peer.IP); n != 2 {
+		t.Errorf("GetViolationCount = %d, want 2", n)
+	}
+}
+
+func TestDetectForgiveOnRecovery(t *testing.T) {
+	cfg := instantConfig()
+	cfg.Violations.ForgiveOnRecovery = true
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 20 << 20}
+	if result := d.Detect(peer, 5*time.Minute); result == nil || result.Action != ActionBlock {
+		t.Fatalf("Unexpected ban: %+v", result)
+	}
+
+	// Uploads plenty after the block: forgiven at once
+	now = now.Add(6 * time.Minute)
+	peer.DownloadSpeed = 100 << 20
+	result := d.Detect(peer, 5*time.Minute)
+	if result == nil || result.Action != ActionForgive || result.Violations != 1 {
+		t.Fatalf("Expected forgive, got %+v", result)
+	}
+	if n := d.GetViolationCount(peer.IP); n != 0 {
+		t.Errorf("GetViolationCount after forgive = %d, want 0", n)
+	}
+}
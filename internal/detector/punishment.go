@@ -0,0 +1,139 @@
+package detector
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// Permanent is the block duration of bans that never expire. The firewall
+// adds them without a timeout.
+const Permanent = time.Duration(math.MaxInt64)
+
+// permanentText is how permanent durations are written to events
+const permanentText = "permanent"
+
+// FormatDuration formats a block duration for events and the API
+func FormatDuration(d time.Duration) string {
+	if d == Permanent {
+		return permanentText
+	}
+	return d.String()
+}
+
+// ParseDuration parses a duration written by FormatDuration
+func ParseDuration(s string) (time.Duration, error) {
+	if s == permanentText {
+		return Permanent, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Punishment computes ban durations from violation counts
+type Punishment struct {
+	policy  policy
+	reasons map[string]policy
+	random  func() float64
+}
+
+// policy is a validated punishment policy
+type policy struct {
+	config.PunishmentConfig
+}
+
+// NewPunishment validates the punishment configuration
+func NewPunishment(cfg config.PunishmentConfig) (*Punishment, error) {
+	p := &Punishment{
+		reasons: make(map[string]policy, len(cfg.Reasons)),
+		random:  rand.Float64,
+	}
+	var err error
+	if p.policy, err = newPolicy(cfg); err != nil {
+		return nil, fmt.Errorf("punishment: %w", err)
+	}
+	for reason, rc := range cfg.Reasons {
+		if len(rc.Reasons) > 0 {
+			return nil, fmt.Errorf("punishment for %s: reasons cannot be nested", reason)
+		}
+		if p.reasons[reason], err = newPolicy(rc); err != nil {
+			return nil, fmt.Errorf("punishment for %s: %w", reason, err)
+		}
+	}
+	return p, nil
+}
+
+// newPolicy validates a single policy
+func newPolicy(cfg config.PunishmentConfig) (policy, error) {
+	switch cfg.Policy {
+	case "", config.PolicyLinear:
+	case config.PolicyExponential:
+		if cfg.Factor <= 1 {
+			return policy{}, fmt.Errorf("exponential factor must be greater than 1, got %v", cfg.Factor)
+		}
+	case config.PolicySteps:
+		if len(cfg.Steps) == 0 {
+			return policy{}, fmt.Errorf("steps policy needs steps")
+		}
+		for _, step := range cfg.Steps {
+			if step <= 0 {
+				return policy{}, fmt.Errorf("steps must be positive, got %s", step)
+			}
+		}
+	default:
+		return policy{}, fmt.Errorf("unknown policy %q (want linear, exponential or steps)", cfg.Policy)
+	}
+	if cfg.Max < 0 {
+		return policy{}, fmt.Errorf("negative max")
+	}
+	if cfg.Jitter < 0 || cfg.Jitter >= 1 {
+		return policy{}, fmt.Errorf("jitter must be between 0 and 1, got %v", cfg.Jitter)
+	}
+	if cfg.PermanentAfter < 0 {
+		return policy{}, fmt.Errorf("negative permanent_after")
+	}
+	return policy{PunishmentConfig: cfg}, nil
+}
+
+// Duration returns the ban duration for the given violation count of a
+// reason. A nil Punishment escalates linearly.
+func (p *Punishment) Duration(reason string, violations int, base time.Duration) time.Duration {
+	if violations < 1 {
+		violations = 1
+	}
+	if p == nil {
+		return time.Duration(violations) * base
+	}
+
+	pol, ok := p.reasons[reason]
+	if !ok {
+		pol = p.policy
+	}
+	if pol.PermanentAfter > 0 && violations >= pol.PermanentAfter {
+		return Permanent
+	}
+
+	var d float64
+	switch pol.Policy {
+	case config.PolicyExponential:
+		d = float64(base) * math.Pow(pol.Factor, float64(violations-1))
+	case config.PolicySteps:
+		d = float64(pol.Steps[min(violations, len(pol.Steps))-1])
+	default:
+		d = float64(violations) * float64(base)
+	}
+	if pol.Jitter > 0 {
+		d *= 1 + pol.Jitter*(2*p.random()-1)
+		d = math.Round(d/float64(time.Second)) * float64(time.Second)
+	}
+	if pol.Max > 0 && d > float64(pol.Max) {
+		d = float64(pol.Max)
+	}
+	if d >= float64(Permanent) {
+		// Exponential growth beyond what a duration can hold is permanent
+		return Permanent
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,128 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+func TestPunishmentDuration(t *testing.T) {
+	p, err := NewPunishment(config.PunishmentConfig{
+		Policy: config.PolicyLinear,
+		Max:    time.Hour,
+		Reasons: map[string]config.PunishmentConfig{
+			ReasonPeerIDAnomaly:    {Policy: config.PolicyExponential, Factor: 3},
+			"client_rule:xunlei":   {Policy: config.PolicySteps, Steps: []time.Duration{time.Hour, 24 * time.Hour}, PermanentAfter: 3},
+			"client_rule:capped":   {Policy: config.PolicyExponential, Factor: 2, Max: 30 * time.Minute},
+			"client_rule:forever1": {PermanentAfter: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := 5 * time.Minute
+	tests := []struct {
+		reason     string
+		violations int
+		want       time.Duration
+	}{
+		{ReasonLowShareRatio, 1, 5 * time.Minute},
+		{ReasonLowShareRatio, 3, 15 * time.Minute},
+		{ReasonLowShareRatio, 20, time.Hour}, // max
+		{ReasonPeerIDAnomaly, 1, 5 * time.Minute},
+		{ReasonPeerIDAnomaly, 3, 45 * time.Minute},
+		{ReasonPeerIDAnomaly, 5, 405 * time.Minute}, // per-reason policy has no max
+		{"client_rule:xunlei", 1, time.Hour},
+		{"client_rule:xunlei", 2, 24 * time.Hour},
+		{"client_rule:xunlei", 3, Permanent},
+		{"client_rule:capped", 10, 30 * time.Minute},
+		{"client_rule:forever1", 1, Permanent},
+		{"client_rule:other", 2, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.Duration(tt.reason, tt.violations, base); got != tt.want {
+			t.Errorf("Duration(%s, %d) = %s, want %s", tt.reason, tt.violations, got, tt.want)
+		}
+	}
+
+	// Exponential growth beyond a duration is permanent, unless capped
+	if got := p.Duration(ReasonPeerIDAnomaly, 200, base); got != Permanent {
+		t.Errorf("Expected permanent on overflow, got %d", got)
+	}
+	if got := p.Duration("client_rule:capped", 200, base); got != 30*time.Minute {
+		t.Errorf("Expected the cap on overflow, got %s", got)
+	}
+
+	var none *Punishment
+	if got := none.Duration(ReasonLowShareRatio, 3, base); got != 15*time.Minute {
+		t.Errorf("nil Punishment: got %s, want linear 15m", got)
+	}
+}
+
+func TestPunishmentJitter(t *testing.T) {
+	p, err := NewPunishment(config.PunishmentConfig{Policy: config.PolicyLinear, Jitter: 0.2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		random float64
+		want   time.Duration
+	}{
+		{0, 8 * time.Minute},
+		{0.5, 10 * time.Minute},
+		{1, 12 * time.Minute},
+	} {
+		p.random = func() float64 { return tt.random }
+		if got := p.Duration(ReasonLowShareRatio, 2, 5*time.Minute); got != tt.want {
+			t.Errorf("random %v: got %s, want %s", tt.random, got, tt.want)
+		}
+	}
+}
+
+func TestNewPunishmentInvalid(t *testing.T) {
+	invalid := []config.PunishmentConfig{
+		{Policy: "fibonacci"},
+		{Policy: config.PolicyExponential, Factor: 1},
+		{Policy: config.PolicySteps},
+		{Policy: config.PolicySteps, Steps: []time.Duration{time.Hour, 0}},
+		{Max: -time.Minute},
+		{Jitter: 1},
+		{PermanentAfter: -1},
+		{Reasons: map[string]config.PunishmentConfig{ReasonLowShareRatio: {Policy: config.PolicyExponential}}},
+		{Reasons: map[string]config.PunishmentConfig{ReasonLowShareRatio: {Reasons: map[string]config.PunishmentConfig{"x": {}}}}},
+	}
+	for i, cfg := range invalid {
+		if _, err := NewPunishment(cfg); err == nil {
+			t.Errorf("Case %d: expected validation error", i)
+		}
+	}
+}
+
+func TestDetectPermanentBan(t *testing.T) {
+	cfg := instantConfig()
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+	p, err := NewPunishment(config.PunishmentConfig{PermanentAfter: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetPunishment(p)
+
+	peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 20 << 20}
+	if result := d.Detect(peer, 5*time.Minute); result == nil || result.BlockDuration != 5*time.Minute {
+		t.Fatalf("Unexpected first ban: %+v", result)
+	}
+	now = now.Add(6 * time.Minute)
+	result := d.Detect(peer, 5*time.Minute)
+	if result == nil || result.BlockDuration != Permanent {
+		t.Fatalf("Expected permanent second ban, got %+v", result)
+	}
+	now = now.Add(10 * 365 * 24 * time.Hour)
+	if !d.IsBlocked(peer.IP) {
+		t.Error("Expected permanent ban to stay in effect")
+	}
+}
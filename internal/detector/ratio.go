@@ -0,0 +1,113 @@
+package detector
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// Ratio is a share ratio with the data it was computed from. Download and
+// Upload are from our side like PeerStats' totals: Download is what the
+// peer gave us, Upload what it took.
+type Ratio struct {
+	ShareRatio float64
+	Download   int64
+	Upload     int64
+	Confidence float64 // 0-1，观察时间相对窗口（或半衰期）是否足够
+}
+
+// ratioSample is one poll of a peer's speeds
+type ratioSample struct {
+	at       time.Time
+	download int64
+	upload   int64
+}
+
+// ratioMeter measures recent traffic of a peer, over a sliding window and
+// as an exponentially weighted moving average, next to the lifetime totals
+// in PeerStats
+type ratioMeter struct {
+	samples      []ratioSample
+	ewmaDownload float64
+	ewmaUpload   float64
+	ewmaUpdated  time.Time
+}
+
+// CheckBehavior validates the share ratio settings of behavior analysis
+func CheckBehavior(cfg config.BehaviorConfig) error {
+	switch cfg.Ratio {
+	case "", config.RatioLifetime:
+	case config.RatioWindow:
+		if cfg.Window <= 0 {
+			return fmt.Errorf("window ratio needs a positive window")
+		}
+	case config.RatioEWMA:
+		if cfg.EWMAHalfLife <= 0 {
+			return fmt.Errorf("ewma ratio needs a positive ewma_half_life")
+		}
+	default:
+		return fmt.Errorf("unknown ratio %q (want window, ewma or lifetime)", cfg.Ratio)
+	}
+	if cfg.MinObservation < 0 {
+		return fmt.Errorf("negative min_observation")
+	}
+	if cfg.MinConfidence < 0 || cfg.MinConfidence > 1 {
+		return fmt.Errorf("min_confidence must be between 0 and 1, got %v", cfg.MinConfidence)
+	}
+	return nil
+}
+
+// add accounts a poll of the peer's speeds
+func (m *ratioMeter) add(cfg config.BehaviorConfig, now time.Time, download, upload int64) {
+	switch cfg.Ratio {
+	case config.RatioWindow:
+		m.samples = append(m.samples, ratioSample{at: now, download: download, upload: upload})
+		cutoff := now.Add(-cfg.Window)
+		i := 0
+		for i < len(m.samples) && !m.samples[i].at.After(cutoff) {
+			i++
+		}
+		m.samples = append(m.samples[:0], m.samples[i:]...)
+
+	case config.RatioEWMA:
+		if !m.ewmaUpdated.IsZero() {
+			decay := math.Pow(0.5, float64(now.Sub(m.ewmaUpdated))/float64(cfg.EWMAHalfLife))
+			m.ewmaDownload *= decay
+			m.ewmaUpload *= decay
+		}
+		m.ewmaDownload += float64(download)
+		m.ewmaUpload += float64(upload)
+		m.ewmaUpdated = now
+	}
+}
+
+// ratio computes the share ratio of a peer with the configured mode. The
+// confidence grows with the time the peer has been observed: the part of
+// the window covered, or the weight the EWMA has gathered. Lifetime ratios
+// are always trusted.
+func (s *PeerStats) ratio(cfg config.BehaviorConfig, now time.Time) Ratio {
+	observed := float64(now.Sub(s.FirstSeen))
+	r := Ratio{Download: s.TotalDownload, Upload: s.TotalUpload, Confidence: 1}
+
+	switch cfg.Ratio {
+	case config.RatioWindow:
+		r.Download, r.Upload = 0, 0
+		for _, sample := range s.meter.samples {
+			r.Download += sample.download
+			r.Upload += sample.upload
+		}
+		r.Confidence = math.Min(1, observed/float64(cfg.Window))
+
+	case config.RatioEWMA:
+		r.Download, r.Upload = int64(s.meter.ewmaDownload), int64(s.meter.ewmaUpload)
+		r.Confidence = 1 - math.Pow(0.5, observed/float64(cfg.EWMAHalfLife))
+	}
+
+	if r.Upload > 0 {
+		r.ShareRatio = float64(r.Download) / float64(r.Upload)
+	}
+	r.Confidence = math.Round(r.Confidence*100) / 100
+	return r
+}
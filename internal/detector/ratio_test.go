@@ -0,0 +1,110 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// pollUntilBan polls a peer every 10s for the given time and returns when
+// it was first banned, or -1
+func pollUntilBan(d *Detector, now *time.Time, peer aria2.Peer, polls int) time.Duration {
+	start := *now
+	for i := 0; i < polls; i++ {
+		if result := d.Detect(peer, 5*time.Minute); result != nil && result.Action == ActionBlock {
+			return now.Sub(start)
+		}
+		*now = now.Add(10 * time.Second)
+	}
+	return -1
+}
+
+func TestRatioModes(t *testing.T) {
+	tests := []struct {
+		ratio string
+		want  bool // banned within 10 minutes of leeching
+	}{
+		{config.RatioWindow, true},
+		{config.RatioEWMA, true},
+		{config.RatioLifetime, false},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultConfig().Detection
+		cfg.Behavior.Ratio = tt.ratio
+		now := time.Now()
+		d := NewDetector(&cfg)
+		d.SetClock(func() time.Time { return now })
+
+		// Half an hour of fair trade, then leeching
+		peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 1 << 20, DownloadSpeed: 1 << 20}
+		if at := pollUntilBan(d, &now, peer, 180); at >= 0 {
+			t.Fatalf("%s: unexpected ban of fair peer after %s", tt.ratio, at)
+		}
+		peer.DownloadSpeed = 0
+		at := pollUntilBan(d, &now, peer, 60)
+		if got := at >= 0; got != tt.want {
+			t.Errorf("%s: banned = %v (after %s), want %v", tt.ratio, got, at, tt.want)
+		}
+
+		stats := d.GetStats(peer.IP)
+		if lifetime := stats.LifetimeShareRatio(); lifetime < 0.5 {
+			t.Errorf("%s: lifetime ratio %v, want above 0.5", tt.ratio, lifetime)
+		}
+	}
+}
+
+func TestSlowLeecherDefaultConfig(t *testing.T) {
+	cfg := config.DefaultConfig().Detection
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	// Far below what a 5 minute window of polls needs to reach the threshold
+	peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 100 << 10}
+	if at := pollUntilBan(d, &now, peer, 360); at < 0 {
+		t.Error("Expected a slow leecher to be banned with the default settings")
+	}
+}
+
+func TestRatioMinObservation(t *testing.T) {
+	cfg := config.DefaultConfig().Detection
+	cfg.Behavior.Ratio = config.RatioWindow
+	cfg.Behavior.MinDataThreshold = 1 << 20
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	// Enough data at once, but judged only after min_observation
+	peer := aria2.Peer{IP: "10.0.0.1", PeerID: "-qB4250-a1B2.c3~D4(e", UploadSpeed: 1 << 20}
+	if at := pollUntilBan(d, &now, peer, 60); at != time.Minute {
+		t.Errorf("Banned after %s, want 1m", at)
+	}
+
+	result := d.Detect(aria2.Peer{IP: "10.0.0.2", UploadSpeed: 1 << 20}, 5*time.Minute)
+	if result != nil {
+		t.Errorf("Expected new peer not to be judged, got %+v", result)
+	}
+	if stats := d.GetStats("10.0.0.2"); stats.Ratio.Confidence != 0 {
+		t.Errorf("Confidence of new peer = %v, want 0", stats.Ratio.Confidence)
+	}
+}
+
+func TestCheckBehavior(t *testing.T) {
+	if err := CheckBehavior(config.DefaultConfig().Detection.Behavior); err != nil {
+		t.Errorf("Default behavior settings invalid: %v", err)
+	}
+	invalid := []config.BehaviorConfig{
+		{Ratio: "median"},
+		{Ratio: config.RatioWindow},
+		{Ratio: config.RatioEWMA},
+		{Ratio: config.RatioLifetime, MinConfidence: 1.5},
+		{Ratio: config.RatioLifetime, MinObservation: -time.Second},
+	}
+	for i, cfg := range invalid {
+		if err := CheckBehavior(cfg); err == nil {
+			t.Errorf("Case %d: expected validation error", i)
+		}
+	}
+}
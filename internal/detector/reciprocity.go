@@ -0,0 +1,113 @@
+package detector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// CheckReciprocity validates the choke reciprocity settings
+func CheckReciprocity(cfg config.ReciprocityConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MinDuration <= 0 {
+		return fmt.Errorf("min_duration must be positive")
+	}
+	if cfg.MinUploaded < 0 {
+		return fmt.Errorf("negative min_uploaded")
+	}
+	switch cfg.Action {
+	case config.RuleActionBan, config.RuleActionWatch:
+	default:
+		return fmt.Errorf("unknown action %q (want ban or watch)", cfg.Action)
+	}
+	return nil
+}
+
+// DetectReciprocity flags peers of a torrent that keep choking us while we
+// unchoke and upload to them, although they have pieces we need. The
+// choke streak ends as soon as the peer unchokes us; polls where we choke
+// the peer or it has nothing we need keep the streak but add no upload.
+//
+// Call it after Detect for every peer of the torrent in the same poll.
+func (d *Detector) DetectReciprocity(torrent *aria2.TorrentPeers, baseBlockDuration time.Duration) []*DetectionResult {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	cfg := d.config.Reciprocity
+	if !cfg.Enabled {
+		return nil
+	}
+	now := d.now()
+
+	var results []*DetectionResult
+	for _, peer := range torrent.Peers {
+		stats, ok := d.peerStats[peer.IP]
+		if !ok {
+			continue
+		}
+		if !bool(peer.PeerChoking) {
+			stats.ChokedSince, stats.ChokedUpload, stats.ChokeWatched = time.Time{}, 0, false
+			continue
+		}
+		if stats.ChokedSince.IsZero() {
+			stats.ChokedSince = now
+		}
+
+		// Only count uploads the peer had a reason to reciprocate
+		has, _, ok := torrent.Availability(peer)
+		if !ok || has == 0 || bool(peer.AmChoking) {
+			continue
+		}
+		stats.ChokedUpload += peer.UploadSpeed
+
+		chokedFor := now.Sub(stats.ChokedSince)
+		if chokedFor < cfg.MinDuration || stats.ChokedUpload < cfg.MinUploaded || stats.ChokedUpload == 0 {
+			continue
+		}
+		if result := d.flagNonReciprocating(stats, peer, chokedFor, now, baseBlockDuration); result != nil {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// flagNonReciprocating bans or watches a peer that kept choking us
+func (d *Detector) flagNonReciprocating(stats *PeerStats, peer aria2.Peer, chokedFor time.Duration, now time.Time, baseBlockDuration time.Duration) *DetectionResult {
+	result := &DetectionResult{
+		Peer:               peer,
+		Reason:             ReasonNonReciprocating,
+		ShareRatio:         stats.Ratio.ShareRatio,
+		Violations:         d.ledger.violations(peer.IP, now),
+		PeerUploaded:       stats.TotalDownload,
+		PeerDownloaded:     stats.TotalUpload,
+		LifetimeShareRatio: stats.LifetimeShareRatio(),
+		RatioConfidence:    stats.Ratio.Confidence,
+		PeerIDScore:        stats.PeerIDScore,
+		PeerIDAnomalies:    stats.PeerIDAnomalies,
+		ChokedFor:          chokedFor,
+		ChokedUpload:       stats.ChokedUpload,
+		Credit:             stats.Credit,
+	}
+
+	if d.config.Reciprocity.Action == config.RuleActionWatch {
+		if stats.ChokeWatched {
+			return nil
+		}
+		stats.ChokeWatched = true
+		result.Action = ActionWatch
+		return result
+	}
+
+	if d.ledger.blocked(peer.IP, now) {
+		return nil
+	}
+	result.Action = ActionBlock
+	result.Violations, result.BlockDuration = d.violate(peer.IP, ReasonNonReciprocating, 0, now, baseBlockDuration)
+	// Start over, the next streak has to last min_duration again
+	stats.ChokedSince, stats.ChokedUpload = time.Time{}, 0
+	return result
+}
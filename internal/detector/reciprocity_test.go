@@ -0,0 +1,93 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// pollChoking polls a torrent with a single peer every 10s and returns the
+// results of DetectReciprocity
+func pollChoking(d *Detector, now *time.Time, torrent *aria2.TorrentPeers, polls int) []*DetectionResult {
+	var results []*DetectionResult
+	for i := 0; i < polls; i++ {
+		d.Detect(torrent.Peers[0], 5*time.Minute)
+		results = append(results, d.DetectReciprocity(torrent, 5*time.Minute)...)
+		*now = now.Add(10 * time.Second)
+	}
+	return results
+}
+
+func chokingTorrent(peerBitfield string) *aria2.TorrentPeers {
+	torrent := &aria2.TorrentPeers{Peers: []aria2.Peer{{
+		IP:          "10.0.0.1",
+		Bitfield:    peerBitfield,
+		PeerChoking: true,
+		UploadSpeed: 1 << 20,
+	}}}
+	torrent.Download.Bitfield = "ff00"
+	torrent.Download.NumPieces = 16
+	return torrent
+}
+
+func TestDetectReciprocity(t *testing.T) {
+	cfg := instantConfig()
+	cfg.Behavior.Enabled = false
+	cfg.Reciprocity.Enabled = true
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	// Choked for 10 minutes while taking 1MB per poll
+	torrent := chokingTorrent("ffff")
+	if results := pollChoking(d, &now, torrent, 60); len(results) != 0 {
+		t.Fatalf("Expected no result before min_duration, got %+v", results[0])
+	}
+	results := pollChoking(d, &now, torrent, 1)
+	if len(results) != 1 {
+		t.Fatalf("Expected a ban after min_duration, got %d results", len(results))
+	}
+	if r := results[0]; r.Action != ActionBlock || r.Reason != ReasonNonReciprocating || r.ChokedFor != 10*time.Minute || r.ChokedUpload != 61<<20 {
+		t.Errorf("Unexpected result: %+v", r)
+	}
+
+	// Unchoking us ends the streak
+	d = NewDetector(&cfg)
+	pollChoking(d, &now, torrent, 40)
+	torrent.Peers[0].PeerChoking = false
+	pollChoking(d, &now, torrent, 1)
+	torrent.Peers[0].PeerChoking = true
+	if results := pollChoking(d, &now, torrent, 40); len(results) != 0 {
+		t.Errorf("Expected streak to restart after unchoke, got %+v", results[0])
+	}
+
+	// Nothing we need: choking us is fair
+	d = NewDetector(&cfg)
+	if results := pollChoking(d, &now, chokingTorrent("ff00"), 120); len(results) != 0 {
+		t.Errorf("Expected no result without needed pieces, got %+v", results[0])
+	}
+}
+
+func TestDetectReciprocityWatch(t *testing.T) {
+	cfg := instantConfig()
+	cfg.Behavior.Enabled = false
+	cfg.Reciprocity.Enabled = true
+	cfg.Reciprocity.Action = config.RuleActionWatch
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+
+	results := pollChoking(d, &now, chokingTorrent("ffff"), 120)
+	if len(results) != 1 || results[0].Action != ActionWatch {
+		t.Fatalf("Expected a single watch result, got %d", len(results))
+	}
+	if d.IsBlocked("10.0.0.1") {
+		t.Error("Expected watch not to block")
+	}
+
+	if err := CheckReciprocity(config.ReciprocityConfig{Enabled: true, MinDuration: time.Minute, Action: "throttle"}); err == nil {
+		t.Error("Expected validation error for throttle action")
+	}
+}
@@ -0,0 +1,146 @@
+package detector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/peerid"
+)
+
+// RuleReasonPrefix prefixes the reason of results from client rules
+const RuleReasonPrefix = "client_rule:"
+
+// ClientRules matches peers against the configured client rules
+type ClientRules struct {
+	rules []clientRule
+}
+
+// clientRule is a validated client rule
+type clientRule struct {
+	config.ClientRule
+	regex    *regexp.Regexp
+	versions *peerid.Range
+}
+
+// NewClientRules validates and compiles client rules
+func NewClientRules(cfg []config.ClientRule) (*ClientRules, error) {
+	r := &ClientRules{}
+	names := make(map[string]bool, len(cfg))
+	for i, rule := range cfg {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("client rule %d: missing name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("client rule %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.PeerIDPrefix == "" && rule.PeerIDRegex == "" && rule.Client == "" && rule.Tag == "" {
+			return nil, fmt.Errorf("client rule %s: needs peer_id_prefix, peer_id_regex, client or tag", rule.Name)
+		}
+		if (rule.Version != "" || rule.MinVersion != "" || rule.MaxVersion != "") && rule.Client == "" && rule.Tag == "" {
+			return nil, fmt.Errorf("client rule %s: version, min_version and max_version need client or tag", rule.Name)
+		}
+		if rule.Version != "" && (rule.MinVersion != "" || rule.MaxVersion != "") {
+			return nil, fmt.Errorf("client rule %s: use either version or min_version/max_version", rule.Name)
+		}
+		switch rule.Action {
+		case config.RuleActionBan, config.RuleActionThrottle, config.RuleActionWatch:
+		default:
+			return nil, fmt.Errorf("client rule %s: unknown action %q (want ban, throttle or watch)", rule.Name, rule.Action)
+		}
+		if rule.Duration < 0 {
+			return nil, fmt.Errorf("client rule %s: negative duration", rule.Name)
+		}
+
+		compiled := clientRule{ClientRule: rule}
+		if rule.PeerIDRegex != "" {
+			re, err := regexp.Compile(rule.PeerIDRegex)
+			if err != nil {
+				return nil, fmt.Errorf("client rule %s: invalid peer_id_regex: %w", rule.Name, err)
+			}
+			compiled.regex = re
+		}
+		if expr := versionExpr(rule); expr != "" {
+			versions, err := peerid.ParseRange(expr)
+			if err != nil {
+				return nil, fmt.Errorf("client rule %s: invalid version: %w", rule.Name, err)
+			}
+			compiled.versions = &versions
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// Len returns the number of rules
+func (r *ClientRules) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.rules)
+}
+
+// Match returns the first rule matching a peer ID, or nil
+func (r *ClientRules) Match(peerID string) *config.ClientRule {
+	if r.Len() == 0 {
+		return nil
+	}
+
+	raw := peerid.Decode(peerID)
+	var info *peerid.ClientInfo
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if rule.PeerIDPrefix != "" && !strings.HasPrefix(raw, rule.PeerIDPrefix) {
+			continue
+		}
+		if rule.regex != nil && !rule.regex.MatchString(raw) {
+			continue
+		}
+		if rule.Client != "" || rule.Tag != "" {
+			if info == nil {
+				parsed := peerid.Parse(raw)
+				info = &parsed
+			}
+			if rule.Client != "" && !strings.EqualFold(info.Name, rule.Client) {
+				continue
+			}
+			if rule.Tag != "" && !hasTag(info.Tags, rule.Tag) {
+				continue
+			}
+			if rule.versions != nil && !rule.versions.MatchString(info.Version) {
+				continue
+			}
+		}
+		return &rule.ClientRule
+	}
+	return nil
+}
+
+// versionExpr returns the version range of a rule, translating
+// min_version and max_version
+func versionExpr(rule config.ClientRule) string {
+	if rule.Version != "" {
+		return rule.Version
+	}
+	var parts []string
+	if rule.MinVersion != "" {
+		parts = append(parts, ">="+rule.MinVersion)
+	}
+	if rule.MaxVersion != "" {
+		parts = append(parts, "<"+rule.MaxVersion)
+	}
+	return strings.Join(parts, " ")
+}
+
+// hasTag reports whether tags contains tag
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
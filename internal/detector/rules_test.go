@@ -0,0 +1,122 @@
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+func TestNewClientRulesValidation(t *testing.T) {
+	invalid := [][]config.ClientRule{
+		{{PeerIDPrefix: "-XL", Action: "ban"}},
+		{{Name: "a", Action: "ban"}},
+		{{Name: "a", PeerIDPrefix: "-XL", Action: "drop"}},
+		{{Name: "a", PeerIDRegex: "(", Action: "ban"}},
+		{{Name: "a", PeerIDPrefix: "-XL", MinVersion: "1.0", Action: "ban"}},
+		{{Name: "a", Client: "qBittorrent", Version: ">=4.x", Action: "ban"}},
+		{{Name: "a", Client: "qBittorrent", Version: "<4.3", MaxVersion: "4.3", Action: "ban"}},
+		{{Name: "a", PeerIDPrefix: "-XL", Action: "ban"}, {Name: "a", PeerIDPrefix: "-SD", Action: "ban"}},
+	}
+	for i, rules := range invalid {
+		if _, err := NewClientRules(rules); err == nil {
+			t.Errorf("Case %d: expected validation error", i)
+		}
+	}
+}
+
+func TestClientRulesMatch(t *testing.T) {
+	rules, err := NewClientRules([]config.ClientRule{
+		{Name: "xunlei", PeerIDPrefix: "-XL", Action: "ban"},
+		{Name: "offline", PeerIDRegex: `^-SD0[0-9]{3}-`, Action: "throttle"},
+		{Name: "old-bc", Client: "bitcomet", MinVersion: "2.0", MaxVersion: "2.14", Action: "watch"},
+		{Name: "old-qb", Client: "qbittorrent", Version: "<4.3 || 4.4.x", Action: "throttle"},
+		{Name: "tr2", Client: "transmission", Version: "2.x", Action: "watch"},
+		{Name: "offline-tag", Tag: "offline_download", Action: "watch"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		peerID string
+		want   string
+	}{
+		{"-XL0019-abcdefghijkl", "xunlei"},
+		{"%2DXL0019%2Dabcdefghijkl", "xunlei"},
+		{"-SD0100-abcdefghijkl", "offline"},
+		{"-SD1100-abcdefghijkl", "offline-tag"}, // regex misses, built-in tag matches
+		{"-BC0213-abcdefghijkl", "old-bc"},
+		{"-BC0214-abcdefghijkl", ""},
+		{"-BC0113-abcdefghijkl", ""},
+		{"-TR2940-abcdefghijkl", "tr2"},
+		{"-TR3000-abcdefghijkl", ""},
+		{"-qB4250-abcdefghijkl", "old-qb"},
+		{"-qB4300-abcdefghijkl", ""},
+		{"-qB4450-abcdefghijkl", "old-qb"},
+		{"-BN0100-abcdefghijkl", "offline-tag"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if rule := rules.Match(tt.peerID); rule != nil {
+			got = rule.Name
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.peerID, got, tt.want)
+		}
+	}
+
+	var none *ClientRules
+	if none.Match("-XL0019-abcdefghijkl") != nil {
+		t.Error("Expected nil rules to match nothing")
+	}
+}
+
+func TestDetectClientRules(t *testing.T) {
+	cfg := instantConfig()
+	now := time.Now()
+	d := NewDetector(&cfg)
+	d.SetClock(func() time.Time { return now })
+	rules, err := NewClientRules([]config.ClientRule{
+		{Name: "xunlei", PeerIDPrefix: "-XL", Action: "ban", Duration: time.Hour},
+		{Name: "sd", PeerIDPrefix: "-SD", Action: "throttle"},
+		{Name: "bc", PeerIDPrefix: "-BC", Action: "watch"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetClientRules(rules)
+
+	// Ban at once with the rule's fixed duration
+	xl := aria2.Peer{IP: "10.0.0.1", PeerID: "-XL0019-abcdefghijkl"}
+	result := d.Detect(xl, 5*time.Minute)
+	if result == nil || result.Action != ActionBlock || result.Reason != "client_rule:xunlei" || result.BlockDuration != time.Hour {
+		t.Fatalf("Unexpected ban result: %+v", result)
+	}
+	if result := d.Detect(xl, 5*time.Minute); result != nil {
+		t.Errorf("Expected no result while banned, got %+v", result)
+	}
+
+	// Throttle for base_duration, again after it expires
+	sd := aria2.Peer{IP: "10.0.0.2", PeerID: "-SD0100-abcdefghijkl"}
+	if result := d.Detect(sd, 5*time.Minute); result == nil || result.Action != ActionThrottle || result.BlockDuration != 5*time.Minute {
+		t.Fatalf("Unexpected throttle result: %+v", result)
+	}
+	if result := d.Detect(sd, 5*time.Minute); result != nil {
+		t.Errorf("Expected no result while throttled, got %+v", result)
+	}
+	now = now.Add(6 * time.Minute)
+	if result := d.Detect(sd, 5*time.Minute); result == nil || result.Action != ActionThrottle {
+		t.Errorf("Expected throttle after expiry, got %+v", result)
+	}
+
+	// Watch once, then behavior analysis still applies
+	bc := aria2.Peer{IP: "10.0.0.3", PeerID: "-BC0213-abcdefghijkl", UploadSpeed: 20 << 20}
+	if result := d.Detect(bc, 5*time.Minute); result == nil || result.Action != ActionWatch {
+		t.Fatalf("Unexpected watch result: %+v", result)
+	}
+	if result := d.Detect(bc, 5*time.Minute); result == nil || result.Action != ActionBlock || result.Reason != "low_share_ratio" {
+		t.Errorf("Expected behavior ban after watch, got %+v", result)
+	}
+}
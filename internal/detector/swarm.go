@@ -0,0 +1,171 @@
+package detector
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// swarmPeer is a peer of a torrent that can be compared with the others
+type swarmPeer struct {
+	peer         aria2.Peer
+	stats        *PeerStats
+	availability float64 // peer拥有我们所缺分片的比例
+	score        float64 // 分享率除以availability
+}
+
+// CheckSwarm validates the swarm comparison settings
+func CheckSwarm(cfg config.SwarmConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MinPeers < 3 {
+		return fmt.Errorf("min_peers must be at least 3, got %d", cfg.MinPeers)
+	}
+	if cfg.ZScore < 0 {
+		return fmt.Errorf("negative z_score")
+	}
+	if cfg.Percentile < 0 || cfg.Percentile >= 100 {
+		return fmt.Errorf("percentile must be between 0 and 100, got %v", cfg.Percentile)
+	}
+	if cfg.ZScore == 0 && cfg.Percentile == 0 {
+		return fmt.Errorf("z_score or percentile must be set")
+	}
+	if cfg.MinAvailability < 0 || cfg.MinAvailability > 1 {
+		return fmt.Errorf("min_availability must be between 0 and 1, got %v", cfg.MinAvailability)
+	}
+	if cfg.MaxShareRatio < 0 {
+		return fmt.Errorf("negative max_share_ratio")
+	}
+	return nil
+}
+
+// DetectSwarm compares the peers of a torrent with each other and blocks
+// outliers: peers whose share ratio, divided by the part of the pieces we
+// lack that they have, is far below the rest of the swarm. In a rare
+// torrent where nobody has much to give, nobody stands out.
+//
+// Peers are compared once behavior analysis would judge them (observed
+// long enough, enough data); peers without pieces we need are left out.
+// Call it after Detect for every peer of the torrent in the same poll.
+func (d *Detector) DetectSwarm(torrent *aria2.TorrentPeers, baseBlockDuration time.Duration) []*DetectionResult {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	cfg := d.config.Swarm
+	if !cfg.Enabled {
+		return nil
+	}
+	now := d.now()
+	peers := d.swarmPeers(torrent, now)
+	if len(peers) < cfg.MinPeers {
+		return nil
+	}
+
+	var sum, sumSquares float64
+	for _, p := range peers {
+		sum += p.score
+		sumSquares += p.score * p.score
+	}
+	n := float64(len(peers))
+	mean := sum / n
+	stddev := math.Sqrt(math.Max(0, sumSquares/n-mean*mean))
+
+	var results []*DetectionResult
+	for _, p := range peers {
+		if cfg.MaxShareRatio > 0 && p.stats.Ratio.ShareRatio >= cfg.MaxShareRatio {
+			continue
+		}
+
+		var z float64
+		if stddev > 0 {
+			z = (p.score - mean) / stddev
+		}
+		if cfg.ZScore > 0 && (stddev == 0 || z > -cfg.ZScore) {
+			continue
+		}
+		percentile := percentileRank(peers, p.score)
+		if cfg.Percentile > 0 && percentile > cfg.Percentile {
+			continue
+		}
+		if d.ledger.blocked(p.peer.IP, now) {
+			continue
+		}
+
+		violations, blockDuration := d.violate(p.peer.IP, ReasonSwarmOutlier, 0, now, baseBlockDuration)
+		results = append(results, &DetectionResult{
+			Action:             ActionBlock,
+			Peer:               p.peer,
+			Reason:             ReasonSwarmOutlier,
+			ShareRatio:         p.stats.Ratio.ShareRatio,
+			Violations:         violations,
+			BlockDuration:      blockDuration,
+			PeerUploaded:       p.stats.TotalDownload,
+			PeerDownloaded:     p.stats.TotalUpload,
+			LifetimeShareRatio: p.stats.LifetimeShareRatio(),
+			RatioConfidence:    p.stats.Ratio.Confidence,
+			PeerIDScore:        p.stats.PeerIDScore,
+			PeerIDAnomalies:    p.stats.PeerIDAnomalies,
+			SwarmPeers:         len(peers),
+			SwarmZScore:        math.Round(z*100) / 100,
+			SwarmPercentile:    math.Round(percentile*10) / 10,
+			PieceAvailability:  math.Round(p.availability*100) / 100,
+			Credit:             p.stats.Credit,
+		})
+	}
+	return results
+}
+
+// swarmPeers returns the peers of a torrent that can be compared, none if
+// we lack no pieces or aria2 reported no bitfields
+func (d *Detector) swarmPeers(torrent *aria2.TorrentPeers, now time.Time) []swarmPeer {
+	behavior := d.config.Behavior
+	var peers []swarmPeer
+	for _, peer := range torrent.Peers {
+		stats, ok := d.peerStats[peer.IP]
+		if !ok || now.Sub(stats.FirstSeen) < behavior.MinObservation || stats.Ratio.Confidence < behavior.MinConfidence {
+			continue
+		}
+		if stats.Ratio.Upload < behavior.MinDataThreshold {
+			continue
+		}
+
+		has, missing, ok := torrent.Availability(peer)
+		if !ok {
+			continue
+		}
+		if missing == 0 {
+			// Seeding: nobody can give us anything
+			return nil
+		}
+		availability := float64(has) / float64(missing)
+		if availability == 0 || availability < d.config.Swarm.MinAvailability {
+			continue
+		}
+		peers = append(peers, swarmPeer{
+			peer:         peer,
+			stats:        stats,
+			availability: availability,
+			score:        stats.Ratio.ShareRatio / availability,
+		})
+	}
+	return peers
+}
+
+// percentileRank returns the percentage of peers scoring below score,
+// counting ties (the peer itself included) half
+func percentileRank(peers []swarmPeer, score float64) float64 {
+	var below float64
+	for _, p := range peers {
+		switch {
+		case p.score < score:
+			below++
+		case p.score == score:
+			below += 0.5
+		}
+	}
+	return below / float64(len(peers)) * 100
+}
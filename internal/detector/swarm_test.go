@@ -0,0 +1,121 @@
+package detector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// swarmTorrent returns a torrent of 16 pieces of which we have the first 8,
+// with one peer per share ratio, each having every piece
+func swarmTorrent(ratios ...float64) *aria2.TorrentPeers {
+	torrent := &aria2.TorrentPeers{}
+	torrent.Download.Bitfield = "ff00"
+	torrent.Download.NumPieces = 16
+	for i, ratio := range ratios {
+		torrent.Peers = append(torrent.Peers, aria2.Peer{
+			IP:            fmt.Sprintf("10.0.0.%d", i+1),
+			Bitfield:      "ffff",
+			UploadSpeed:   20 << 20,
+			DownloadSpeed: int64(ratio * (20 << 20)),
+		})
+	}
+	return torrent
+}
+
+// detectSwarm runs a poll of a torrent through the detector and returns
+// the IPs blocked as swarm outliers
+func detectSwarm(d *Detector, torrent *aria2.TorrentPeers) []string {
+	for _, peer := range torrent.Peers {
+		d.Detect(peer, 5*time.Minute)
+	}
+	var ips []string
+	for _, result := range d.DetectSwarm(torrent, 5*time.Minute) {
+		ips = append(ips, result.Peer.IP)
+	}
+	return ips
+}
+
+func swarmConfig() config.DetectionConfig {
+	cfg := instantConfig()
+	cfg.Behavior.Enabled = false
+	cfg.Swarm.Enabled = true
+	return cfg
+}
+
+func TestDetectSwarmOutlier(t *testing.T) {
+	cfg := swarmConfig()
+	d := NewDetector(&cfg)
+
+	torrent := swarmTorrent(1, 0.9, 1.1, 0.95, 1.05, 1, 0.9, 1.1, 1, 0.2)
+	// Gives little, but has only half of what we lack
+	torrent.Peers = append(torrent.Peers, aria2.Peer{IP: "10.0.1.1", Bitfield: "ff0f", UploadSpeed: 20 << 20, DownloadSpeed: 10 << 20})
+	// Has nothing we lack, cannot be judged
+	torrent.Peers = append(torrent.Peers, aria2.Peer{IP: "10.0.1.2", Bitfield: "ff00", UploadSpeed: 20 << 20})
+
+	for _, peer := range torrent.Peers {
+		d.Detect(peer, 5*time.Minute)
+	}
+	results := d.DetectSwarm(torrent, 5*time.Minute)
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 outlier, got %d", len(results))
+	}
+	result := results[0]
+	if result.Peer.IP != "10.0.0.10" || result.Reason != ReasonSwarmOutlier || result.Violations != 1 {
+		t.Errorf("Unexpected outlier: %+v", result)
+	}
+	if result.SwarmPeers != 11 || result.SwarmZScore > -2 || result.SwarmPercentile > 10 || result.PieceAvailability != 1 {
+		t.Errorf("Unexpected swarm evidence: peers %d, z %v, percentile %v, availability %v",
+			result.SwarmPeers, result.SwarmZScore, result.SwarmPercentile, result.PieceAvailability)
+	}
+
+	// Blocked now, not again next poll
+	if ips := detectSwarm(d, torrent); len(ips) != 0 {
+		t.Errorf("Expected no outliers while blocked, got %v", ips)
+	}
+}
+
+func TestDetectSwarmRareTorrent(t *testing.T) {
+	// Everyone gives little: nobody stands out
+	cfg := swarmConfig()
+	d := NewDetector(&cfg)
+	if ips := detectSwarm(d, swarmTorrent(0.02, 0.03, 0.02, 0.01, 0.02, 0.03, 0.02, 0.02, 0.01, 0.02)); len(ips) != 0 {
+		t.Errorf("Expected no outliers in rare torrent, got %v", ips)
+	}
+
+	// Too few peers to compare
+	d = NewDetector(&cfg)
+	if ips := detectSwarm(d, swarmTorrent(1, 1, 1, 1, 1, 0)); len(ips) != 0 {
+		t.Errorf("Expected no outliers below min_peers, got %v", ips)
+	}
+
+	// Seeding: we lack nothing
+	d = NewDetector(&cfg)
+	torrent := swarmTorrent(1, 0.9, 1.1, 0.95, 1.05, 1, 0.9, 1.1, 1, 0)
+	torrent.Download.Bitfield = "ffff"
+	if ips := detectSwarm(d, torrent); len(ips) != 0 {
+		t.Errorf("Expected no outliers while seeding, got %v", ips)
+	}
+}
+
+func TestCheckSwarm(t *testing.T) {
+	cfg := config.DefaultConfig().Detection.Swarm
+	cfg.Enabled = true
+	if err := CheckSwarm(cfg); err != nil {
+		t.Errorf("Default swarm settings invalid: %v", err)
+	}
+	invalid := []config.SwarmConfig{
+		{Enabled: true, MinPeers: 2, ZScore: 2},
+		{Enabled: true, MinPeers: 8},
+		{Enabled: true, MinPeers: 8, Percentile: 100},
+		{Enabled: true, MinPeers: 8, ZScore: 2, MinAvailability: 2},
+	}
+	for i, cfg := range invalid {
+		if err := CheckSwarm(cfg); err == nil {
+			t.Errorf("Case %d: expected validation error", i)
+		}
+	}
+}
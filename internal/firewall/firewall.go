@@ -0,0 +1,18 @@
+package firewall
+
+import "time"
+
+// Firewall blocks outgoing traffic to IPs for a limited time. A zero
+// duration blocks until UnblockIP.
+type Firewall interface {
+	BlockIP(ip string, duration time.Duration) error
+	ThrottleIP(ip string, duration time.Duration) error
+	UnblockIP(ip string) error
+	ListBlocked() ([]string, error)
+	Close() error
+}
+
+var (
+	_ Firewall = (*NftablesManager)(nil)
+	_ Firewall = (*MemoryFirewall)(nil)
+)
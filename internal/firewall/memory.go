@@ -0,0 +1,102 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryFirewall only remembers blocked IPs and never touches the system
+// firewall. It backs dry-run mode and tests.
+type MemoryFirewall struct {
+	blocked   map[string]time.Time // IP -> expiry, zero for permanent
+	throttled map[string]time.Time // IP -> expiry
+	mutex     sync.Mutex
+	now       func() time.Time
+}
+
+// NewMemoryFirewall creates an empty in-memory firewall
+func NewMemoryFirewall() *MemoryFirewall {
+	return &MemoryFirewall{
+		blocked:   make(map[string]time.Time),
+		throttled: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// BlockIP remembers an IP as blocked for the specified duration, or until
+// UnblockIP if it is zero
+func (m *MemoryFirewall) BlockIP(ipStr string, duration time.Duration) error {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address: %s", ipStr)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var expires time.Time
+	if duration > 0 {
+		expires = m.now().Add(duration)
+	}
+	m.blocked[ip.String()] = expires
+	return nil
+}
+
+// ThrottleIP remembers an IP as throttled for the specified duration
+func (m *MemoryFirewall) ThrottleIP(ipStr string, duration time.Duration) error {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address: %s", ipStr)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.throttled[ip.String()] = m.now().Add(duration)
+	return nil
+}
+
+// IsThrottled reports whether an IP is throttled
+func (m *MemoryFirewall) IsThrottled(ipStr string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expires, ok := m.throttled[ipStr]
+	return ok && m.now().Before(expires)
+}
+
+// UnblockIP forgets a blocked IP
+func (m *MemoryFirewall) UnblockIP(ipStr string) error {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address: %s", ipStr)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.blocked, ip.String())
+	return nil
+}
+
+// ListBlocked returns all IPs whose block has not expired
+func (m *MemoryFirewall) ListBlocked() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	var blockedIPs []string
+	for ip, expires := range m.blocked {
+		if expires.IsZero() || now.Before(expires) {
+			blockedIPs = append(blockedIPs, ip)
+		} else {
+			delete(m.blocked, ip)
+		}
+	}
+	sort.Strings(blockedIPs)
+	return blockedIPs, nil
+}
+
+// Close does nothing
+func (m *MemoryFirewall) Close() error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryFirewall(t *testing.T) {
+	now := time.Now()
+	m := NewMemoryFirewall()
+	m.now = func() time.Time { return now }
+
+	if err := m.BlockIP("10.0.0.1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.BlockIP("2001:db8::1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.BlockIP("not-an-ip", time.Minute); err == nil {
+		t.Error("Expected error for invalid IP")
+	}
+
+	blocked, _ := m.ListBlocked()
+	if len(blocked) != 2 {
+		t.Fatalf("Expected 2 blocked IPs, got %v", blocked)
+	}
+
+	now = now.Add(2 * time.Minute)
+	blocked, _ = m.ListBlocked()
+	if len(blocked) != 1 || blocked[0] != "2001:db8::1" {
+		t.Errorf("Expected only the IPv6 block left, got %v", blocked)
+	}
+
+	m.UnblockIP("2001:db8::1")
+	if blocked, _ = m.ListBlocked(); len(blocked) != 0 {
+		t.Errorf("Expected no blocks, got %v", blocked)
+	}
+
+	// A zero duration blocks until unblocked
+	m.BlockIP("10.0.0.9", 0)
+	now = now.Add(365 * 24 * time.Hour)
+	if blocked, _ = m.ListBlocked(); len(blocked) != 1 {
+		t.Errorf("Expected permanent block to stay, got %v", blocked)
+	}
+}
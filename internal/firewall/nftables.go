@@ -2,12 +2,15 @@
 package firewall
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
 )
 
 // NftablesManager manages nftables rules for blocking IPs
@@ -17,10 +20,16 @@ type NftablesManager struct {
 	setV4 *nftables.Set
 	setV6 *nftables.Set
 	chain *nftables.Chain
+
+	// Throttled IPs and their per-IP rate meters, nil if throttling is off
+	throttleV4 *nftables.Set
+	throttleV6 *nftables.Set
+	mutex      sync.Mutex // serializes batches from the poll loop and the control API
 }
 
-// NewNftablesManager creates a new nftables manager
-func NewNftablesManager(tableName string) (*NftablesManager, error) {
+// NewNftablesManager creates a new nftables manager. Throttled IPs may
+// receive throttleRate bytes per second each; 0 disables throttling.
+func NewNftablesManager(tableName string, throttleRate int64) (*NftablesManager, error) {
 	conn, err := nftables.New()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to nftables: %w", err)
@@ -35,6 +44,12 @@ func NewNftablesManager(tableName string) (*NftablesManager, error) {
 		conn.CloseLasting()
 		return nil, err
 	}
+	if throttleRate > 0 {
+		if err := mgr.initThrottle(throttleRate); err != nil {
+			conn.CloseLasting()
+			return nil, err
+		}
+	}
 
 	return mgr, nil
 }
@@ -166,8 +181,139 @@ func (m *NftablesManager) init(tableName string) error {
 	return nil
 }
 
-// BlockIP adds an IP to the blocked set with the specified duration
+// initThrottle adds the sets and rules that rate limit outgoing traffic
+// to throttled IPs. Each throttled IP gets its own limit through a meter,
+// like "ip daddr @throttled_v4 meter m { ip daddr limit rate over N bytes/second } drop".
+func (m *NftablesManager) initThrottle(rate int64) error {
+	families := []struct {
+		name    string
+		keyType nftables.SetDatatype
+		offset  uint32
+		length  uint32
+		set     **nftables.Set
+	}{
+		{"v4", nftables.TypeIPAddr, 16, 4, &m.throttleV4},
+		{"v6", nftables.TypeIP6Addr, 24, 16, &m.throttleV6},
+	}
+
+	for _, f := range families {
+		throttled, err := m.ensureSet(&nftables.Set{
+			Name:       "throttled_" + f.name,
+			Table:      m.table,
+			KeyType:    f.keyType,
+			HasTimeout: true,
+		})
+		if err != nil {
+			return err
+		}
+		meter, err := m.ensureSet(&nftables.Set{
+			Name:       "throttle_meter_" + f.name,
+			Table:      m.table,
+			KeyType:    f.keyType,
+			Dynamic:    true,
+			HasTimeout: true,
+			Timeout:    time.Minute,
+		})
+		if err != nil {
+			return err
+		}
+		*f.set = throttled
+
+		m.conn.AddRule(&nftables.Rule{
+			Table: m.table,
+			Chain: m.chain,
+			Exprs: []expr.Any{
+				// Load destination IP
+				&expr.Payload{
+					OperationType: expr.PayloadLoad,
+					Len:           f.length,
+					Offset:        f.offset,
+					DestRegister:  1,
+					Base:          expr.PayloadBaseNetworkHeader,
+				},
+				// Only throttled IPs
+				&expr.Lookup{
+					SourceRegister: 1,
+					SetName:        throttled.Name,
+					SetID:          throttled.ID,
+				},
+				// Per-IP rate, matches once the IP is over it
+				&expr.Dynset{
+					SrcRegKey: 1,
+					SetName:   meter.Name,
+					SetID:     meter.ID,
+					Operation: unix.NFT_DYNSET_OP_UPDATE,
+					Exprs: []expr.Any{
+						&expr.Limit{
+							Type:  expr.LimitTypePktBytes,
+							Rate:  uint64(rate),
+							Over:  true,
+							Unit:  expr.LimitTimeSecond,
+							Burst: uint32(rate),
+						},
+					},
+				},
+				// Drop what exceeds the rate
+				&expr.Verdict{
+					Kind: expr.VerdictDrop,
+				},
+			},
+		})
+	}
+
+	if err := m.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables: %w", err)
+	}
+	return nil
+}
+
+// ensureSet adds a set, or looks it up if it already exists
+func (m *NftablesManager) ensureSet(set *nftables.Set) (*nftables.Set, error) {
+	if err := m.conn.AddSet(set, nil); err == nil {
+		return set, nil
+	}
+	sets, err := m.conn.GetSets(m.table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sets: %w", err)
+	}
+	for _, s := range sets {
+		if s.Name == set.Name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to add set %s", set.Name)
+}
+
+// ThrottleIP rate limits outgoing traffic to an IP for the specified duration
+func (m *NftablesManager) ThrottleIP(ipStr string, duration time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.throttleV4 == nil {
+		return errors.New("throttling is disabled (blocking.throttle_rate is 0)")
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address: %s", ipStr)
+	}
+
+	set, key := m.throttleV6, ip.To16()
+	if ip.To4() != nil {
+		set, key = m.throttleV4, ip.To4()
+	}
+	if err := m.conn.SetAddElements(set, []nftables.SetElement{{Key: key, Timeout: duration}}); err != nil {
+		return fmt.Errorf("failed to add IP to throttled set: %w", err)
+	}
+	return m.conn.Flush()
+}
+
+// BlockIP adds an IP to the blocked set with the specified duration. A
+// zero duration adds it without timeout.
 func (m *NftablesManager) BlockIP(ipStr string, duration time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
 		return fmt.Errorf("invalid IP address: %s", ipStr)
@@ -202,6 +348,9 @@ func (m *NftablesManager) BlockIP(ipStr string, duration time.Duration) error {
 
 // UnblockIP removes an IP from the blocked set
 func (m *NftablesManager) UnblockIP(ipStr string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
 		return fmt.Errorf("invalid IP address: %s", ipStr)
@@ -230,6 +379,9 @@ func (m *NftablesManager) UnblockIP(ipStr string) error {
 
 // Clear removes all blocked IPs
 func (m *NftablesManager) Clear() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	// Flush all elements from sets
 	m.conn.FlushSet(m.setV4)
 	m.conn.FlushSet(m.setV6)
@@ -238,12 +390,18 @@ func (m *NftablesManager) Clear() error {
 
 // Destroy removes the entire table
 func (m *NftablesManager) Destroy() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	m.conn.DelTable(m.table)
 	return m.conn.Flush()
 }
 
 // ListBlocked returns all currently blocked IPs
 func (m *NftablesManager) ListBlocked() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	var blockedIPs []string
 
 	// Get IPv4 elements
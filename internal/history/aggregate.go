@@ -0,0 +1,130 @@
+package history
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// DayCount is the number of bans started on one day
+type DayCount struct {
+	Day   string `json:"day"` // YYYY-MM-DD in local time
+	Bans  int    `json:"bans"`
+	IPs   int    `json:"ips"` // distinct IPs banned
+	ipSet map[string]bool
+}
+
+// Offender summarizes the bans of one IP
+type Offender struct {
+	IP             string        `json:"ip"`
+	Bans           int           `json:"bans"`
+	MaxViolations  int           `json:"max_violations"`
+	TotalBanned    time.Duration `json:"-"`
+	LastClientName string        `json:"last_client_name"`
+	LastReason     string        `json:"last_reason"`
+	FirstBan       time.Time     `json:"first_ban"`
+	LastBan        time.Time     `json:"last_ban"`
+}
+
+// MarshalJSON encodes the total banned time as a duration string
+func (o Offender) MarshalJSON() ([]byte, error) {
+	type plain Offender
+	return json.Marshal(struct {
+		plain
+		TotalBanned string `json:"total_banned"`
+	}{plain(o), o.TotalBanned.String()})
+}
+
+// isBanStart reports whether an event starts a ban. Hypothetical bans of
+// dry-run mode (would_block) only count if dryRun is set.
+func isBanStart(event logger.BlockEvent, dryRun bool) bool {
+	return event.Event == logger.EventBlocked || dryRun && event.Event == logger.EventWouldBlock
+}
+
+// BansPerDay counts ban starts per day, oldest first. would_block events
+// are counted as bans if dryRun is set.
+func BansPerDay(events []logger.BlockEvent, dryRun bool) []DayCount {
+	days := make(map[string]*DayCount)
+	for _, event := range events {
+		if !isBanStart(event, dryRun) {
+			continue
+		}
+		day := event.Timestamp.Local().Format("2006-01-02")
+		dc, ok := days[day]
+		if !ok {
+			dc = &DayCount{Day: day, ipSet: make(map[string]bool)}
+			days[day] = dc
+		}
+		dc.Bans++
+		dc.ipSet[event.IP] = true
+	}
+
+	result := make([]DayCount, 0, len(days))
+	for _, dc := range days {
+		dc.IPs = len(dc.ipSet)
+		dc.ipSet = nil
+		result = append(result, *dc)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Day < result[j].Day })
+	return result
+}
+
+// TopOffenders returns the n IPs with the most bans, most banned first.
+// Total banned time is taken from the events that ended each ban.
+// would_block events are counted as bans if dryRun is set.
+func TopOffenders(events []logger.BlockEvent, n int, dryRun bool) []Offender {
+	offenders := make(map[string]*Offender)
+	get := func(ip string) *Offender {
+		o, ok := offenders[ip]
+		if !ok {
+			o = &Offender{IP: ip}
+			offenders[ip] = o
+		}
+		return o
+	}
+
+	for _, event := range events {
+		switch {
+		case isBanStart(event, dryRun):
+			o := get(event.IP)
+			o.Bans++
+			if event.Violations > o.MaxViolations {
+				o.MaxViolations = event.Violations
+			}
+			if o.FirstBan.IsZero() || event.Timestamp.Before(o.FirstBan) {
+				o.FirstBan = event.Timestamp
+			}
+			if !event.Timestamp.Before(o.LastBan) {
+				o.LastBan = event.Timestamp
+				o.LastClientName = event.ClientName
+				o.LastReason = event.Reason
+			}
+		case event.BannedFor != "":
+			if d, err := time.ParseDuration(event.BannedFor); err == nil {
+				get(event.IP).TotalBanned += d
+			}
+		}
+	}
+
+	result := make([]Offender, 0, len(offenders))
+	for _, o := range offenders {
+		if o.Bans > 0 {
+			result = append(result, *o)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Bans != result[j].Bans {
+			return result[i].Bans > result[j].Bans
+		}
+		if result[i].TotalBanned != result[j].TotalBanned {
+			return result[i].TotalBanned > result[j].TotalBanned
+		}
+		return result[i].IP < result[j].IP
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
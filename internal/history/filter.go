@@ -0,0 +1,132 @@
+package history
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/logger"
+	"github.com/lbl1m/aria2bango/internal/peerid"
+)
+
+// Filter selects events from the history. Empty fields match everything.
+type Filter struct {
+	IP       string   // single IP or CIDR
+	Client   string   // case-insensitive substring of the client name
+	Version  string   // version range of the client parsed from the peer ID, e.g. ">=4.0 <4.4"
+	Reason   string   // exact reason
+	Events   []string // event types
+	Torrent  string   // info hash prefix or case-insensitive substring of the name
+	Instance string   // exact aria2 instance name
+	BanID    string
+	Since    time.Time
+	Until    time.Time // exclusive
+	Limit    int
+
+	ip       net.IP
+	network  *net.IPNet
+	versions *peerid.Range
+}
+
+// compile parses the IP and version filters
+func (f *Filter) compile() error {
+	f.ip, f.network, f.versions = nil, nil, nil
+	if f.Version != "" {
+		versions, err := peerid.ParseRange(f.Version)
+		if err != nil {
+			return err
+		}
+		f.versions = &versions
+	}
+	if f.IP == "" {
+		return nil
+	}
+	if strings.Contains(f.IP, "/") {
+		_, network, err := net.ParseCIDR(f.IP)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q: %w", f.IP, err)
+		}
+		f.network = network
+		return nil
+	}
+	f.ip = net.ParseIP(f.IP)
+	if f.ip == nil {
+		return fmt.Errorf("invalid IP %q", f.IP)
+	}
+	return nil
+}
+
+// singleIP returns the IP to look up in the index, if the filter is for one
+func (f *Filter) singleIP() string {
+	if f.ip == nil {
+		return ""
+	}
+	// Stored events use aria2's notation, which is canonical for IPv4 and
+	// may differ for IPv6; fall back to a scan for the latter
+	if f.ip.To4() == nil && f.ip.String() != f.IP {
+		return ""
+	}
+	return f.IP
+}
+
+// Match reports whether an event passes the filter
+func (f *Filter) Match(event logger.BlockEvent) bool {
+	if f.ip != nil || f.network != nil {
+		ip := net.ParseIP(event.IP)
+		if ip == nil {
+			return false
+		}
+		if f.ip != nil && !f.ip.Equal(ip) {
+			return false
+		}
+		if f.network != nil && !f.network.Contains(ip) {
+			return false
+		}
+	}
+	if f.Client != "" && !containsFold(event.ClientName, f.Client) {
+		return false
+	}
+	if f.versions != nil && !f.versions.MatchString(peerid.Parse(event.PeerID).Version) {
+		return false
+	}
+	if f.Reason != "" && event.Reason != f.Reason {
+		return false
+	}
+	if len(f.Events) > 0 && !contains(f.Events, event.Event) {
+		return false
+	}
+	if f.Torrent != "" &&
+		!strings.HasPrefix(strings.ToLower(event.InfoHash), strings.ToLower(f.Torrent)) &&
+		!containsFold(event.TorrentName, f.Torrent) {
+		return false
+	}
+	if f.Instance != "" && event.Instance != f.Instance {
+		return false
+	}
+	if f.BanID != "" && event.BanID != f.BanID {
+		return false
+	}
+	if !f.Since.IsZero() && event.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !event.Timestamp.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// containsFold reports whether substr is within s, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// contains reports whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
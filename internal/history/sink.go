@@ -0,0 +1,82 @@
+package history
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// sinkBuffer is the number of events waiting to be written
+const sinkBuffer = 1000
+
+// Sink records block log events into the store in the background, so the
+// database lock held by a running history query never stalls detection.
+// It implements logger.Sink.
+type Sink struct {
+	store   *Store
+	events  chan logger.BlockEvent
+	wg      sync.WaitGroup
+	onError func(error)
+}
+
+// NewSink starts a background writer for store. onError may be nil.
+func NewSink(store *Store, onError func(error)) *Sink {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	s := &Sink{
+		store:   store,
+		events:  make(chan logger.BlockEvent, sinkBuffer),
+		onError: onError,
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// WriteEvent queues an event for recording
+func (s *Sink) WriteEvent(event logger.BlockEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	select {
+	case s.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("history buffer full, dropped %s event for %s", event.Event, event.IP)
+	}
+}
+
+// Close writes pending events and stops the writer
+func (s *Sink) Close() error {
+	close(s.events)
+	s.wg.Wait()
+	return nil
+}
+
+// run writes queued events in batches
+func (s *Sink) run() {
+	defer s.wg.Done()
+
+	for event := range s.events {
+		batch := []logger.BlockEvent{event}
+	collect:
+		for len(batch) < 100 {
+			select {
+			case next, ok := <-s.events:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+
+		if err := s.store.Record(batch...); err != nil {
+			s.onError(fmt.Errorf("failed to record %d events: %w", len(batch), err))
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package history stores ban lifecycle events in an indexed local database
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// Bucket names
+var (
+	bucketEvents = []byte("events") // event key -> event JSON
+	bucketByIP   = []byte("by_ip")  // ip \x00 event key -> nil
+	bucketByBan  = []byte("by_ban") // ban id \x00 event key -> nil
+)
+
+// openTimeout bounds how long an operation waits for the database lock
+const openTimeout = 5 * time.Second
+
+// Store is the ban history database. The database file is opened for each
+// operation rather than held open, so the history command can read it while
+// the daemon is running.
+type Store struct {
+	path string
+}
+
+// Open creates the database at path if needed and returns a store for it
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	s := &Store{path: path}
+	err := s.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketEvents, bucketByIP, bucketByBan} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize history database: %w", err)
+	}
+	return s, nil
+}
+
+// OpenExisting returns a store for an existing database without modifying it
+func OpenExisting(path string) (*Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	return &Store{path: path}, nil
+}
+
+// Path returns the database file path
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Record stores events in a single transaction
+func (s *Store) Record(events ...logger.BlockEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		eventsBucket := tx.Bucket(bucketEvents)
+		ipBucket := tx.Bucket(bucketByIP)
+		banBucket := tx.Bucket(bucketByBan)
+
+		for _, event := range events {
+			if event.Timestamp.IsZero() {
+				event.Timestamp = time.Now()
+			}
+			seq, err := eventsBucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := eventKey(event.Timestamp, seq)
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("failed to marshal event: %w", err)
+			}
+			if err := eventsBucket.Put(key, data); err != nil {
+				return err
+			}
+			if err := ipBucket.Put(indexKey(event.IP, key), nil); err != nil {
+				return err
+			}
+			if event.BanID != "" {
+				if err := banBucket.Put(indexKey(event.BanID, key), nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Query returns events matching the filter in chronological order.
+// A single-IP filter is answered from the IP index, everything else by
+// scanning the requested time range.
+func (s *Store) Query(f Filter) ([]logger.BlockEvent, error) {
+	if err := f.compile(); err != nil {
+		return nil, err
+	}
+
+	var result []logger.BlockEvent
+	err := s.view(func(tx *bolt.Tx) error {
+		eventsBucket := tx.Bucket(bucketEvents)
+
+		collect := func(data []byte) (bool, error) {
+			var event logger.BlockEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return false, fmt.Errorf("failed to decode event: %w", err)
+			}
+			if f.Match(event) {
+				result = append(result, event)
+			}
+			return f.Limit > 0 && len(result) >= f.Limit, nil
+		}
+
+		if ip := f.singleIP(); ip != "" {
+			return scanIndex(tx.Bucket(bucketByIP), ip, f.Since, f.Until, func(key []byte) (bool, error) {
+				return collect(eventsBucket.Get(key))
+			})
+		}
+		if f.BanID != "" {
+			return scanIndex(tx.Bucket(bucketByBan), f.BanID, f.Since, f.Until, func(key []byte) (bool, error) {
+				return collect(eventsBucket.Get(key))
+			})
+		}
+
+		c := eventsBucket.Cursor()
+		var k, v []byte
+		if f.Since.IsZero() {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(timePrefix(f.Since))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if !f.Until.IsZero() && bytes.Compare(k, timePrefix(f.Until)) >= 0 {
+				break
+			}
+			done, err := collect(v)
+			if err != nil || done {
+				return err
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Count returns the number of stored events
+func (s *Store) Count() (int, error) {
+	var n int
+	err := s.view(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketEvents).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// scanIndex walks index entries of value within a time range
+func scanIndex(bucket *bolt.Bucket, value string, since, until time.Time, fn func(eventKey []byte) (bool, error)) error {
+	prefix := append([]byte(value), 0)
+	start := prefix
+	if !since.IsZero() {
+		start = append(append([]byte{}, prefix...), timePrefix(since)...)
+	}
+
+	c := bucket.Cursor()
+	for k, _ := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		key := k[len(prefix):]
+		if !until.IsZero() && bytes.Compare(key, timePrefix(until)) >= 0 {
+			break
+		}
+		done, err := fn(key)
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventKey orders events by time; the sequence keeps keys unique
+func eventKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// timePrefix returns the smallest event key at time t
+func timePrefix(t time.Time) []byte {
+	return eventKey(t, 0)[:8]
+}
+
+// indexKey builds a secondary index key
+func indexKey(value string, eventKey []byte) []byte {
+	key := make([]byte, 0, len(value)+1+len(eventKey))
+	key = append(key, value...)
+	key = append(key, 0)
+	return append(key, eventKey...)
+}
+
+// update runs fn in a read-write transaction
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return fmt.Errorf("failed to open history database: %w", err)
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+// view runs fn in a read-only transaction
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	if _, err := os.Stat(s.path); err != nil {
+		return fmt.Errorf("failed to open history database: %w", err)
+	}
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to open history database: %w", err)
+	}
+	defer db.Close()
+	return db.View(fn)
+}
@@ -0,0 +1,143 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+func testStore(t *testing.T) (*Store, time.Time) {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	base := time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local)
+	events := []logger.BlockEvent{
+		{Timestamp: base, Event: logger.EventBlocked, IP: "10.0.0.1", PeerID: "-XL0019-abcdefghijkl", ClientName: "Xunlei 0.0.1.9", Reason: "low_share_ratio", BanID: "a1", Violations: 1, InfoHash: "abcdef", TorrentName: "Ubuntu ISO"},
+		{Timestamp: base.Add(5 * time.Minute), Event: logger.EventExpired, IP: "10.0.0.1", PeerID: "-XL0019-abcdefghijkl", ClientName: "Xunlei 0.0.1.9", Reason: "low_share_ratio", BanID: "a1", BannedFor: "5m0s"},
+		{Timestamp: base.Add(time.Hour), Event: logger.EventBlocked, IP: "10.0.0.1", PeerID: "-XL0019-abcdefghijkl", ClientName: "Xunlei 0.0.1.9", Reason: "low_share_ratio", BanID: "a2", Violations: 2},
+		{Timestamp: base.Add(2 * time.Hour), Event: logger.EventBlocked, IP: "10.0.1.7", PeerID: "-qB4390-abcdefghijkl", ClientName: "qBittorrent 4.3.9", Reason: "low_share_ratio", BanID: "b1", Violations: 1},
+		{Timestamp: base.Add(26 * time.Hour), Event: logger.EventBlocked, IP: "192.168.1.5", PeerID: "-TR2940-abcdefghijkl", ClientName: "Transmission 2.94", Reason: "client_rule:test", BanID: "c1", Violations: 1},
+	}
+	if err := store.Record(events...); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	return store, base
+}
+
+func TestQueryFilters(t *testing.T) {
+	store, base := testStore(t)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"all", Filter{}, 5},
+		{"single IP", Filter{IP: "10.0.0.1"}, 3},
+		{"CIDR", Filter{IP: "10.0.0.0/16"}, 4},
+		{"client", Filter{Client: "xunlei"}, 3},
+		{"version", Filter{Version: ">=2.0 <4.4"}, 2},
+		{"client and version", Filter{Client: "qbittorrent", Version: "<4.3"}, 0},
+		{"reason", Filter{Reason: "client_rule:test"}, 1},
+		{"event type", Filter{Events: []string{logger.EventBlocked}}, 4},
+		{"torrent hash", Filter{Torrent: "ABC"}, 1},
+		{"torrent name", Filter{Torrent: "ubuntu"}, 1},
+		{"ban", Filter{BanID: "a1"}, 2},
+		{"since", Filter{Since: base.Add(30 * time.Minute)}, 3},
+		{"range", Filter{Since: base.Add(30 * time.Minute), Until: base.Add(2 * time.Hour)}, 1},
+		{"IP and range", Filter{IP: "10.0.0.1", Since: base.Add(time.Minute)}, 2},
+		{"limit", Filter{Limit: 2}, 2},
+	}
+
+	for _, tt := range tests {
+		events, err := store.Query(tt.filter)
+		if err != nil {
+			t.Errorf("%s: Query failed: %v", tt.name, err)
+			continue
+		}
+		if len(events) != tt.want {
+			t.Errorf("%s: expected %d events, got %d", tt.name, tt.want, len(events))
+		}
+	}
+
+	if _, err := store.Query(Filter{IP: "10.0.0.0/99"}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	if _, err := store.Query(Filter{Version: ">=4.x"}); err == nil {
+		t.Error("Expected error for invalid version range")
+	}
+}
+
+func TestQueryOrder(t *testing.T) {
+	store, _ := testStore(t)
+	events, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Timestamp.Before(events[i-1].Timestamp) {
+			t.Fatalf("Events out of order at %d", i)
+		}
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	store, _ := testStore(t)
+	events, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hypothetical bans of dry-run mode are left out unless asked for
+	events = append(events, logger.BlockEvent{Timestamp: events[0].Timestamp, Event: logger.EventWouldBlock, IP: "10.0.9.9", BanID: "d1"})
+
+	days := BansPerDay(events, false)
+	if len(days) != 2 {
+		t.Fatalf("Expected 2 days, got %d", len(days))
+	}
+	if days[0].Day != "2024-01-15" || days[0].Bans != 3 || days[0].IPs != 2 {
+		t.Errorf("Unexpected first day %+v", days[0])
+	}
+
+	if days := BansPerDay(events, true); days[0].Bans != 4 || days[0].IPs != 3 {
+		t.Errorf("Expected would_block counted with dryRun, got %+v", days[0])
+	}
+
+	top := TopOffenders(events, 2, false)
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 offenders, got %d", len(top))
+	}
+	if all := TopOffenders(events, 0, false); len(all) != 3 {
+		t.Errorf("Expected 3 offenders without dry-run bans, got %d", len(all))
+	}
+	if all := TopOffenders(events, 0, true); len(all) != 4 {
+		t.Errorf("Expected 4 offenders with dry-run bans, got %d", len(all))
+	}
+	if top[0].IP != "10.0.0.1" || top[0].Bans != 2 || top[0].MaxViolations != 2 {
+		t.Errorf("Unexpected top offender %+v", top[0])
+	}
+	if top[0].TotalBanned != 5*time.Minute {
+		t.Errorf("Expected 5m total banned, got %s", top[0].TotalBanned)
+	}
+}
+
+func TestSink(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewSink(store, func(err error) { t.Error(err) })
+	for i := 0; i < 10; i++ {
+		sink.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1"})
+	}
+	sink.Close()
+
+	if n, err := store.Count(); err != nil || n != 10 {
+		t.Errorf("Expected 10 recorded events, got %d (%v)", n, err)
+	}
+}
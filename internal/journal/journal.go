@@ -0,0 +1,119 @@
+// Package journal writes entries to the systemd journal using its native protocol
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultSocket is the path of journald's native protocol socket
+const DefaultSocket = "/run/systemd/journal/socket"
+
+// Priority is a syslog priority as understood by journald
+type Priority int
+
+// Syslog priorities
+const (
+	PriEmerg Priority = iota
+	PriAlert
+	PriCrit
+	PriErr
+	PriWarning
+	PriNotice
+	PriInfo
+	PriDebug
+)
+
+// Client sends entries to journald over a unix datagram socket
+type Client struct {
+	conn  *net.UnixConn
+	addr  *net.UnixAddr
+	mutex sync.Mutex
+}
+
+// Available reports whether a journal socket exists at path
+func Available(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// NewClient creates a client for the journal socket at path
+func NewClient(path string) (*Client, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal socket: %w", err)
+	}
+
+	return &Client{
+		conn: conn,
+		addr: &net.UnixAddr{Name: path, Net: "unixgram"},
+	}, nil
+}
+
+// Send writes one journal entry. Field names must consist of upper-case
+// letters, digits and underscores and must not start with an underscore;
+// invalid names are sanitized.
+func (c *Client) Send(message string, priority Priority, fields map[string]string) error {
+	var buf bytes.Buffer
+	appendField(&buf, "MESSAGE", message)
+	appendField(&buf, "PRIORITY", fmt.Sprintf("%d", priority))
+	for name, value := range fields {
+		appendField(&buf, FieldName(name), value)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := c.conn.WriteToUnix(buf.Bytes(), c.addr); err != nil {
+		return fmt.Errorf("failed to write to journal: %w", err)
+	}
+	return nil
+}
+
+// Close closes the client socket
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// appendField serializes one field. Values containing newlines use the
+// binary form: NAME\n<little-endian uint64 length><value>\n
+func appendField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// FieldName converts name into a valid journal field name
+func FieldName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	result := strings.TrimLeft(b.String(), "_")
+	if result == "" || (result[0] >= '0' && result[0] <= '9') {
+		result = "F_" + result
+	}
+	return result
+}
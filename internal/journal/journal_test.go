@@ -0,0 +1,59 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if !Available(path) {
+		t.Fatal("Expected the socket to be available")
+	}
+
+	client, err := NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Send("blocked", PriNotice, map[string]string{"stack": "line 1\nline 2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	n, _, err := server.ReadFromUnix(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Multi-line values use the binary form with a length prefix
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len("line 1\nline 2")))
+	want := "MESSAGE=blocked\nPRIORITY=5\nSTACK\n" + string(size[:]) + "line 1\nline 2\n"
+	if got := buf[:n]; !bytes.Equal(got, []byte(want)) {
+		t.Errorf("Datagram = %q, want %q", got, want)
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	tests := map[string]string{
+		"client_name": "CLIENT_NAME",
+		"peer-id":     "PEER_ID",
+		"_private":    "PRIVATE",
+		"2fa":         "F_2FA",
+		"":            "F_",
+	}
+	for name, want := range tests {
+		if got := FieldName(name); got != want {
+			t.Errorf("FieldName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
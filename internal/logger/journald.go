@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lbl1m/aria2bango/internal/journal"
+)
+
+// JournalFieldPrefix prefixes block event fields in the journal
+const JournalFieldPrefix = "ARIA2BANGO_"
+
+// journalFieldNames renames event fields whose JSON name is not the
+// natural journal field, e.g. client_name -> ARIA2BANGO_CLIENT
+var journalFieldNames = map[string]string{
+	"client_name": "CLIENT",
+}
+
+// JournalSink sends block events to journald with every event field as a
+// structured journal field, so they can be matched directly:
+//
+//	journalctl ARIA2BANGO_REASON=low_share_ratio
+//	journalctl ARIA2BANGO_IP=192.168.1.100
+type JournalSink struct {
+	client *journal.Client
+}
+
+// NewJournalSink creates a sink writing to the journal socket at path
+func NewJournalSink(path string) (*JournalSink, error) {
+	if path == "" {
+		path = journal.DefaultSocket
+	}
+	if !journal.Available(path) {
+		return nil, fmt.Errorf("journal socket %s not found", path)
+	}
+	client, err := journal.NewClient(path)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalSink{client: client}, nil
+}
+
+// WriteEvent sends one event to the journal
+func (s *JournalSink) WriteEvent(event BlockEvent) error {
+	fields, err := journalFields(event)
+	if err != nil {
+		return err
+	}
+	fields["SYSLOG_IDENTIFIER"] = SyslogIdentifier
+
+	return s.client.Send(eventMessage(event), journal.Priority(eventSeverity(event.Event)), fields)
+}
+
+// Close closes the journal connection
+func (s *JournalSink) Close() error {
+	return s.client.Close()
+}
+
+// journalFields flattens an event into ARIA2BANGO_* journal fields
+func journalFields(event BlockEvent) (map[string]string, error) {
+	values, err := eventFields(event)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(values))
+	for key, text := range values {
+		name, ok := journalFieldNames[key]
+		if !ok {
+			name = journal.FieldName(key)
+		}
+		fields[JournalFieldPrefix+name] = text
+	}
+	return fields, nil
+}
+
+// eventFields flattens an event into its JSON field names and text values.
+// Fields are left out where the JSON omits them, and empty strings too;
+// numbers are always kept, a share ratio of 0 is what most bans are about.
+// The timestamp is left out, sinks carry their own.
+func eventFields(event BlockEvent) (map[string]string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+
+	fields := make(map[string]string, len(values))
+	for key, value := range values {
+		if key == "timestamp" {
+			continue
+		}
+		if text := fieldText(value); text != "" {
+			fields[key] = text
+		}
+	}
+	return fields, nil
+}
+
+// fieldText formats a decoded JSON value as text, "" for null and empty
+// strings
+func fieldText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// eventMessage returns a human-readable summary of an event
+func eventMessage(event BlockEvent) string {
+	parts := []string{event.Event, event.IP}
+	if event.ClientName != "" {
+		parts = append(parts, "("+event.ClientName+")")
+	}
+	if event.Reason != "" {
+		parts = append(parts, "reason="+event.Reason)
+	}
+	if event.Duration != "" && event.Duration != "0s" {
+		parts = append(parts, "duration="+event.Duration)
+	}
+	if event.BanID != "" {
+		parts = append(parts, "ban_id="+event.BanID)
+	}
+	return strings.Join(parts, " ")
+}
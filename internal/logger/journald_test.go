@@ -0,0 +1,161 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// fakeJournal listens on a unix datagram socket like journald does
+func fakeJournal(t *testing.T) (string, *net.UnixConn) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return path, conn
+}
+
+// readEntry reads one datagram and decodes the native journal protocol
+func readEntry(t *testing.T, conn *net.UnixConn) map[string]string {
+	t.Helper()
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFromUnix(buf)
+	if err != nil {
+		t.Fatalf("Failed to read journal entry: %v", err)
+	}
+
+	fields := make(map[string]string)
+	data := buf[:n]
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			t.Fatalf("Truncated entry: %q", data)
+		}
+		line := data[:nl]
+		data = data[nl+1:]
+
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			continue
+		}
+		// Binary form: NAME\n<uint64 length><value>\n
+		size := binary.LittleEndian.Uint64(data[:8])
+		fields[string(line)] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournalSink(t *testing.T) {
+	path, conn := fakeJournal(t)
+
+	sink, err := NewJournalSink(path)
+	if err != nil {
+		t.Fatalf("NewJournalSink failed: %v", err)
+	}
+	defer sink.Close()
+
+	err = sink.WriteEvent(BlockEvent{
+		Timestamp:  time.Now(),
+		Event:      EventBlocked,
+		IP:         "192.168.1.100",
+		PeerID:     "-XL0019-abcdefghijkl",
+		ClientName: "Xunlei 0.0.1.9",
+		Reason:     "low_share_ratio",
+		Duration:   "15m0s",
+		ShareRatio: 0.001,
+		BanID:      "0123456789abcdef",
+		Violations: 3,
+	})
+	if err != nil {
+		t.Fatalf("WriteEvent failed: %v", err)
+	}
+
+	fields := readEntry(t, conn)
+	expected := map[string]string{
+		"ARIA2BANGO_EVENT":       "blocked",
+		"ARIA2BANGO_IP":          "192.168.1.100",
+		"ARIA2BANGO_CLIENT":      "Xunlei 0.0.1.9",
+		"ARIA2BANGO_REASON":      "low_share_ratio",
+		"ARIA2BANGO_DURATION":    "15m0s",
+		"ARIA2BANGO_SHARE_RATIO": "0.001",
+		"ARIA2BANGO_BAN_ID":      "0123456789abcdef",
+		"ARIA2BANGO_VIOLATIONS":  "3",
+		"PRIORITY":               "5",
+		"SYSLOG_IDENTIFIER":      "aria2bango",
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, fields[k])
+		}
+	}
+	if _, ok := fields["ARIA2BANGO_TIMESTAMP"]; ok {
+		t.Error("Timestamp should be left to the journal")
+	}
+	if fields["ARIA2BANGO_UPLOAD_SPEED"] != "0" {
+		t.Errorf("Expected zero numbers to be kept, got %q", fields["ARIA2BANGO_UPLOAD_SPEED"])
+	}
+	if _, ok := fields["ARIA2BANGO_PEER_UPLOADED"]; ok {
+		t.Error("Fields omitted from the JSON should be omitted")
+	}
+	if fields["MESSAGE"] == "" {
+		t.Error("Expected a MESSAGE field")
+	}
+}
+
+func TestJournalSinkMultilineValue(t *testing.T) {
+	path, conn := fakeJournal(t)
+
+	sink, err := NewJournalSink(path)
+	if err != nil {
+		t.Fatalf("NewJournalSink failed: %v", err)
+	}
+	defer sink.Close()
+
+	if err := sink.WriteEvent(BlockEvent{Event: EventUnblockedManual, IP: "10.0.0.1", Reason: "line one\nline two"}); err != nil {
+		t.Fatalf("WriteEvent failed: %v", err)
+	}
+
+	fields := readEntry(t, conn)
+	if fields["ARIA2BANGO_REASON"] != "line one\nline two" {
+		t.Errorf("Expected multi-line reason to survive, got %q", fields["ARIA2BANGO_REASON"])
+	}
+}
+
+func TestJournalSinkMissingSocket(t *testing.T) {
+	if _, err := NewJournalSink(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("Expected error for missing journal socket")
+	}
+}
+
+func TestLoggerForwardsToSink(t *testing.T) {
+	path, conn := fakeJournal(t)
+
+	cfg := &config.LoggingConfig{File: filepath.Join(t.TempDir(), "blocked.log")}
+	l, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewJournalSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.AddSink(sink)
+	defer l.Close()
+
+	if err := l.LogBlock(BlockEvent{IP: "10.0.0.2", Reason: "low_share_ratio"}); err != nil {
+		t.Fatalf("LogBlock failed: %v", err)
+	}
+	if fields := readEntry(t, conn); fields["ARIA2BANGO_IP"] != "10.0.0.2" {
+		t.Errorf("Expected forwarded event, got %v", fields)
+	}
+}
@@ -3,6 +3,7 @@ package logger
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,6 +13,59 @@ import (
 	"github.com/lbl1m/aria2bango/internal/config"
 )
 
+// Ban lifecycle event types
+const (
+	EventBlocked         = "blocked"
+	EventEscalated       = "escalated"        // 再次违规，屏蔽时长累加
+	EventExpired         = "expired"          // 屏蔽到期
+	EventUnblockedManual = "unblocked_manual" // 手动解除屏蔽
+	EventForgiven        = "forgiven"         // 分享率恢复，违规次数清零
+	EventForgotten       = "forgotten"        // 违规记录衰减为零或超过forget_after，违规次数清零
+	EventWouldBlock      = "would_block"      // 观察模式下本应屏蔽
+	EventThrottled       = "throttled"        // 客户端规则限速
+	EventWouldThrottle   = "would_throttle"   // 观察模式下本应限速
+	EventWatched         = "watched"          // 客户端规则只记录
+)
+
+// EventTypes lists all block log event types
+var EventTypes = []string{
+	EventBlocked,
+	EventEscalated,
+	EventExpired,
+	EventUnblockedManual,
+	EventForgiven,
+	EventForgotten,
+	EventWouldBlock,
+	EventThrottled,
+	EventWouldThrottle,
+	EventWatched,
+}
+
+// Severities of block events, as syslog severities. journald priorities use
+// the same values.
+const (
+	severityNotice = 5
+	severityInfo   = 6
+)
+
+// eventSeverities maps the event types that need attention to notice,
+// the others are informational
+var eventSeverities = map[string]int{
+	EventBlocked:       severityNotice,
+	EventEscalated:     severityNotice,
+	EventWouldBlock:    severityNotice,
+	EventThrottled:     severityNotice,
+	EventWouldThrottle: severityNotice,
+}
+
+// eventSeverity returns the severity of an event type, used by all sinks
+func eventSeverity(eventType string) int {
+	if severity, ok := eventSeverities[eventType]; ok {
+		return severity
+	}
+	return severityInfo
+}
+
 // BlockEvent represents a block event for logging
 type BlockEvent struct {
 	Timestamp     time.Time `json:"timestamp"`
@@ -24,12 +78,68 @@ type BlockEvent struct {
 	DownloadSpeed int64     `json:"download_speed"`
 	UploadSpeed   int64     `json:"upload_speed"`
 	ShareRatio    float64   `json:"share_ratio"`
+	InfoHash      string    `json:"info_hash,omitempty"`
+	TorrentName   string    `json:"torrent_name,omitempty"`
+	Instance      string    `json:"instance,omitempty"` // aria2实例名称，监控多个实例时
+
+	// Detection evidence: what the detector had accumulated for the peer
+	// and the threshold it was compared against
+	PeerUploaded   int64   `json:"peer_uploaded,omitempty"`
+	PeerDownloaded int64   `json:"peer_downloaded,omitempty"`
+	MinShareRatio  float64 `json:"min_share_ratio,omitempty"`
+
+	// share_ratio is computed as configured by detection.behavior.ratio;
+	// the ratio over the whole connection and the confidence go with it
+	LifetimeShareRatio float64 `json:"lifetime_share_ratio,omitempty"`
+	RatioConfidence    float64 `json:"ratio_confidence,omitempty"`
+
+	// Swarm comparison (reason swarm_outlier): number of peers compared,
+	// the peer's z-score and percentile rank among them, and the part of
+	// the pieces we lack that it has
+	SwarmPeers        int     `json:"swarm_peers,omitempty"`
+	SwarmZScore       float64 `json:"swarm_z_score,omitempty"`
+	SwarmPercentile   float64 `json:"swarm_percentile,omitempty"`
+	PieceAvailability float64 `json:"piece_availability,omitempty"`
+
+	// Choke reciprocity (reason non_reciprocating): how long the peer kept
+	// choking us and what we uploaded to it meanwhile
+	ChokedFor    string `json:"choked_for,omitempty"`
+	ChokedUpload int64  `json:"choked_upload,omitempty"`
+
+	// Upload credit left when the event was logged, see detection.credit
+	CreditBalance int64 `json:"credit_balance,omitempty"`
+
+	// Burst drain (reason burst_drain): consecutive polls the peer took
+	// more than min_fraction of our upload, and its part in the last one
+	BurstPolls     int     `json:"burst_polls,omitempty"`
+	UploadFraction float64 `json:"upload_fraction,omitempty"`
+
+	// Structural problems of the peer ID, 0-1 score and anomaly codes
+	PeerIDScore     float64  `json:"peer_id_score,omitempty"`
+	PeerIDAnomalies []string `json:"peer_id_anomalies,omitempty"`
+
+	// Ban lifecycle fields. BanID links expired/unblocked/forgiven events
+	// back to the blocked event that started the ban.
+	BanID           string `json:"ban_id,omitempty"`
+	PreviousBanID   string `json:"previous_ban_id,omitempty"`
+	Violations      int    `json:"violations,omitempty"`
+	BannedFor       string `json:"banned_for,omitempty"`
+	BytesDownloaded int64  `json:"bytes_downloaded,omitempty"`
+	BytesUploaded   int64  `json:"bytes_uploaded,omitempty"`
+}
+
+// Sink receives every event written to the block log, e.g. to forward it
+// to journald or syslog
+type Sink interface {
+	WriteEvent(event BlockEvent) error
+	Close() error
 }
 
 // Logger handles logging blocked peers
 type Logger struct {
 	config *config.LoggingConfig
-	file   *os.File
+	out    *rotatingFile
+	sinks  []Sink
 	mutex  sync.Mutex
 }
 
@@ -41,94 +151,91 @@ func NewLogger(cfg *config.LoggingConfig) (*Logger, error) {
 		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 
-	file, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	out, err := openRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups, cfg.MaxAge, cfg.Compress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 
 	return &Logger{
 		config: cfg,
-		file:   file,
+		out:    out,
 	}, nil
 }
 
-// LogBlock logs a block event
-func (l *Logger) LogBlock(event BlockEvent) error {
+// Log logs a ban lifecycle event. The timestamp is filled in if unset.
+func (l *Logger) Log(event BlockEvent) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	event.Timestamp = time.Now()
-	event.Event = "blocked"
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
 
 	data, err := json.Marshal(event)
 	if err != nil {
 		return fmt.Errorf("failed to marshal event: %w", err)
 	}
 
-	_, err = l.file.WriteString(string(data) + "\n")
-	if err != nil {
-		return fmt.Errorf("failed to write to log file: %w", err)
+	var errs []error
+	if _, err := l.out.Write(append(data, '\n')); err != nil {
+		errs = append(errs, fmt.Errorf("failed to write to log file: %w", err))
+	}
+	for _, sink := range l.sinks {
+		if err := sink.WriteEvent(event); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-// LogUnblock logs an unblock event
-func (l *Logger) LogUnblock(ip string, reason string) error {
+// AddSink forwards all subsequent events to sink as well. The sink is
+// closed together with the logger.
+func (l *Logger) AddSink(sink Sink) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
+	l.sinks = append(l.sinks, sink)
+}
 
-	event := map[string]interface{}{
-		"timestamp": time.Now(),
-		"event":     "unblocked",
-		"ip":        ip,
-		"reason":    reason,
-	}
-
-	data, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
-	}
-
-	_, err = l.file.WriteString(string(data) + "\n")
-	if err != nil {
-		return fmt.Errorf("failed to write to log file: %w", err)
-	}
+// LogBlock logs a block event
+func (l *Logger) LogBlock(event BlockEvent) error {
+	event.Timestamp = time.Now()
+	event.Event = EventBlocked
+	return l.Log(event)
+}
 
-	return nil
+// LogUnblock logs a manual unblock event
+func (l *Logger) LogUnblock(ip string, reason string) error {
+	return l.Log(BlockEvent{
+		Event:  EventUnblockedManual,
+		IP:     ip,
+		Reason: reason,
+	})
 }
 
-// Close closes the log file
+// Close closes the log file and all sinks
 func (l *Logger) Close() error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
-	return l.file.Close()
+
+	errs := []error{l.out.Close()}
+	for _, sink := range l.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
 }
 
-// Rotate rotates the log file (simple implementation)
+// Rotate rotates the log file now. The old file is compressed and old
+// backups are pruned according to max_backups and max_age.
 func (l *Logger) Rotate() error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
+	return l.out.Rotate()
+}
 
-	// Close current file
-	if err := l.file.Close(); err != nil {
-		return err
-	}
-
-	// Rename current file
-	backup := fmt.Sprintf("%s.%s", l.config.File, time.Now().Format("20060102-150405"))
-	if err := os.Rename(l.config.File, backup); err != nil {
-		// If rename fails, try to reopen the original file
-		l.file, _ = os.OpenFile(l.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		return err
-	}
-
-	// Open new file
-	file, err := os.OpenFile(l.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	l.file = file
-
-	return nil
+// Reopen reopens the log file, for use after logrotate has moved it away
+func (l *Logger) Reopen() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.out.Reopen()
 }
@@ -0,0 +1,185 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/journal"
+)
+
+// Operational log outputs besides a file path
+const (
+	OutputStdout   = "stdout"
+	OutputStderr   = "stderr"
+	OutputJournald = "journald"
+)
+
+// Operational log formats
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// SyslogIdentifier identifies aria2bango entries in the journal
+const SyslogIdentifier = "aria2bango"
+
+// NewOperational builds the operational logger from the logging config.
+// The returned level can be changed at runtime, e.g. via the control API.
+func NewOperational(cfg *config.LoggingConfig) (*zap.Logger, zap.AtomicLevel, error) {
+	level := zap.NewAtomicLevel()
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, level, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+		}
+	}
+
+	var core zapcore.Core
+	switch cfg.Output {
+	case OutputJournald:
+		if !journal.Available(journal.DefaultSocket) {
+			return nil, level, fmt.Errorf("journal socket %s not found", journal.DefaultSocket)
+		}
+		client, err := journal.NewClient(journal.DefaultSocket)
+		if err != nil {
+			return nil, level, err
+		}
+		core = newJournalCore(client, level)
+
+	default:
+		encoder, err := newEncoder(cfg.Format)
+		if err != nil {
+			return nil, level, err
+		}
+		out, err := openOutput(cfg.Output)
+		if err != nil {
+			return nil, level, err
+		}
+		core = zapcore.NewCore(encoder, out, level)
+	}
+
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)), level, nil
+}
+
+// newEncoder returns a zap encoder for the configured format
+func newEncoder(format string) (zapcore.Encoder, error) {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	switch format {
+	case "", FormatJSON:
+		return zapcore.NewJSONEncoder(encoderConfig), nil
+	case FormatConsole:
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(encoderConfig), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want %s or %s)", format, FormatJSON, FormatConsole)
+	}
+}
+
+// openOutput opens stdout, stderr or a log file for appending
+func openOutput(output string) (zapcore.WriteSyncer, error) {
+	switch output {
+	case "", OutputStderr:
+		return zapcore.Lock(os.Stderr), nil
+	case OutputStdout:
+		return zapcore.Lock(os.Stdout), nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return zapcore.Lock(file), nil
+}
+
+// journalCore is a zapcore.Core writing entries natively to journald.
+// Structured fields become journal fields, e.g. zap.String("ip", ...) is
+// stored as IP=... and can be matched with journalctl IP=...
+type journalCore struct {
+	zapcore.LevelEnabler
+	client *journal.Client
+	fields []zapcore.Field
+}
+
+// newJournalCore creates a core that writes to client
+func newJournalCore(client *journal.Client, level zapcore.LevelEnabler) *journalCore {
+	return &journalCore{LevelEnabler: level, client: client}
+}
+
+// With returns a core that adds fields to every entry
+func (c *journalCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field{}, c.fields...), fields...)
+	return &clone
+}
+
+// Check adds the core to the checked entry if the level is enabled
+func (c *journalCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write sends one entry to the journal
+func (c *journalCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	journalFields := map[string]string{
+		"SYSLOG_IDENTIFIER": SyslogIdentifier,
+	}
+	if entry.LoggerName != "" {
+		journalFields["LOGGER"] = entry.LoggerName
+	}
+	if entry.Caller.Defined {
+		journalFields["CODE_FILE"] = entry.Caller.File
+		journalFields["CODE_LINE"] = fmt.Sprintf("%d", entry.Caller.Line)
+		journalFields["CODE_FUNC"] = entry.Caller.Function
+	}
+	if entry.Stack != "" {
+		journalFields["STACKTRACE"] = entry.Stack
+	}
+	for k, v := range enc.Fields {
+		journalFields[journal.FieldName(k)] = fmt.Sprint(v)
+	}
+
+	return c.client.Send(entry.Message, journalPriority(entry.Level), journalFields)
+}
+
+// Sync is a no-op, journal writes are unbuffered
+func (c *journalCore) Sync() error {
+	return nil
+}
+
+// journalPriority maps a zap level to a syslog priority
+func journalPriority(level zapcore.Level) journal.Priority {
+	switch level {
+	case zapcore.DebugLevel:
+		return journal.PriDebug
+	case zapcore.InfoLevel:
+		return journal.PriInfo
+	case zapcore.WarnLevel:
+		return journal.PriWarning
+	case zapcore.ErrorLevel:
+		return journal.PriErr
+	case zapcore.DPanicLevel, zapcore.PanicLevel, zapcore.FatalLevel:
+		return journal.PriCrit
+	default:
+		return journal.PriInfo
+	}
+}
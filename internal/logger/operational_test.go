@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+func TestNewOperational(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log", "aria2bango.log")
+	log, level, err := NewOperational(&config.LoggingConfig{Level: "warn", Format: FormatJSON, Output: path})
+	if err != nil {
+		t.Fatalf("NewOperational failed: %v", err)
+	}
+	log.Info("hidden")
+	log.Warn("shown")
+
+	// The level can be changed at runtime
+	level.SetLevel(zapcore.DebugLevel)
+	log.Debug("debug")
+	log.Sync()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries, got %q", lines)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Expected JSON entries: %v", err)
+	}
+	if entry["msg"] != "shown" || entry["level"] != "warn" || entry["timestamp"] == nil {
+		t.Errorf("Unexpected entry: %v", entry)
+	}
+	if !strings.Contains(lines[1], `"msg":"debug"`) {
+		t.Errorf("Expected debug entry after changing the level, got %s", lines[1])
+	}
+}
+
+func TestNewOperationalConsole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aria2bango.log")
+	log, _, err := NewOperational(&config.LoggingConfig{Level: "info", Format: FormatConsole, Output: path})
+	if err != nil {
+		t.Fatalf("NewOperational failed: %v", err)
+	}
+	log.Info("started")
+	log.Sync()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := string(data); !strings.Contains(line, "\tINFO\t") || !strings.Contains(line, "started") || strings.HasPrefix(line, "{") {
+		t.Errorf("Expected a console entry, got %q", line)
+	}
+}
+
+func TestNewOperationalInvalid(t *testing.T) {
+	if _, _, err := NewOperational(&config.LoggingConfig{Level: "loud"}); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+	if _, _, err := NewOperational(&config.LoggingConfig{Format: "xml"}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReadStats describes what ReadEvents went through
+type ReadStats struct {
+	Files   int // files read, including rotated and gzipped ones
+	Events  int // events passed to the callback
+	Skipped int // lines that could not be parsed
+}
+
+// ReadEvents reads events at or after since from the block log at path and
+// its rotated backups (gzipped or not), oldest file first, and calls fn for
+// each. A missing log is not an error.
+func ReadEvents(path string, since time.Time, fn func(BlockEvent) error) (ReadStats, error) {
+	var stats ReadStats
+
+	backups, err := listBackups(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return stats, fmt.Errorf("failed to list rotated logs: %w", err)
+	}
+
+	// listBackups is newest first; a backup's timestamp is when it was
+	// rotated, so older backups only hold events before that time
+	var files []string
+	for i := len(backups) - 1; i >= 0; i-- {
+		if !since.IsZero() && backups[i].time.Before(since) {
+			continue
+		}
+		files = append(files, backups[i].path)
+	}
+	files = append(files, path)
+
+	for _, file := range files {
+		read, err := readEventFile(file, since, &stats, fn)
+		if err != nil {
+			return stats, err
+		}
+		if read {
+			stats.Files++
+		}
+	}
+	return stats, nil
+}
+
+// readEventFile reads one log file, returning false if it does not exist
+func readEventFile(path string, since time.Time, stats *ReadStats, fn func(BlockEvent) error) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, compressSuffix) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return false, fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var event BlockEvent
+		if err := json.Unmarshal(line, &event); err != nil || event.Event == "" {
+			stats.Skipped++
+			continue
+		}
+		if !since.IsZero() && event.Timestamp.Before(since) {
+			continue
+		}
+		stats.Events++
+		if err := fn(event); err != nil {
+			return true, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return true, nil
+}
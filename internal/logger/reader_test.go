@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeEventLines(t *testing.T, path string, compress bool, lines ...interface{}) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w interface{ Write([]byte) (int, error) } = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	for _, line := range lines {
+		var data []byte
+		if s, ok := line.(string); ok {
+			data = []byte(s)
+		} else if data, err = json.Marshal(line); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadEventsAcrossBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocked.log")
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	oldest := base.Add(-48 * time.Hour)
+	writeEventLines(t, path+"."+oldest.Format(backupTimeFormat)+compressSuffix, true,
+		BlockEvent{Timestamp: oldest.Add(-time.Minute), Event: EventBlocked, IP: "10.0.0.9"})
+	writeEventLines(t, path+"."+base.Add(-time.Minute).Format(backupTimeFormat)+compressSuffix, true,
+		BlockEvent{Timestamp: base.Add(-2 * time.Minute), Event: EventBlocked, IP: "10.0.0.1"},
+		"not json")
+	writeEventLines(t, path+"."+base.Format(backupTimeFormat), false,
+		BlockEvent{Timestamp: base.Add(-30 * time.Second), Event: EventExpired, IP: "10.0.0.1"})
+	writeEventLines(t, path, false,
+		BlockEvent{Timestamp: base.Add(time.Minute), Event: EventBlocked, IP: "10.0.0.1"})
+
+	var ips []string
+	stats, err := ReadEvents(path, base.Add(-24*time.Hour), func(event BlockEvent) error {
+		ips = append(ips, event.Event+" "+event.IP)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadEvents failed: %v", err)
+	}
+
+	want := []string{"blocked 10.0.0.1", "expired 10.0.0.1", "blocked 10.0.0.1"}
+	if len(ips) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, ips)
+	}
+	for i := range want {
+		if ips[i] != want[i] {
+			t.Errorf("Event %d: expected %q, got %q", i, want[i], ips[i])
+		}
+	}
+	if stats.Files != 3 || stats.Events != 3 || stats.Skipped != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestReadEventsMissingLog(t *testing.T) {
+	stats, err := ReadEvents(filepath.Join(t.TempDir(), "missing.log"), time.Time{}, func(BlockEvent) error {
+		t.Fatal("Unexpected event")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error for missing log, got %v", err)
+	}
+	if stats.Files != 0 {
+		t.Errorf("Expected no files read, got %d", stats.Files)
+	}
+}
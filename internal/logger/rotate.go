@@ -0,0 +1,264 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp suffix of rotated log files
+const backupTimeFormat = "20060102-150405"
+
+// compressSuffix is appended to rotated files once they are gzipped
+const compressSuffix = ".gz"
+
+// rotatingFile is an append-only file that rotates itself when it grows past
+// maxSize. Rotated files are renamed to <path>.<timestamp>, optionally gzipped,
+// and pruned by count and age in the background.
+type rotatingFile struct {
+	path       string
+	maxSize    int64         // bytes, 0 disables size-based rotation
+	maxBackups int           // 0 keeps all backups
+	maxAge     time.Duration // 0 keeps backups forever
+	compress   bool
+
+	file *os.File
+	size int64
+
+	millMutex sync.Mutex
+	millWg    sync.WaitGroup
+}
+
+// openRotatingFile opens (or creates) the log file at path
+func openRotatingFile(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+		compress:   compress,
+	}
+	file, size, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	r.file, r.size = file, size
+	return r, nil
+}
+
+// open opens the log file for appending and returns it with its current size
+func (r *rotatingFile) open() (*os.File, int64, error) {
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// swap replaces the current file with a newly opened one
+func (r *rotatingFile) swap(file *os.File, size int64) error {
+	old := r.file
+	r.file, r.size = file, size
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("failed to close previous log file: %w", err)
+	}
+	return nil
+}
+
+// Write appends p, rotating first if p would push the file past maxSize. If
+// rotation fails p is still appended to the current file.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	var rotateErr error
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.Rotate(); err != nil {
+			rotateErr = fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// Rotate renames the current file to a timestamped backup and starts a new
+// one. On failure the current file is kept for writing.
+func (r *rotatingFile) Rotate() error {
+	backup := r.backupName(time.Now())
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+
+	file, size, err := r.open()
+	if err != nil {
+		// Keep writing to the current file under its old name
+		if renameErr := os.Rename(backup, r.path); renameErr != nil {
+			return fmt.Errorf("%w (log continues in %s)", err, backup)
+		}
+		return err
+	}
+	closeErr := r.swap(file, size)
+
+	r.millWg.Add(1)
+	go func() {
+		defer r.millWg.Done()
+		r.mill()
+	}()
+	return closeErr
+}
+
+// Reopen closes and reopens the file at the same path. External tools such as
+// logrotate move the file away and then signal us to start a fresh one. If
+// the file cannot be opened the previous one is kept for writing.
+func (r *rotatingFile) Reopen() error {
+	file, size, err := r.open()
+	if err != nil {
+		return err
+	}
+	return r.swap(file, size)
+}
+
+// Close closes the file and waits for background compression to finish
+func (r *rotatingFile) Close() error {
+	err := r.file.Close()
+	r.millWg.Wait()
+	return err
+}
+
+// backupName returns an unused backup file name for the given time
+func (r *rotatingFile) backupName(t time.Time) string {
+	name := fmt.Sprintf("%s.%s", r.path, t.Format(backupTimeFormat))
+	candidate := name
+	for i := 1; ; i++ {
+		if !fileExists(candidate) && !fileExists(candidate+compressSuffix) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s.%d", name, i)
+	}
+}
+
+// backupFile describes a rotated log file on disk
+type backupFile struct {
+	path string
+	time time.Time
+}
+
+// listBackups returns rotated files of path, newest first
+func listBackups(path string) ([]backupFile, error) {
+	dir := filepath.Dir(path)
+	prefix := filepath.Base(path) + "."
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), compressSuffix)
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, stamp[:len(backupTimeFormat)], time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, name), time: t})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].time.Equal(backups[j].time) {
+			return backups[i].path > backups[j].path
+		}
+		return backups[i].time.After(backups[j].time)
+	})
+	return backups, nil
+}
+
+// mill compresses and prunes rotated files. Errors are not fatal to logging,
+// a failed backup is simply retried on the next rotation.
+func (r *rotatingFile) mill() {
+	r.millMutex.Lock()
+	defer r.millMutex.Unlock()
+
+	backups, err := listBackups(r.path)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-r.maxAge)
+	var keep []backupFile
+	for i, b := range backups {
+		if (r.maxBackups > 0 && i >= r.maxBackups) || (r.maxAge > 0 && b.time.Before(cutoff)) {
+			os.Remove(b.path)
+			continue
+		}
+		keep = append(keep, b)
+	}
+
+	if !r.compress {
+		return
+	}
+	for _, b := range keep {
+		if strings.HasSuffix(b.path, compressSuffix) {
+			continue
+		}
+		compressFile(b.path, b.path+compressSuffix)
+	}
+}
+
+// compressFile gzips src into dst and removes src on success
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// fileExists reports whether a file exists at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,142 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateOnMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked.log")
+	r, err := openRotatingFile(path, 1, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := []byte(strings.Repeat("x", 600*1024) + "\n")
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write(line); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := listBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %d", len(backups))
+	}
+	if !strings.HasSuffix(backups[0].path, compressSuffix) {
+		t.Fatalf("Expected compressed backup, got %s", backups[0].path)
+	}
+
+	f, err := os.Open(backups[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(line) {
+		t.Errorf("Expected %d bytes in backup, got %d", len(line), len(data))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(line)) {
+		t.Errorf("Expected current file to hold one line, got %d bytes", info.Size())
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocked.log")
+
+	now := time.Now()
+	stamps := []time.Time{
+		now.Add(-1 * time.Hour),
+		now.Add(-2 * time.Hour),
+		now.Add(-3 * time.Hour),
+		now.Add(-40 * 24 * time.Hour),
+	}
+	for _, ts := range stamps {
+		name := path + "." + ts.Format(backupTimeFormat) + compressSuffix
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &rotatingFile{path: path, maxBackups: 2, maxAge: 30 * 24 * time.Hour}
+	r.mill()
+
+	backups, err := listBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups after pruning, got %d", len(backups))
+	}
+	if !backups[0].time.Equal(stamps[0].Truncate(time.Second)) {
+		t.Errorf("Expected newest backup to be kept, got %s", backups[0].time)
+	}
+}
+
+func TestReopenFailureKeepsWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocked.log")
+	r, err := openRotatingFile(path, 0, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// logrotate moved the file away, but a directory now blocks the path
+	moved := filepath.Join(dir, "moved.log")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reopen(); err == nil {
+		t.Fatal("Expected reopen to fail")
+	}
+	if _, err := r.Write([]byte("kept\n")); err != nil {
+		t.Fatalf("Expected writes to the previous file after a failed reopen, got %v", err)
+	}
+
+	// Once the path is free again the next reopen succeeds
+	os.Remove(path)
+	if err := r.Reopen(); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if _, err := r.Write([]byte("fresh\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{moved: "kept\n", path: "fresh\n"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), data, want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// SyslogSDID is the RFC 5424 structured data ID carrying event fields.
+// 32473 is the private enterprise number reserved for documentation.
+const SyslogSDID = "aria2bango@32473"
+
+// Syslog transports
+const (
+	SyslogUDP  = "udp"
+	SyslogTCP  = "tcp"
+	SyslogTLS  = "tls"
+	SyslogUnix = "unix" // local datagram socket such as /dev/log
+)
+
+// syslogFacilities maps facility names to RFC 5424 facility codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// maxReconnectDelay caps the exponential reconnect backoff
+const maxReconnectDelay = time.Minute
+
+// SyslogSink sends block events to a syslog collector as RFC 5424 messages.
+// Messages go through a bounded buffer and a background writer, so a slow or
+// unreachable collector never blocks the caller; when the buffer is full new
+// events are dropped.
+type SyslogSink struct {
+	cfg       config.SyslogConfig
+	facility  int
+	hostname  string
+	tlsConfig *tls.Config
+
+	buffer  chan []byte
+	done    chan struct{}
+	wg      sync.WaitGroup
+	dropped int64
+	mutex   sync.Mutex
+
+	conn net.Conn // owned by the writer goroutine
+}
+
+// NewSyslogSink creates a syslog sink and starts its writer. The collector
+// does not need to be reachable yet; the writer keeps reconnecting.
+func NewSyslogSink(cfg config.SyslogConfig) (*SyslogSink, error) {
+	facility, ok := syslogFacilities[strings.ToLower(cfg.Facility)]
+	if !ok {
+		if cfg.Facility != "" {
+			return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+		}
+		facility = syslogFacilities["daemon"]
+	}
+
+	switch cfg.Network {
+	case SyslogUDP, SyslogTCP, SyslogTLS, SyslogUnix:
+	default:
+		return nil, fmt.Errorf("unknown syslog network %q", cfg.Network)
+	}
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("missing syslog address")
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = SyslogIdentifier
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = time.Second
+	}
+
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	s := &SyslogSink{
+		cfg:      cfg,
+		facility: facility,
+		hostname: hostname,
+		buffer:   make(chan []byte, cfg.BufferSize),
+		done:     make(chan struct{}),
+	}
+
+	if cfg.Network == SyslogTLS {
+		tlsConfig, err := syslogTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig = tlsConfig
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// WriteEvent formats an event and queues it for sending
+func (s *SyslogSink) WriteEvent(event BlockEvent) error {
+	msg, err := s.format(event)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.buffer <- msg:
+		return nil
+	default:
+		s.mutex.Lock()
+		s.dropped++
+		dropped := s.dropped
+		s.mutex.Unlock()
+		return fmt.Errorf("syslog buffer full, dropped %s event for %s (%d dropped so far)", event.Event, event.IP, dropped)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (s *SyslogSink) Dropped() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dropped
+}
+
+// Close stops the writer after trying briefly to send buffered messages
+func (s *SyslogSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return nil
+}
+
+// run writes buffered messages, reconnecting with backoff on failure
+func (s *SyslogSink) run() {
+	defer s.wg.Done()
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+
+	delay := s.cfg.ReconnectDelay
+	for {
+		var msg []byte
+		select {
+		case msg = <-s.buffer:
+		case <-s.done:
+			s.flush()
+			return
+		}
+
+		// Retry the message until it is written or we shut down
+		for {
+			err := s.write(msg)
+			if err == nil {
+				delay = s.cfg.ReconnectDelay
+				break
+			}
+
+			select {
+			case <-s.done:
+				s.flush()
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+	}
+}
+
+// flush makes one last attempt to send buffered messages on shutdown
+func (s *SyslogSink) flush() {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case msg := <-s.buffer:
+			if err := s.write(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// write sends one message, connecting first if needed
+func (s *SyslogSink) write(msg []byte) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.conn.Write(s.frame(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects to the collector
+func (s *SyslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch s.cfg.Network {
+	case SyslogTLS:
+		return tls.DialWithDialer(dialer, "tcp", s.cfg.Address, s.tlsConfig)
+	case SyslogUnix:
+		return dialer.Dial("unixgram", s.cfg.Address)
+	default:
+		return dialer.Dial(s.cfg.Network, s.cfg.Address)
+	}
+}
+
+// frame applies octet-counting framing (RFC 6587/5425) on stream transports
+func (s *SyslogSink) frame(msg []byte) []byte {
+	if s.cfg.Network == SyslogTCP || s.cfg.Network == SyslogTLS {
+		return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	return msg
+}
+
+// format renders an event as an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID k="v" ...] MSG
+func (s *SyslogSink) format(event BlockEvent) ([]byte, error) {
+	fields, err := eventFields(event)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s ",
+		s.facility*8+eventSeverity(event.Event),
+		timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(s.hostname, 255),
+		headerField(s.cfg.AppName, 48),
+		os.Getpid(),
+		headerField(event.Event, 32),
+	)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b.WriteString("[" + SyslogSDID)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=\"%s\"", sdName(k), sdEscape(fields[k]))
+	}
+	b.WriteString("] ")
+	b.WriteString(eventMessage(event))
+
+	return []byte(b.String()), nil
+}
+
+// headerField returns a valid RFC 5424 header field: printable ASCII without
+// spaces, truncated to max, or "-" when empty
+func headerField(value string, max int) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+	}
+	result := b.String()
+	if len(result) > max {
+		result = result[:max]
+	}
+	if result == "" {
+		return "-"
+	}
+	return result
+}
+
+// sdName returns a valid SD-PARAM name (at most 32 chars, no '=', ' ', ']', '"')
+func sdName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r > 32 && r < 127 && r != '=' && r != ']' && r != '"' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	result := b.String()
+	if len(result) > 32 {
+		result = result[:32]
+	}
+	return result
+}
+
+// sdEscape escapes '"', '\' and ']' in SD-PARAM values
+func sdEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// syslogTLSConfig builds the TLS client config for the collector
+func syslogTLSConfig(cfg config.SyslogConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		if host, _, err := net.SplitHostPort(cfg.Address); err == nil {
+			tlsConfig.ServerName = host
+		}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read syslog CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load syslog client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,212 @@
+package logger
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+func testSyslogConfig(network, address string) config.SyslogConfig {
+	return config.SyslogConfig{
+		Network:        network,
+		Address:        address,
+		Facility:       "local0",
+		Hostname:       "seedbox",
+		BufferSize:     10,
+		ReconnectDelay: 10 * time.Millisecond,
+	}
+}
+
+func TestSyslogUDPFormat(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	sink, err := NewSyslogSink(testSyslogConfig(SyslogUDP, pc.LocalAddr().String()))
+	if err != nil {
+		t.Fatalf("NewSyslogSink failed: %v", err)
+	}
+	defer sink.Close()
+
+	err = sink.WriteEvent(BlockEvent{
+		Timestamp:  time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+		Event:      EventBlocked,
+		IP:         "192.168.1.100",
+		ClientName: `Weird "client"]`,
+		Reason:     "low_share_ratio",
+		Duration:   "15m0s",
+		ShareRatio: 0,
+		BanID:      "0123456789abcdef",
+	})
+	if err != nil {
+		t.Fatalf("WriteEvent failed: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read syslog message: %v", err)
+	}
+	msg := string(buf[:n])
+
+	// local0 (16) * 8 + notice (5) = 133
+	prefix := "<133>1 2024-01-15T10:30:00.000000Z seedbox aria2bango "
+	if !strings.HasPrefix(msg, prefix) {
+		t.Fatalf("Unexpected header: %q", msg)
+	}
+	for _, want := range []string{
+		" blocked [aria2bango@32473 ",
+		`ban_id="0123456789abcdef"`,
+		`client_name="Weird \"client\"\]"`,
+		`ip="192.168.1.100"`,
+		`reason="low_share_ratio"`,
+		`share_ratio="0"`,
+		`upload_speed="0"`,
+		"] blocked 192.168.1.100",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected message to contain %q, got %q", want, msg)
+		}
+	}
+	// Like the block log, fields the JSON omits are left out
+	if strings.Contains(msg, "peer_uploaded=") || strings.Contains(msg, "info_hash=") {
+		t.Errorf("Expected omitted fields to be left out, got %q", msg)
+	}
+}
+
+// readFramed reads one octet-counted message from a stream
+func readFramed(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatalf("Failed to read frame length: %v", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		t.Fatalf("Invalid frame length %q", length)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	return string(buf)
+}
+
+func TestSyslogTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	sink, err := NewSyslogSink(testSyslogConfig(SyslogTCP, ln.Addr().String()))
+	if err != nil {
+		t.Fatalf("NewSyslogSink failed: %v", err)
+	}
+	defer sink.Close()
+
+	sink.WriteEvent(BlockEvent{Event: EventBlocked, IP: "10.0.0.1"})
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if msg := readFramed(t, bufio.NewReader(conn)); !strings.Contains(msg, `ip="10.0.0.1"`) {
+		t.Errorf("Unexpected first message %q", msg)
+	}
+
+	// The collector drops the connection; later events must arrive on a new one
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	var conn2 net.Conn
+	for i := 2; conn2 == nil; i++ {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for reconnect")
+		}
+		sink.WriteEvent(BlockEvent{Event: EventExpired, IP: "10.0.0." + strconv.Itoa(i)})
+		select {
+		case conn2 = <-accepted:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	defer conn2.Close()
+
+	conn2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if msg := readFramed(t, bufio.NewReader(conn2)); !strings.Contains(msg, " expired [") {
+		t.Errorf("Unexpected message after reconnect %q", msg)
+	}
+}
+
+func TestSyslogNeverBlocks(t *testing.T) {
+	// Nothing listens here, every connection attempt fails
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	sink, err := NewSyslogSink(testSyslogConfig(SyslogTCP, addr))
+	if err != nil {
+		t.Fatalf("NewSyslogSink failed: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		sink.WriteEvent(BlockEvent{Event: EventBlocked, IP: "10.0.0.1"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WriteEvent blocked for %s", elapsed)
+	}
+	if sink.Dropped() == 0 {
+		t.Error("Expected events to be dropped once the buffer is full")
+	}
+
+	start = time.Now()
+	sink.Close()
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Close took %s", elapsed)
+	}
+}
+
+func TestSyslogInvalidConfig(t *testing.T) {
+	if _, err := NewSyslogSink(config.SyslogConfig{Network: "carrier-pigeon", Address: "x"}); err == nil {
+		t.Error("Expected error for unknown network")
+	}
+	if _, err := NewSyslogSink(config.SyslogConfig{Network: SyslogUDP, Address: "x", Facility: "nope"}); err == nil {
+		t.Error("Expected error for unknown facility")
+	}
+}
+
+func TestEventSeverity(t *testing.T) {
+	notice := map[string]bool{
+		EventBlocked: true, EventEscalated: true, EventWouldBlock: true,
+		EventThrottled: true, EventWouldThrottle: true,
+	}
+	for _, event := range EventTypes {
+		want := severityInfo
+		if notice[event] {
+			want = severityNotice
+		}
+		if got := eventSeverity(event); got != want {
+			t.Errorf("eventSeverity(%s) = %d, want %d", event, got, want)
+		}
+	}
+}
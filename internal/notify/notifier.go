@@ -0,0 +1,227 @@
+// Package notify delivers ban lifecycle events to HTTP webhooks
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// retryCheckInterval is how often a webhook checks its queue for due retries
+var retryCheckInterval = time.Second
+
+// saveDelay is how long changes to the queue are collected before the
+// queue file is rewritten
+var saveDelay = time.Second
+
+// Notifier batches block events and delivers them to webhooks. It implements
+// logger.Sink so it receives the same events as the block log.
+type Notifier struct {
+	webhooks map[string]*webhook
+	queue    *retryQueue
+	client   *http.Client
+	onError  func(error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a notifier and starts its delivery goroutines. Deliveries left
+// in the queue file by a previous run are retried. onError is called for
+// delivery failures and may be nil.
+func New(cfg *config.NotificationsConfig, onError func(error)) (*Notifier, error) {
+	if onError == nil {
+		onError = func(error) {}
+	}
+
+	n := &Notifier{
+		webhooks: make(map[string]*webhook, len(cfg.Webhooks)),
+		client:   &http.Client{},
+		onError:  onError,
+	}
+
+	for _, hookCfg := range cfg.Webhooks {
+		w, err := newWebhook(hookCfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := n.webhooks[w.cfg.Name]; dup {
+			return nil, fmt.Errorf("duplicate webhook name %q", w.cfg.Name)
+		}
+		n.webhooks[w.cfg.Name] = w
+	}
+
+	queue, err := loadQueue(cfg.QueueFile)
+	if err != nil {
+		return nil, err
+	}
+	n.queue = queue
+
+	for _, d := range queue.drop(func(name string) bool { return n.webhooks[name] != nil }) {
+		onError(fmt.Errorf("dropping %d queued events for removed webhook %s", len(d.Events), d.Webhook))
+	}
+
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	for _, w := range n.webhooks {
+		n.wg.Add(1)
+		go n.run(w)
+	}
+	n.wg.Add(1)
+	go n.save()
+
+	return n, nil
+}
+
+// WriteEvent queues an event for every webhook subscribed to its type.
+// The queue file is written in the background, at the latest before the
+// event is first sent. It never blocks on delivery or disk.
+func (n *Notifier) WriteEvent(event logger.BlockEvent) error {
+	for _, w := range n.webhooks {
+		if !w.wants(event.Event) {
+			continue
+		}
+		count := n.queue.add(w.cfg.Name, event, w.cfg.BatchSize)
+		// Wake up the webhook; if it is behind, it finds the event anyway
+		select {
+		case w.incoming <- count:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of deliveries not yet delivered
+func (n *Notifier) Pending() int {
+	return n.queue.len()
+}
+
+// Close stops delivery. Events not yet delivered are kept in the queue
+// file and sent after the next start.
+func (n *Notifier) Close() error {
+	n.cancel()
+	n.wg.Wait()
+	return n.queue.save()
+}
+
+// run delivers the events of one webhook in order: a batch is sealed once
+// it is full or its interval has passed, and a failed delivery holds back
+// the ones after it until its retry succeeds or is given up
+func (n *Notifier) run(w *webhook) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(retryCheckInterval)
+	defer ticker.Stop()
+
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timerC = nil, nil
+		}
+	}
+	defer stopTimer()
+
+	for {
+		n.sendDue(w)
+
+		select {
+		case <-n.ctx.Done():
+			// Unsent events are in the queue already
+			return
+
+		case count := <-w.incoming:
+			if count >= w.cfg.BatchSize {
+				stopTimer()
+				n.queue.seal(w.cfg.Name)
+			} else if timer == nil {
+				timer = time.NewTimer(w.cfg.BatchInterval)
+				timerC = timer.C
+			}
+
+		case <-timerC:
+			timer, timerC = nil, nil
+			n.queue.seal(w.cfg.Name)
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendDue sends the deliveries of a webhook that are due, oldest first
+func (n *Notifier) sendDue(w *webhook) {
+	for d := n.queue.next(w.cfg.Name, time.Now()); d != nil; d = n.queue.next(w.cfg.Name, time.Now()) {
+		if n.ctx.Err() != nil {
+			// Shutting down, the rest stays queued for the next run
+			return
+		}
+		if d.Attempts == 0 {
+			// Make sure the batch is on disk before it is first sent
+			if err := n.queue.save(); err != nil {
+				n.onError(err)
+			}
+		}
+		n.deliver(w, d)
+	}
+}
+
+// save writes the queue file shortly after it changes
+func (n *Notifier) save() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			// Close saves what is left
+			return
+		case <-n.queue.changed:
+		}
+
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-time.After(saveDelay):
+		}
+		if err := n.queue.save(); err != nil {
+			n.onError(err)
+		}
+	}
+}
+
+// deliver sends a delivery and keeps it queued for retry on failure
+func (n *Notifier) deliver(w *webhook, d *delivery) {
+	err := w.send(n.ctx, n.client, d.Events)
+	if err == nil {
+		n.queue.remove(d)
+		return
+	}
+
+	attempts, next := d.Attempts+1, time.Now()
+	switch {
+	case errors.Is(err, errRender):
+		// Retrying cannot fix the template
+		n.onError(fmt.Errorf("webhook %s: dropping %d events: %w", w.cfg.Name, len(d.Events), err))
+		n.queue.remove(d)
+		return
+	case n.ctx.Err() != nil:
+		// Interrupted by shutdown, this attempt does not count
+		attempts--
+	case attempts >= w.cfg.MaxRetries:
+		n.onError(fmt.Errorf("webhook %s: giving up on %d events after %d attempts: %w", w.cfg.Name, len(d.Events), attempts, err))
+		n.queue.remove(d)
+		return
+	default:
+		backoff := w.cfg.RetryBackoff << (attempts - 1)
+		next = next.Add(backoff)
+		n.onError(fmt.Errorf("webhook %s: delivery failed, retrying in %s: %w", w.cfg.Name, backoff, err))
+	}
+
+	n.queue.retry(d, attempts, next, err)
+}
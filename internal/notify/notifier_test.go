@@ -0,0 +1,377 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// recorder is a webhook endpoint that records requests
+type recorder struct {
+	mutex    sync.Mutex
+	requests []*recordedRequest
+	fail     int // number of requests to fail before succeeding
+}
+
+type recordedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if rec.fail > 0 {
+		rec.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rec.requests = append(rec.requests, &recordedRequest{header: r.Header.Clone(), body: body})
+}
+
+func (rec *recorder) get() []*recordedRequest {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return append([]*recordedRequest(nil), rec.requests...)
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func init() {
+	retryCheckInterval = 10 * time.Millisecond
+	saveDelay = 10 * time.Millisecond
+}
+
+func TestBatchingAndSignature(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n, err := New(&config.NotificationsConfig{
+		Webhooks: []config.WebhookConfig{{
+			Name:          "test",
+			URL:           srv.URL,
+			Secret:        "s3cret",
+			Events:        []string{logger.EventBlocked},
+			BatchSize:     10,
+			BatchInterval: 50 * time.Millisecond,
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1"})
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventExpired, IP: "10.0.0.1"})
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.2"})
+
+	waitFor(t, "batch delivery", func() bool { return len(rec.get()) == 1 })
+
+	req := rec.get()[0]
+	var payload struct {
+		Count  int                 `json:"count"`
+		Events []logger.BlockEvent `json:"events"`
+	}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("Invalid JSON body: %v", err)
+	}
+	if payload.Count != 2 || len(payload.Events) != 2 {
+		t.Fatalf("Expected 2 filtered events in one batch, got %d", payload.Count)
+	}
+	if payload.Events[1].IP != "10.0.0.2" {
+		t.Errorf("Unexpected event order: %+v", payload.Events)
+	}
+
+	timestamp := req.header.Get(HeaderTimestamp)
+	if got, want := req.header.Get(HeaderSignature), Sign("s3cret", timestamp, req.body); got != want {
+		t.Errorf("Signature mismatch: got %s, want %s", got, want)
+	}
+}
+
+func TestTextTemplate(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n, err := New(&config.NotificationsConfig{
+		Webhooks: []config.WebhookConfig{{
+			Name:     "chat",
+			URL:      srv.URL,
+			Format:   FormatText,
+			Template: `{{range .Events}}{{.Event}} {{.IP}} ({{.Reason}}){{"\n"}}{{end}}`,
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1", Reason: "low_share_ratio"})
+	waitFor(t, "delivery", func() bool { return len(rec.get()) == 1 })
+
+	req := rec.get()[0]
+	if got := string(req.body); got != "blocked 10.0.0.1 (low_share_ratio)\n" {
+		t.Errorf("Unexpected body %q", got)
+	}
+	if ct := req.header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected text/plain, got %s", ct)
+	}
+}
+
+func TestRetryAfterFailure(t *testing.T) {
+	rec := &recorder{fail: 2}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n, err := New(&config.NotificationsConfig{
+		Webhooks: []config.WebhookConfig{{
+			Name:         "flaky",
+			URL:          srv.URL,
+			RetryBackoff: 10 * time.Millisecond,
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1"})
+	waitFor(t, "delivery after retries", func() bool { return len(rec.get()) == 1 })
+	waitFor(t, "empty retry queue", func() bool { return n.Pending() == 0 })
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+	rec := &recorder{fail: 1000}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	cfg := &config.NotificationsConfig{
+		QueueFile: queueFile,
+		Webhooks: []config.WebhookConfig{{
+			Name:         "down",
+			URL:          srv.URL,
+			RetryBackoff: time.Hour,
+		}},
+	}
+
+	n, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1"})
+	waitFor(t, "failed delivery to be queued", func() bool { return attempts(n.queue) == 1 })
+	n.Close()
+
+	// The endpoint recovers and the queued delivery is due
+	rec.mutex.Lock()
+	rec.fail = 0
+	rec.mutex.Unlock()
+	q, err := loadQueue(queueFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.items) != 1 || q.items[0].Attempts != 1 {
+		t.Fatalf("Expected 1 persisted delivery with 1 attempt, got %+v", q.items)
+	}
+	q.items[0].NextTry = time.Now()
+	q.dirty = true
+	if err := q.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = New(cfg, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	waitFor(t, "delivery after restart", func() bool { return len(rec.get()) == 1 })
+	if !strings.Contains(string(rec.get()[0].body), "10.0.0.1") {
+		t.Errorf("Unexpected body %s", rec.get()[0].body)
+	}
+}
+
+// attempts returns the attempts of the first queued delivery
+func attempts(q *retryQueue) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.items) == 0 {
+		return 0
+	}
+	return q.items[0].Attempts
+}
+
+func TestQueueSurvivesCrash(t *testing.T) {
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	cfg := &config.NotificationsConfig{
+		QueueFile: queueFile,
+		Webhooks: []config.WebhookConfig{{
+			Name:          "slow",
+			URL:           srv.URL,
+			BatchInterval: time.Hour,
+		}},
+	}
+	n, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	// Saved in the background, without Close
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1"})
+	waitFor(t, "the event in the queue file", func() bool {
+		q, err := loadQueue(queueFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(q.items) == 1 && len(q.items[0].Events) == 1 && q.items[0].Attempts == 0
+	})
+
+	// A new process sends what the crashed one left behind
+	restarted, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer restarted.Close()
+	waitFor(t, "delivery after crash", func() bool { return len(rec.get()) == 1 })
+	waitFor(t, "queue to be emptied", func() bool { return restarted.Pending() == 0 })
+}
+
+func TestRetryKeepsOrder(t *testing.T) {
+	rec := &recorder{fail: 1}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n, err := New(&config.NotificationsConfig{
+		Webhooks: []config.WebhookConfig{{
+			Name:         "ordered",
+			URL:          srv.URL,
+			BatchSize:    1,
+			RetryBackoff: 50 * time.Millisecond,
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	for _, ip := range ips {
+		n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: ip})
+	}
+	waitFor(t, "3 deliveries", func() bool { return len(rec.get()) == 3 })
+
+	// The first delivery failed once; the others waited for its retry
+	for i, req := range rec.get() {
+		if !strings.Contains(string(req.body), ips[i]) {
+			t.Errorf("Expected delivery %d to carry %s, got %s", i, ips[i], req.body)
+		}
+	}
+}
+
+func TestTemplateErrorNotRetried(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	var mutex sync.Mutex
+	var errs []error
+	n, err := New(&config.NotificationsConfig{
+		Webhooks: []config.WebhookConfig{{
+			Name:         "broken",
+			URL:          srv.URL,
+			Template:     "{{index .Events 5}}",
+			RetryBackoff: 10 * time.Millisecond,
+		}},
+	}, func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		errs = append(errs, err)
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1"})
+	waitFor(t, "event to be dropped", func() bool { return n.Pending() == 0 })
+	time.Sleep(50 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(errs) != 1 || !errors.Is(errs[0], errRender) {
+		t.Errorf("Expected a single render error, got %v", errs)
+	}
+	if len(rec.get()) != 0 {
+		t.Errorf("Expected no requests, got %d", len(rec.get()))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n, err := New(&config.NotificationsConfig{
+		Webhooks: []config.WebhookConfig{{
+			Name:        "limited",
+			URL:         srv.URL,
+			BatchSize:   1,
+			MinInterval: 100 * time.Millisecond,
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer n.Close()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		n.WriteEvent(logger.BlockEvent{Event: logger.EventBlocked, IP: "10.0.0.1"})
+	}
+	waitFor(t, "3 deliveries", func() bool { return len(rec.get()) == 3 })
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected rate limiting to space requests, all sent within %s", elapsed)
+	}
+}
+
+func TestInvalidWebhook(t *testing.T) {
+	tests := []config.WebhookConfig{
+		{Name: "no-url"},
+		{Name: "bad-event", URL: "http://localhost", Events: []string{"nope"}},
+		{Name: "bad-template", URL: "http://localhost", Template: "{{.Missing"},
+	}
+	for _, hook := range tests {
+		if _, err := New(&config.NotificationsConfig{Webhooks: []config.WebhookConfig{hook}}, nil); err == nil {
+			t.Errorf("Expected error for webhook %s", hook.Name)
+		}
+	}
+}
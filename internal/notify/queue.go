@@ -0,0 +1,226 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// delivery is a batch of events waiting to be (re)sent to a webhook
+type delivery struct {
+	Webhook   string              `json:"webhook"`
+	Events    []logger.BlockEvent `json:"events"`
+	Attempts  int                 `json:"attempts"`
+	NextTry   time.Time           `json:"next_try"`
+	LastError string              `json:"last_error,omitempty"`
+
+	open bool // 仍在收集事件，尚未发送
+}
+
+// retryQueue holds every delivery that has not succeeded yet, from the
+// moment its first event arrives. Changes only touch memory; save writes
+// a snapshot to disk and is called off the event path, so notifications
+// survive a restart or crash without slowing down logging.
+type retryQueue struct {
+	path  string // empty keeps the queue in memory only
+	items []*delivery
+	open  map[string]*delivery // 每个 webhook 正在收集的批次
+	mutex sync.Mutex
+
+	dirty     bool          // 有未保存的修改
+	changed   chan struct{} // 修改后通知保存
+	saveMutex sync.Mutex    // 串行化写文件
+}
+
+// loadQueue loads the queue file at path, starting empty if it does not exist
+func loadQueue(path string) (*retryQueue, error) {
+	q := &retryQueue{
+		path:    path,
+		open:    make(map[string]*delivery),
+		changed: make(chan struct{}, 1),
+	}
+	if path == "" {
+		return q, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification queue: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &q.items); err != nil {
+			return nil, fmt.Errorf("failed to parse notification queue: %w", err)
+		}
+	}
+	return q, nil
+}
+
+// add appends an event to the open batch of a webhook, starting a new one
+// if there is none or it is full, and returns the size of the batch
+func (q *retryQueue) add(webhook string, event logger.BlockEvent, batchSize int) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	batch := q.open[webhook]
+	if batch != nil && len(batch.Events) >= batchSize {
+		// Full, ready to be sent
+		batch.open = false
+		batch = nil
+	}
+	if batch == nil {
+		batch = &delivery{Webhook: webhook, NextTry: time.Now(), open: true}
+		q.items = append(q.items, batch)
+		q.open[webhook] = batch
+	}
+	batch.Events = append(batch.Events, event)
+	q.changedLocked()
+	return len(batch.Events)
+}
+
+// seal closes the open batch of a webhook so it can be sent
+func (q *retryQueue) seal(webhook string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if batch := q.open[webhook]; batch != nil {
+		batch.open = false
+		delete(q.open, webhook)
+	}
+}
+
+// next returns the oldest sealed delivery of a webhook if it is due, nil
+// otherwise. Newer deliveries wait behind one that is backing off, so a
+// webhook receives its events in order.
+func (q *retryQueue) next(webhook string, now time.Time) *delivery {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, d := range q.items {
+		if d.Webhook != webhook || d.open {
+			continue
+		}
+		if d.NextTry.After(now) {
+			return nil
+		}
+		return d
+	}
+	return nil
+}
+
+// drop removes the deliveries of webhooks not in keep and returns them
+func (q *retryQueue) drop(keep func(webhook string) bool) []*delivery {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var dropped []*delivery
+	items := q.items[:0]
+	for _, d := range q.items {
+		if keep(d.Webhook) {
+			items = append(items, d)
+		} else {
+			dropped = append(dropped, d)
+		}
+	}
+	q.items = items
+	if len(dropped) > 0 {
+		q.changedLocked()
+	}
+	return dropped
+}
+
+// retry records a failed attempt of a delivery and schedules the next one
+func (q *retryQueue) retry(d *delivery, attempts int, next time.Time, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	d.Attempts, d.NextTry, d.LastError = attempts, next, err.Error()
+	q.changedLocked()
+}
+
+// remove drops a delivery that succeeded or was given up
+func (q *retryQueue) remove(d *delivery) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, item := range q.items {
+		if item == d {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	q.changedLocked()
+}
+
+// len returns the number of queued deliveries
+func (q *retryQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items)
+}
+
+// changedLocked marks the queue as needing a save. Caller must hold the mutex.
+func (q *retryQueue) changedLocked() {
+	q.dirty = true
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the queue atomically if it changed since the last save.
+// Only the snapshot is taken under the mutex; writing the file does not
+// block add.
+func (q *retryQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+
+	q.saveMutex.Lock()
+	defer q.saveMutex.Unlock()
+
+	q.mutex.Lock()
+	if !q.dirty {
+		q.mutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(q.items)
+	q.dirty = err != nil
+	q.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification queue: %w", err)
+	}
+
+	if err := q.write(data); err != nil {
+		// Try again on the next save
+		q.mutex.Lock()
+		q.dirty = true
+		q.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// write replaces the queue file with data
+func (q *retryQueue) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write notification queue: %w", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("failed to write notification queue: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/logger"
+)
+
+// Signature headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" using the webhook secret, prefixed with "sha256=".
+const (
+	HeaderTimestamp = "X-Aria2bango-Timestamp"
+	HeaderSignature = "X-Aria2bango-Signature"
+)
+
+// Payload formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Defaults for unset webhook settings
+const (
+	defaultBatchSize    = 20
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRetries   = 5
+	defaultRetryBackoff = 30 * time.Second
+	incomingBuffer      = 1024
+)
+
+// errRender is returned when a template fails to render, which retrying
+// cannot fix
+var errRender = errors.New("failed to render template")
+
+// templateData is passed to webhook templates
+type templateData struct {
+	Webhook string
+	Events  []logger.BlockEvent
+	Event   logger.BlockEvent // first event of the batch
+	Count   int
+}
+
+// templateFuncs are available in webhook templates
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+}
+
+// webhook is a configured delivery target
+type webhook struct {
+	cfg         config.WebhookConfig
+	events      map[string]bool // nil means all events
+	tmpl        *template.Template
+	contentType string
+	incoming    chan int // 有新事件时的批次大小
+
+	// rate limiting
+	limitMutex sync.Mutex
+	lastSent   time.Time
+}
+
+// newWebhook validates a webhook config and applies defaults
+func newWebhook(cfg config.WebhookConfig) (*webhook, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("webhook without name")
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook %s: missing url", cfg.Name)
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+
+	w := &webhook{
+		cfg:      cfg,
+		incoming: make(chan int, incomingBuffer),
+	}
+
+	if len(cfg.Events) > 0 {
+		w.events = make(map[string]bool, len(cfg.Events))
+		for _, e := range cfg.Events {
+			if !knownEvent(e) {
+				return nil, fmt.Errorf("webhook %s: unknown event type %q", cfg.Name, e)
+			}
+			w.events[e] = true
+		}
+	}
+
+	switch cfg.Format {
+	case "", FormatJSON:
+		w.contentType = "application/json"
+	case FormatText:
+		w.contentType = "text/plain; charset=utf-8"
+	default:
+		return nil, fmt.Errorf("webhook %s: unknown format %q", cfg.Name, cfg.Format)
+	}
+	if cfg.ContentType != "" {
+		w.contentType = cfg.ContentType
+	}
+
+	if cfg.Template != "" {
+		tmpl, err := template.New(cfg.Name).Funcs(templateFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid template: %w", cfg.Name, err)
+		}
+		w.tmpl = tmpl
+	}
+
+	return w, nil
+}
+
+// wants reports whether the webhook subscribes to an event type
+func (w *webhook) wants(eventType string) bool {
+	return w.events == nil || w.events[eventType]
+}
+
+// render builds the request body for a batch of events
+func (w *webhook) render(events []logger.BlockEvent) ([]byte, error) {
+	data := templateData{
+		Webhook: w.cfg.Name,
+		Events:  events,
+		Count:   len(events),
+	}
+	if len(events) > 0 {
+		data.Event = events[0]
+	}
+
+	if w.tmpl == nil {
+		return json.Marshal(map[string]interface{}{
+			"webhook": data.Webhook,
+			"count":   data.Count,
+			"events":  data.Events,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := w.tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("%w: %w", errRender, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// wait blocks until the rate limit allows the next request
+func (w *webhook) wait(ctx context.Context) error {
+	w.limitMutex.Lock()
+	defer w.limitMutex.Unlock()
+
+	if w.cfg.MinInterval > 0 && !w.lastSent.IsZero() {
+		if delay := time.Until(w.lastSent.Add(w.cfg.MinInterval)); delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	w.lastSent = time.Now()
+	return nil
+}
+
+// send delivers a batch of events in a single request
+func (w *webhook) send(ctx context.Context, client *http.Client, events []logger.BlockEvent) error {
+	body, err := w.render(events)
+	if err != nil {
+		return err
+	}
+
+	if err := w.wait(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, w.cfg.Method, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", w.contentType)
+	req.Header.Set("User-Agent", "aria2bango")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.cfg.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, Sign(w.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a request body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// knownEvent reports whether eventType is a block log event type
+func knownEvent(eventType string) bool {
+	for _, e := range logger.EventTypes {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package peerid
+
+import (
+	"math"
+	"strings"
+)
+
+// Anomalies found in peer IDs
+const (
+	AnomalyAllZero        = "all_zero"        // 全零peer ID
+	AnomalyBadLength      = "bad_length"      // 长度不是20字节
+	AnomalyBadVersion     = "bad_version"     // Azureus版本字段含非字母数字字符
+	AnomalyBadCharset     = "bad_charset"     // 随机部分不符合所声称客户端的字符集
+	AnomalyConstantSuffix = "constant_suffix" // 随机部分几乎是常量
+	AnomalyUnknownClient  = "unknown_client"  // 无法识别的客户端
+)
+
+// anomalyWeights is what each anomaly adds to the score
+var anomalyWeights = map[string]float64{
+	AnomalyAllZero:        1.0,
+	AnomalyBadLength:      0.5,
+	AnomalyBadVersion:     0.3,
+	AnomalyBadCharset:     0.6,
+	AnomalyConstantSuffix: 0.4,
+	AnomalyUnknownClient:  0.1,
+}
+
+const (
+	// peerIDLength is the length of every valid peer ID
+	peerIDLength = 20
+
+	// libtorrentCharset is the alphabet libtorrent draws the random part
+	// of its peer IDs from (url_random)
+	libtorrentCharset = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_.!~*()"
+
+	// transmissionCharset is the alphabet of Transmission's random part
+	transmissionCharset = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+// suffixCharsets restricts the random part of Azureus-style clients known
+// to generate it from a fixed alphabet. Other clients use arbitrary bytes.
+var suffixCharsets = map[string]string{
+	"qB": libtorrentCharset,
+	"DE": libtorrentCharset,
+	"LT": libtorrentCharset,
+	"TR": transmissionCharset,
+}
+
+// Report is the result of checking the structure of a peer ID
+type Report struct {
+	Info      ClientInfo
+	Anomalies []string
+	Score     float64 // 0-1，越高越可疑
+}
+
+// Check validates a peer ID against the structure of the client it claims
+// to be. Empty peer IDs, which aria2 reports before the handshake, are not
+// checked.
+func Check(peerID string) Report {
+	raw := Decode(peerID)
+	report := Report{Info: Parse(raw)}
+	if raw == "" {
+		return report
+	}
+
+	if strings.Trim(raw, "\x00") == "" {
+		report.add(AnomalyAllZero)
+		return report
+	}
+	if len(raw) != peerIDLength {
+		report.add(AnomalyBadLength)
+	}
+
+	info := report.Info
+	switch {
+	case info.Style == StyleUnknown:
+		report.add(AnomalyUnknownClient)
+	case info.Style == StyleAzureus:
+		code := raw[1:3]
+		if info.Name == "Unknown" {
+			report.add(AnomalyUnknownClient)
+		}
+		for i := 3; i < 7; i++ {
+			if !isAlnum(raw[i]) {
+				report.add(AnomalyBadVersion)
+				break
+			}
+		}
+		if charset, ok := suffixCharsets[code]; ok && !onlyChars(raw[8:], charset) {
+			report.add(AnomalyBadCharset)
+		}
+	}
+
+	// The rest of the ID is random for every client that has one
+	if suffix := raw[len(info.Prefix):]; len(suffix) >= 6 && distinctBytes(suffix) <= 2 {
+		report.add(AnomalyConstantSuffix)
+	}
+	return report
+}
+
+// add records an anomaly, capping the score at 1
+func (r *Report) add(anomaly string) {
+	r.Anomalies = append(r.Anomalies, anomaly)
+	r.Score = math.Min(1, math.Round((r.Score+anomalyWeights[anomaly])*100)/100)
+}
+
+// onlyChars reports whether every byte of s is in charset
+func onlyChars(s, charset string) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(charset, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// distinctBytes counts the different bytes in s
+func distinctBytes(s string) int {
+	var seen [256]bool
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if !seen[s[i]] {
+			seen[s[i]] = true
+			n++
+		}
+	}
+	return n
+}
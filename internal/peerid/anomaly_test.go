@@ -0,0 +1,39 @@
+package peerid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		peerID    string
+		anomalies string
+		score     float64
+	}{
+		{"qBittorrent", "-qB4250-a1B2.c3~D4(e", "", 0},
+		{"Transmission", "-TR2940-k3j5h2l8g9q1", "", 0},
+		{"uTorrent binary suffix", "-UT355B-\x8a\x01\xff\x10abcdefgh", "", 0},
+		{"URL-encoded", "%2DqB4250%2Da1B2.c3~D4(e", "", 0},
+		{"empty", "", "", 0},
+		{"all zero", strings.Repeat("\x00", 20), "all_zero", 1},
+		{"short", "-qB4250-abc", "bad_length", 0.5},
+		{"qBittorrent with binary suffix", "-qB4250-\x8a\x01\xff\x10abcdefgh", "bad_charset", 0.6},
+		{"Transmission with uppercase", "-TR2940-ABCDEFGHIJKL", "bad_charset", 0.6},
+		{"constant suffix", "-qB4250-000000000000", "constant_suffix", 0.4},
+		{"bad version", "-XL0.19-abcdefghijkl", "bad_version", 0.3},
+		{"unknown Azureus code", "-Z91234-abcdefghijkl", "unknown_client", 0.1},
+		{"unknown style", "garbage-peer-id-0001", "unknown_client", 0.1},
+		{"spoofed and constant", "-TR2940-XXXXXXXXXXXX", "bad_charset,constant_suffix", 1},
+	}
+	for _, tt := range tests {
+		report := Check(tt.peerID)
+		if got := strings.Join(report.Anomalies, ","); got != tt.anomalies {
+			t.Errorf("%s: anomalies = %q, want %q", tt.name, got, tt.anomalies)
+		}
+		if report.Score != tt.score {
+			t.Errorf("%s: score = %v, want %v", tt.name, report.Score, tt.score)
+		}
+	}
+}
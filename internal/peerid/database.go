@@ -0,0 +1,211 @@
+package peerid
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Client tags
+const (
+	TagOfflineDownload = "offline_download" // 离线下载/网盘客户端，通常只下载不上传
+	TagKnownLeecher    = "known_leecher"
+)
+
+// builtinTags tags built-in Azureus-style clients
+var builtinTags = map[string][]string{
+	"XL": {TagOfflineDownload},
+	"SD": {TagOfflineDownload},
+	"XY": {TagOfflineDownload},
+	"XZ": {TagOfflineDownload},
+	"BN": {TagOfflineDownload},
+	"QD": {TagOfflineDownload},
+}
+
+// versionDecoders are the decoders for the version field of Azureus-style
+// peer IDs, see decodeAzureusVersion
+var versionDecoders = map[string]bool{
+	"digits":       true,
+	"major_minor":  true,
+	"transmission": true,
+	"utorrent":     true,
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Client is an entry of a client database file
+type Client struct {
+	Style   Style    `yaml:"style"`  // azureus, shadow 或 fixed
+	Code    string   `yaml:"code"`   // azureus为两个字符，shadow为一个字符
+	Prefix  string   `yaml:"prefix"` // fixed: 原始peer ID前缀
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version"` // azureus版本解码器：digits, major_minor, transmission 或 utorrent
+	Tags    []string `yaml:"tags"`
+}
+
+// databaseFile is the layout of a client database file
+type databaseFile struct {
+	Clients []Client `yaml:"clients"`
+}
+
+// Database is an external client database. Its entries add to or
+// override the built-in tables.
+type Database struct {
+	azureus map[string]Client
+	shadow  map[string]Client
+	fixed   []Client // 按前缀长度降序
+}
+
+// current is the database used by Parse, nil for built-ins only
+var current atomic.Pointer[Database]
+
+// LoadDatabase loads a client database from a YAML or JSON file
+func LoadDatabase(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client database: %w", err)
+	}
+
+	var file databaseFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse client database %s: %w", path, err)
+	}
+
+	db, err := NewDatabase(file.Clients)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// NewDatabase validates client entries and builds a database
+func NewDatabase(clients []Client) (*Database, error) {
+	db := &Database{
+		azureus: make(map[string]Client),
+		shadow:  make(map[string]Client),
+	}
+	prefixes := make(map[string]bool)
+
+	for i, c := range clients {
+		if c.Name == "" {
+			return nil, fmt.Errorf("client %d: missing name", i+1)
+		}
+		for _, tag := range c.Tags {
+			if !tagPattern.MatchString(tag) {
+				return nil, fmt.Errorf("client %s: invalid tag %q (want lowercase letters, digits and _)", c.Name, tag)
+			}
+		}
+		if c.Version != "" && c.Style != StyleAzureus {
+			return nil, fmt.Errorf("client %s: version decoders only apply to azureus style", c.Name)
+		}
+
+		switch c.Style {
+		case StyleAzureus:
+			if len(c.Code) != 2 || !isAlnum(c.Code[0]) || !isAlnum(c.Code[1]) {
+				return nil, fmt.Errorf("client %s: azureus code must be two letters or digits, got %q", c.Name, c.Code)
+			}
+			if c.Version != "" && !versionDecoders[c.Version] {
+				return nil, fmt.Errorf("client %s: unknown version decoder %q", c.Name, c.Version)
+			}
+			if _, ok := db.azureus[c.Code]; ok {
+				return nil, fmt.Errorf("client %s: duplicate azureus code %q", c.Name, c.Code)
+			}
+			db.azureus[c.Code] = c
+
+		case StyleShadow:
+			if len(c.Code) != 1 || !isAlnum(c.Code[0]) {
+				return nil, fmt.Errorf("client %s: shadow code must be one letter or digit, got %q", c.Name, c.Code)
+			}
+			if _, ok := db.shadow[c.Code]; ok {
+				return nil, fmt.Errorf("client %s: duplicate shadow code %q", c.Name, c.Code)
+			}
+			db.shadow[c.Code] = c
+
+		case StyleFixed:
+			if c.Prefix == "" {
+				return nil, fmt.Errorf("client %s: fixed style needs prefix", c.Name)
+			}
+			if prefixes[c.Prefix] {
+				return nil, fmt.Errorf("client %s: duplicate prefix %q", c.Name, c.Prefix)
+			}
+			prefixes[c.Prefix] = true
+			db.fixed = append(db.fixed, c)
+
+		default:
+			return nil, fmt.Errorf("client %s: unknown style %q (want azureus, shadow or fixed)", c.Name, c.Style)
+		}
+	}
+
+	// The most specific prefix wins
+	sort.SliceStable(db.fixed, func(i, j int) bool {
+		return len(db.fixed[i].Prefix) > len(db.fixed[j].Prefix)
+	})
+	return db, nil
+}
+
+// Len returns the number of entries
+func (db *Database) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.azureus) + len(db.shadow) + len(db.fixed)
+}
+
+// SetDatabase makes Parse consult db before the built-in tables. A nil
+// database restores the built-ins. Safe to call while parsing.
+func SetDatabase(db *Database) {
+	current.Store(db)
+}
+
+// lookupAzureus returns the client with an Azureus-style code
+func lookupAzureus(code string) (Client, bool) {
+	if db := current.Load(); db != nil {
+		if c, ok := db.azureus[code]; ok {
+			return c, true
+		}
+	}
+	name, ok := azureusClients[code]
+	if !ok {
+		return Client{}, false
+	}
+	return Client{Style: StyleAzureus, Code: code, Name: name, Version: azureusVersions[code], Tags: builtinTags[code]}, true
+}
+
+// lookupShadow returns the client with a Shadow-style code
+func lookupShadow(code string) (Client, bool) {
+	if db := current.Load(); db != nil {
+		if c, ok := db.shadow[code]; ok {
+			return c, true
+		}
+	}
+	name, ok := shadowClients[code]
+	if !ok {
+		return Client{}, false
+	}
+	return Client{Style: StyleShadow, Code: code, Name: name}, true
+}
+
+// parseDatabase recognises the fixed prefixes of the external database,
+// before any built-in style
+func parseDatabase(peerID string) (ClientInfo, bool) {
+	db := current.Load()
+	if db == nil {
+		return ClientInfo{}, false
+	}
+	for _, c := range db.fixed {
+		if strings.HasPrefix(peerID, c.Prefix) {
+			return ClientInfo{Name: c.Name, Style: StyleFixed, Prefix: c.Prefix, Tags: c.Tags}, true
+		}
+	}
+	return ClientInfo{}, false
+}
@@ -0,0 +1,121 @@
+package peerid
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	data := `clients:
+  - style: azureus
+    code: XL
+    name: Thunder
+    version: major_minor
+    tags: [offline_download, known_leecher]
+  - style: azureus
+    code: Q9
+    name: NewLeecher
+    tags: [known_leecher]
+  - style: shadow
+    code: T
+    name: BitTornado Mod
+  - style: fixed
+    prefix: "-XL00"
+    name: Thunder Old
+  - style: fixed
+    prefix: "-XL0019-"
+    name: Thunder Cloud
+    tags: [offline_download]
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := LoadDatabase(path)
+	if err != nil {
+		t.Fatalf("LoadDatabase: %v", err)
+	}
+	if db.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", db.Len())
+	}
+	SetDatabase(db)
+	defer SetDatabase(nil)
+
+	tests := []struct {
+		peerID  string
+		name    string
+		version string
+		tags    string
+	}{
+		{"-XL0019-abcdefghijkl", "Thunder Cloud", "", "offline_download"}, // longest prefix wins
+		{"-XL0018-abcdefghijkl", "Thunder Old", "", ""},
+		{"-XL1120-abcdefghijkl", "Thunder", "11.20", "offline_download,known_leecher"},
+		{"-Q91000-abcdefghijkl", "NewLeecher", "1.0", "known_leecher"},
+		{"T03I--abcdefghijklmn", "BitTornado Mod", "0.3.18", ""},
+		{"-qB5120-abcdefghijkl", "qBittorrent", "5.1.2", ""},
+		{"-SD0100-abcdefghijkl", "Xunlei", "0.1", "offline_download"},
+	}
+	for _, tt := range tests {
+		info := Parse(tt.peerID)
+		if info.Name != tt.name || info.Version != tt.version || strings.Join(info.Tags, ",") != tt.tags {
+			t.Errorf("Parse(%q) = %+v, want %s %s [%s]", tt.peerID, info, tt.name, tt.version, tt.tags)
+		}
+	}
+
+	SetDatabase(nil)
+	if got := GetNameWithVersion("-XL0019-abcdefghijkl"); got != "Xunlei 0.0.1.9" {
+		t.Errorf("after reset got %s, want built-in Xunlei 0.0.1.9", got)
+	}
+}
+
+func TestLoadDatabaseJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	data := `{"clients": [{"style": "azureus", "code": "Z9", "name": "Zed", "tags": ["known_leecher"]}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := LoadDatabase(path)
+	if err != nil {
+		t.Fatalf("LoadDatabase: %v", err)
+	}
+	if db.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", db.Len())
+	}
+}
+
+func TestNewDatabaseInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients []Client
+		want    string
+	}{
+		{"missing name", []Client{{Style: StyleAzureus, Code: "XL"}}, "missing name"},
+		{"unknown style", []Client{{Style: "bep42", Name: "x"}}, "unknown style"},
+		{"bad azureus code", []Client{{Style: StyleAzureus, Code: "XLX", Name: "x"}}, "two letters"},
+		{"bad shadow code", []Client{{Style: StyleShadow, Code: "-", Name: "x"}}, "one letter"},
+		{"fixed without prefix", []Client{{Style: StyleFixed, Name: "x"}}, "needs prefix"},
+		{"unknown decoder", []Client{{Style: StyleAzureus, Code: "XL", Name: "x", Version: "hex"}}, "unknown version decoder"},
+		{"decoder on shadow", []Client{{Style: StyleShadow, Code: "T", Name: "x", Version: "digits"}}, "only apply to azureus"},
+		{"bad tag", []Client{{Style: StyleAzureus, Code: "XL", Name: "x", Tags: []string{"Known Leecher"}}}, "invalid tag"},
+		{"duplicate code", []Client{{Style: StyleAzureus, Code: "XL", Name: "x"}, {Style: StyleAzureus, Code: "XL", Name: "y"}}, "duplicate"},
+	}
+	for _, tt := range tests {
+		_, err := NewDatabase(tt.clients)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadDatabaseUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	if err := os.WriteFile(path, []byte("clients:\n  - style: azureus\n    code: XL\n    nmae: typo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDatabase(path); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
@@ -6,10 +6,31 @@ import (
 	"strings"
 )
 
+// Style is the peer ID encoding scheme a client uses (BEP 20)
+type Style string
+
+// Peer ID styles
+const (
+	StyleAzureus   Style = "azureus"   // -XXYYYY-
+	StyleShadow    Style = "shadow"    // CYYYYY---
+	StyleMainline  Style = "mainline"  // MY-Y-Y--
+	StyleBitComet  Style = "bitcomet"  // exbc + 二进制版本
+	StyleXBT       Style = "xbt"       // XBTYYY
+	StyleOpera     Style = "opera"     // OPYYYY
+	StyleMLdonkey  Style = "mldonkey"  // -MLY.Y.Y-
+	StyleBitSpirit Style = "bitspirit" // ?YBS
+	StyleAria2     Style = "aria2"     // A2-Y-Y-Y-
+	StyleFixed     Style = "fixed"     // 固定前缀
+	StyleUnknown   Style = "unknown"
+)
+
 // ClientInfo contains parsed client information from peer ID
 type ClientInfo struct {
 	Name    string
 	Version string
+	Style   Style
+	Prefix  string   // 标识客户端和版本的原始前缀
+	Tags    []string // 如offline_download，来自内置表或客户端数据库
 }
 
 // azureusClients maps Azureus-style peer ID prefixes (2 chars after -) to client names
@@ -61,9 +82,10 @@ var azureusClients = map[string]string{
 	"LC": "LeechCraft",
 	"LH": "LH-ABC",
 	"LP": "Lphant",
-	"LT": "libtorrent",
+	"LT": "libtorrent (Rasterbar)",
+	"lt": "libTorrent (Rakshasa)",
 	"LW": "LimeWire",
-	"Lr": "LibTorrent (Rasterbar)",
+	"Lr": "LibreTorrent",
 	"MK": "Meerkat",
 	"ML": "MLDonkey",
 	"MO": "MonoTorrent",
@@ -111,6 +133,30 @@ var azureusClients = map[string]string{
 	"ZT": "ZipTorrent",
 	"ZP": "ZipTorrent",
 	"ZZ": "ZipTorrent",
+	"7T": "aTorrent",
+	"AB": "AnyEvent::BitTorrent",
+	"AX": "BitPump",
+	"BF": "Bitflu",
+	"BI": "BiglyBT",
+	"IL": "iLivid",
+	"PB": "Protocol::BitTorrent",
+	"PT": "Popcorn Time",
+	"TX": "Tixati",
+	"UE": "uTorrent Embedded",
+	"WS": "HTTP Seed",
+	"XS": "XSwifter",
+	"ZO": "Zona",
+}
+
+// azureusVersions selects the version decoder of Azureus-style clients
+// whose version field is not one character per component
+var azureusVersions = map[string]string{
+	"BC": "major_minor",
+	"TR": "transmission",
+	"UT": "utorrent",
+	"UM": "utorrent",
+	"UE": "utorrent",
+	"UW": "utorrent",
 }
 
 // shadowClients maps Shadow's-style peer ID prefixes (single char) to client names
@@ -125,93 +171,33 @@ var shadowClients = map[string]string{
 	"U": "UPnP NAT Bit Torrent",
 }
 
-// Parse parses a peer ID and returns client information
-func Parse(peerID string) ClientInfo {
-	// Handle URL-encoded peer IDs
+// Decode returns the raw peer ID. aria2 reports peer IDs percent-encoded.
+func Decode(peerID string) string {
 	if strings.Contains(peerID, "%") {
 		if decoded, err := url.QueryUnescape(peerID); err == nil {
-			peerID = decoded
-		}
-	}
-
-	// Check Azureus-style peer IDs: -XXYYYY-
-	if strings.HasPrefix(peerID, "-") && len(peerID) >= 8 {
-		// Extract the 2-character client code
-		clientCode := peerID[1:3]
-		if name, ok := azureusClients[clientCode]; ok {
-			info := ClientInfo{
-				Name: name,
-			}
-			// Extract version (4 characters after client code)
-			if len(peerID) >= 7 {
-				versionRaw := peerID[3:7]
-				info.Version = formatVersion(versionRaw)
-			}
-			return info
-		}
-	}
-
-	// Check Shadow's style peer IDs (single character prefix)
-	if len(peerID) >= 1 {
-		prefix := string(peerID[0])
-		if name, ok := shadowClients[prefix]; ok {
-			return ClientInfo{
-				Name:    name,
-				Version: "",
-			}
-		}
-	}
-
-	// Check for mainline style: MYYYP...
-	if len(peerID) >= 4 && peerID[0] == 'M' {
-		return ClientInfo{
-			Name:    "BitTorrent Mainline",
-			Version: formatVersion(peerID[1:4]),
+			return decoded
 		}
 	}
-
-	return ClientInfo{
-		Name:    "Unknown",
-		Version: "",
-	}
+	return peerID
 }
 
-// formatVersion formats a raw version string into a readable version
-func formatVersion(raw string) string {
-	if len(raw) < 4 {
-		return raw
-	}
-
-	// Handle version formats like "0213" -> "2.13" or "5120" -> "51.20"
-	// Common format: XXYY where XX is major, YY is minor
-	major := raw[:2]
-	minor := raw[2:4]
-
-	// Parse major version
-	majorNum := 0
-	for _, c := range major {
-		if c >= '0' && c <= '9' {
-			majorNum = majorNum*10 + int(c-'0')
-		}
-	}
+// Parse parses a peer ID and returns client information
+func Parse(peerID string) ClientInfo {
+	// Handle URL-encoded peer IDs
+	peerID = Decode(peerID)
 
-	// Parse minor version
-	minorNum := 0
-	for _, c := range minor {
-		if c >= '0' && c <= '9' {
-			minorNum = minorNum*10 + int(c-'0')
+	// Ordered so that formats sharing a first character with a more
+	// generic style are recognised first
+	for _, parse := range parsers {
+		if info, ok := parse(peerID); ok {
+			return info
 		}
 	}
 
-	// Format version
-	if majorNum > 0 {
-		if minorNum > 0 {
-			return strings.TrimLeft(major, "0") + "." + strings.TrimLeft(minor, "0")
-		}
-		return strings.TrimLeft(major, "0")
+	return ClientInfo{
+		Name:  "Unknown",
+		Style: StyleUnknown,
 	}
-
-	return raw
 }
 
 // GetName returns the client name from a peer ID
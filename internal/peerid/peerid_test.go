@@ -24,8 +24,8 @@ func TestParseQBitTorrent(t *testing.T) {
 	if info.Name != "qBittorrent" {
 		t.Errorf("Expected qBittorrent, got %s", info.Name)
 	}
-	if info.Version != "51.20" {
-		t.Errorf("Expected version 51.20, got %s", info.Version)
+	if info.Version != "5.1.2" {
+		t.Errorf("Expected version 5.1.2, got %s", info.Version)
 	}
 }
 
@@ -79,9 +79,9 @@ func TestGetNameWithVersion(t *testing.T) {
 		peerID   string
 		expected string
 	}{
-		{"-qB5120-ME_GpvJS-s49", "qBittorrent 51.20"},
+		{"-qB5120-ME_GpvJS-s49", "qBittorrent 5.1.2"},
 		{"-BC0213-xxxxxxxxxxxx", "BitComet 2.13"},
-		{"-TR2940-xxxxxxxxxxxx", "Transmission 29.40"},
+		{"-TR2940-xxxxxxxxxxxx", "Transmission 2.94"},
 		{"unknown-format", "Unknown"},
 	}
 
@@ -93,6 +93,82 @@ func TestGetNameWithVersion(t *testing.T) {
 	}
 }
 
+func TestParseCorpus(t *testing.T) {
+	tests := []struct {
+		peerID  string
+		name    string
+		version string
+		style   Style
+		prefix  string
+	}{
+		// Azureus style
+		{"-qB4250-abcdefghijkl", "qBittorrent", "4.2.5", StyleAzureus, "-qB4250-"},
+		{"-qB5120-ME_GpvJS-s49", "qBittorrent", "5.1.2", StyleAzureus, "-qB5120-"},
+		{"-qB41A0-abcdefghijkl", "qBittorrent", "4.1.10", StyleAzureus, "-qB41A0-"},
+		{"-TR0072-abcdefghijkl", "Transmission", "0.72", StyleAzureus, "-TR0072-"},
+		{"-TR2940-abcdefghijkl", "Transmission", "2.94", StyleAzureus, "-TR2940-"},
+		{"-TR300Z-abcdefghijkl", "Transmission", "3.00", StyleAzureus, "-TR300Z-"},
+		{"-TR4050-abcdefghijkl", "Transmission", "4.0.5", StyleAzureus, "-TR4050-"},
+		{"-UT355B-abcdefghijkl", "uTorrent", "3.5.5", StyleAzureus, "-UT355B-"},
+		{"-UM1870-abcdefghijkl", "uTorrent Mac", "1.8.7", StyleAzureus, "-UM1870-"},
+		{"-BC0213-abcdefghijkl", "BitComet", "2.13", StyleAzureus, "-BC0213-"},
+		{"-BC0108-abcdefghijkl", "BitComet", "1.08", StyleAzureus, "-BC0108-"},
+		{"-DE13D0-abcdefghijkl", "Deluge", "1.3.13", StyleAzureus, "-DE13D0-"},
+		{"-DE2110-abcdefghijkl", "Deluge", "2.1.1", StyleAzureus, "-DE2110-"},
+		{"-LT2070-abcdefghijkl", "libtorrent (Rasterbar)", "2.0.7", StyleAzureus, "-LT2070-"},
+		{"-lt0D60-abcdefghijkl", "libTorrent (Rakshasa)", "0.13.6", StyleAzureus, "-lt0D60-"},
+		{"-AZ5770-abcdefghijkl", "Azureus/Vuze", "5.7.7", StyleAzureus, "-AZ5770-"},
+		{"-BI3500-abcdefghijkl", "BiglyBT", "3.5", StyleAzureus, "-BI3500-"},
+		{"-XL0019-abcdefghijkl", "Xunlei", "0.0.1.9", StyleAzureus, "-XL0019-"},
+		{"-SD0100-abcdefghijkl", "Xunlei", "0.1", StyleAzureus, "-SD0100-"},
+		{"-Z91234-abcdefghijkl", "Unknown", "", StyleAzureus, "-Z91234-"},
+
+		// Shadow style
+		{"T03I--abcdefghijklmn", "BitTornado", "0.3.18", StyleShadow, "T03I-"},
+		{"A310--abcdefghijklmn", "ABC", "3.1.0", StyleShadow, "A310-"},
+		{"S587-abcdefghijklmno", "Shad0w", "5.8.7", StyleShadow, "S587-"},
+		{"R1234-abcdefghijklmn", "Tribler", "1.2.3.4", StyleShadow, "R1234-"},
+
+		// Mainline style
+		{"M4-4-0--abcdefghijkl", "BitTorrent Mainline", "4.4.0", StyleMainline, "M4-4-0-"},
+		{"M7-10-3--abcdefghijk", "BitTorrent Mainline", "7.10.3", StyleMainline, "M7-10-3-"},
+		{"Q1-10-0-abcdefghijkl", "Queen Bee", "1.10.0", StyleMainline, "Q1-10-0-"},
+
+		// Binary versions
+		{"exbc\x00\x30" + "abcdefghijklmn", "BitComet", "0.48", StyleBitComet, "exbc"},
+		{"exbc\x00\x03LORDabcdefghij", "BitLord", "0.03", StyleBitComet, "exbc..LORD"},
+		{"FUTB\x00\x34abcdefghijklmn", "BitComet", "0.52", StyleBitComet, "FUTB"},
+		{"\x00\x03BS" + "abcdefghijklmnop", "BitSpirit", "3", StyleBitSpirit, "\x00\x03BS"},
+		{"\x00\x00BS" + "abcdefghijklmnop", "BitSpirit", "1", StyleBitSpirit, "\x00\x00BS"},
+
+		// Other schemes
+		{"XBT054d-abcdefghijkl", "XBT Client", "0.5.4", StyleXBT, "XBT054d"},
+		{"XBT100-abcdefghijklm", "XBT Client", "1.0.0", StyleXBT, "XBT100-"},
+		{"OP7685abcdefghijklmn", "Opera", "7685", StyleOpera, "OP7685"},
+		{"-ML2.7.2-abcdefghijk", "MLdonkey", "2.7.2", StyleMLdonkey, "-ML2.7.2-"},
+		{"A2-1-37-0-abcdefghij", "aria2", "1.37.0", StyleAria2, "A2-1-37-0-"},
+
+		// Fixed prefixes
+		{"-aria2-abcdefghijklm", "aria2", "", StyleFixed, "-aria2-"},
+		{"AZ2500BTabcdefghijkl", "BitTyrant", "", StyleFixed, "AZ2500BT"},
+		{"Mbrst1-0-2abcdefghij", "Burst!", "1.0.2", StyleFixed, "Mbrst1-0-2"},
+		{"271-abcdefghijklmnop", "GreedBT", "2.7.1", StyleFixed, "271-"},
+		{"-BOWA0C-abcdefghijkl", "Bits on Wheels", "", StyleFixed, "-BOW"},
+		{"turbobt5.0.3abcdefgh", "TurboBT", "", StyleFixed, "turbobt"},
+
+		// Unrecognised
+		{"unknown-peer-id-form", "Unknown", "", StyleUnknown, ""},
+		{"", "Unknown", "", StyleUnknown, ""},
+	}
+
+	for _, tt := range tests {
+		info := Parse(tt.peerID)
+		if info.Name != tt.name || info.Version != tt.version || info.Style != tt.style || info.Prefix != tt.prefix {
+			t.Errorf("Parse(%q) = %+v, want %s %s (%s, %q)", tt.peerID, info, tt.name, tt.version, tt.style, tt.prefix)
+		}
+	}
+}
+
 func TestURLDecodedPeerID(t *testing.T) {
 	// Test that URL-encoded peer IDs are properly decoded
 	peerID := "%2DqB5120%2DME_GpvJS-s49"
@@ -102,3 +178,86 @@ func TestURLDecodedPeerID(t *testing.T) {
 		t.Errorf("Expected qBittorrent, got %s", info.Name)
 	}
 }
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.13", "2.9", 1},
+		{"4.3", "4.3.0", 0},
+		{"4.2.5", "4.3", -1},
+		{"0.0.1.9", "0.1", -1},
+	}
+	for _, tt := range tests {
+		a, err := ParseVersion(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseVersion(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "1.", "a.b", "1.-2", "4.2b"} {
+		if _, err := ParseVersion(s); err == nil {
+			t.Errorf("ParseVersion(%q): expected error", s)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		expr    string
+		version string
+		want    bool
+	}{
+		{">=4.0 <4.4", "4.0", true},
+		{">=4.0 <4.4", "4.3.9", true},
+		{">=4.0 <4.4", "4.4", false},
+		{">=4.0 <4.4", "3.9", false},
+		{">= 4.0 < 4.4", "4.1", true},
+		{"2.x", "2.94", true},
+		{"2.x", "3.0", false},
+		{"2.13.*", "2.13.5", true},
+		{"2.13.*", "2.14", false},
+		{"=2.13", "2.13.0", true},
+		{"2.13", "2.13.1", false},
+		{"!=3.0", "3.0", false},
+		{"<4.3 || 2.x", "4.2", true},
+		{"<2 || >=4", "3.1", false},
+		{"<2 || >=4", "4.0.1", true},
+		{"x", "0.0.1.9", true},
+		{">1", "", false},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange(tt.expr)
+		if err != nil {
+			t.Errorf("ParseRange(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := r.MatchString(tt.version); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.expr, tt.version, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", ">=", ">=4.x", "4.0 ||", "~1.2", "<4.a"} {
+		if _, err := ParseRange(expr); err == nil {
+			t.Errorf("ParseRange(%q): expected error", expr)
+		}
+	}
+}
+
+func TestParsedVersion(t *testing.T) {
+	v, ok := Parse("-qB4390-abcdefghijkl").ParsedVersion()
+	if !ok || v.String() != "4.3.9" {
+		t.Errorf("ParsedVersion() = %v, %v, want 4.3.9", v, ok)
+	}
+	if _, ok := Parse("-aria2-abcdefghijklm").ParsedVersion(); ok {
+		t.Error("Expected no version for -aria2-")
+	}
+}
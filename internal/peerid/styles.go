@@ -0,0 +1,276 @@
+package peerid
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parsers recognise one peer ID style each, tried in order by Parse
+var parsers = []func(peerID string) (ClientInfo, bool){
+	parseDatabase,
+	parseFixed,
+	parseBitComet,
+	parseAria2,
+	parseMLdonkey,
+	parseAzureus,
+	parseMainline,
+	parseXBT,
+	parseOpera,
+	parseBitSpirit,
+	parseShadow,
+}
+
+// fixedClients are clients identified by a constant prefix
+var fixedClients = []struct {
+	prefix  string
+	name    string
+	version string
+}{
+	{"AZ2500BT", "BitTyrant", ""},
+	{"Deadman Walking-", "Deadman", ""},
+	{"-BOW", "Bits on Wheels", ""},
+	{"-G3", "G3 Torrent", ""},
+	{"-aria2-", "aria2", ""},
+	{"271-", "GreedBT", "2.7.1"},
+	{"346-", "TorrentTopia", ""},
+	{"10-------", "JVtorrent", ""},
+	{"BLZ", "Blizzard Downloader", ""},
+	{"btfans", "SimpleBT", ""},
+	{"BTDWV-", "Deadman Walking", ""},
+	{"btuga", "BTugaXP", ""},
+	{"BTuga", "BTugaXP", ""},
+	{"oernu", "BTugaXP", ""},
+	{"DansClient", "XanTorrent", ""},
+	{"LIME", "LimeWire", ""},
+	{"Pando", "Pando", ""},
+	{"turbobt", "TurboBT", ""},
+	{"Plus", "Plus!", ""},
+	{"eX", "eXeem", ""},
+}
+
+// shadowAlphabet maps Shadow-style version characters to their values
+const shadowAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz."
+
+var (
+	mainlinePattern = regexp.MustCompile(`^([MQ])(\d{1,3})-(\d{1,3})-(\d{1,3})-`)
+	burstPattern    = regexp.MustCompile(`^Mbrst(\d)-(\d)-(\d)`)
+	aria2Pattern    = regexp.MustCompile(`^A2-(\d{1,3})-(\d{1,3})-(\d{1,3})-`)
+	mldonkeyPattern = regexp.MustCompile(`^-ML(\d+\.\d+(?:\.\d+)*)-`)
+	xbtPattern      = regexp.MustCompile(`^XBT(\d)(\d)(\d)[d-]`)
+	operaPattern    = regexp.MustCompile(`^OP(\d{4})`)
+)
+
+// parseFixed recognises clients with a constant prefix
+func parseFixed(peerID string) (ClientInfo, bool) {
+	if m := burstPattern.FindStringSubmatch(peerID); m != nil {
+		return ClientInfo{Name: "Burst!", Version: m[1] + "." + m[2] + "." + m[3], Style: StyleFixed, Prefix: m[0]}, true
+	}
+	for _, c := range fixedClients {
+		if strings.HasPrefix(peerID, c.prefix) {
+			return ClientInfo{Name: c.name, Version: c.version, Style: StyleFixed, Prefix: c.prefix}, true
+		}
+	}
+	return ClientInfo{}, false
+}
+
+// parseBitComet recognises BitComet and BitLord: "exbc" followed by the
+// major and minor version as bytes, "LORD" for BitLord. FUTB and xUTB are
+// BitComet mods using the same layout.
+func parseBitComet(peerID string) (ClientInfo, bool) {
+	if len(peerID) < 6 {
+		return ClientInfo{}, false
+	}
+	prefix := peerID[:4]
+	if prefix != "exbc" && prefix != "FUTB" && prefix != "xUTB" {
+		return ClientInfo{}, false
+	}
+	info := ClientInfo{
+		Name:    "BitComet",
+		Version: fmt.Sprintf("%d.%02d", peerID[4], peerID[5]),
+		Style:   StyleBitComet,
+		Prefix:  prefix,
+	}
+	if len(peerID) >= 10 && peerID[6:10] == "LORD" {
+		info.Name = "BitLord"
+		info.Prefix = prefix + "..LORD"
+	}
+	return info, true
+}
+
+// parseAria2 recognises aria2: "A2-" and the version separated by dashes
+func parseAria2(peerID string) (ClientInfo, bool) {
+	m := aria2Pattern.FindStringSubmatch(peerID)
+	if m == nil {
+		return ClientInfo{}, false
+	}
+	return ClientInfo{Name: "aria2", Version: joinNumbers(m[1:]), Style: StyleAria2, Prefix: m[0]}, true
+}
+
+// parseMLdonkey recognises MLdonkey: "-ML" and a dotted version
+func parseMLdonkey(peerID string) (ClientInfo, bool) {
+	m := mldonkeyPattern.FindStringSubmatch(peerID)
+	if m == nil {
+		return ClientInfo{}, false
+	}
+	return ClientInfo{Name: "MLdonkey", Version: m[1], Style: StyleMLdonkey, Prefix: m[0]}, true
+}
+
+// parseAzureus recognises "-XXYYYY-": a two character client code and
+// four version characters
+func parseAzureus(peerID string) (ClientInfo, bool) {
+	if len(peerID) < 8 || peerID[0] != '-' || peerID[7] != '-' {
+		return ClientInfo{}, false
+	}
+	code := peerID[1:3]
+	if !isAlnum(code[0]) || !isAlnum(code[1]) {
+		return ClientInfo{}, false
+	}
+
+	info := ClientInfo{Name: "Unknown", Style: StyleAzureus, Prefix: peerID[:8]}
+	if c, ok := lookupAzureus(code); ok {
+		info.Name = c.Name
+		info.Version = decodeAzureusVersion(c.Version, peerID[3:7])
+		info.Tags = c.Tags
+	}
+	return info, true
+}
+
+// parseMainline recognises BitTorrent Mainline and Queen Bee: a letter and
+// the version separated by dashes, e.g. M7-10-3--
+func parseMainline(peerID string) (ClientInfo, bool) {
+	m := mainlinePattern.FindStringSubmatch(peerID)
+	if m == nil {
+		return ClientInfo{}, false
+	}
+	name := "BitTorrent Mainline"
+	if m[1] == "Q" {
+		name = "Queen Bee"
+	}
+	return ClientInfo{Name: name, Version: joinNumbers(m[2:]), Style: StyleMainline, Prefix: m[0]}, true
+}
+
+// parseXBT recognises XBT: "XBT", three version digits and 'd' for debug builds
+func parseXBT(peerID string) (ClientInfo, bool) {
+	m := xbtPattern.FindStringSubmatch(peerID)
+	if m == nil {
+		return ClientInfo{}, false
+	}
+	return ClientInfo{Name: "XBT Client", Version: joinNumbers(m[1:]), Style: StyleXBT, Prefix: m[0]}, true
+}
+
+// parseOpera recognises Opera: "OP" and a four digit build number
+func parseOpera(peerID string) (ClientInfo, bool) {
+	m := operaPattern.FindStringSubmatch(peerID)
+	if m == nil {
+		return ClientInfo{}, false
+	}
+	return ClientInfo{Name: "Opera", Version: strings.TrimLeft(m[1], "0"), Style: StyleOpera, Prefix: m[0]}, true
+}
+
+// parseBitSpirit recognises BitSpirit: "BS" at offset 2 and the major
+// version in the second byte, 0 meaning version 1
+func parseBitSpirit(peerID string) (ClientInfo, bool) {
+	if len(peerID) < 4 || peerID[2:4] != "BS" {
+		return ClientInfo{}, false
+	}
+	major := int(peerID[1])
+	if major == 0 {
+		major = 1
+	}
+	return ClientInfo{Name: "BitSpirit", Version: strconv.Itoa(major), Style: StyleBitSpirit, Prefix: peerID[:4]}, true
+}
+
+// parseShadow recognises "CYYYYY": a client character and up to five
+// version characters, padded with dashes
+func parseShadow(peerID string) (ClientInfo, bool) {
+	if len(peerID) < 6 {
+		return ClientInfo{}, false
+	}
+	c, ok := lookupShadow(peerID[:1])
+	if !ok {
+		return ClientInfo{}, false
+	}
+
+	// The version ends at the first dash, which must come within six characters
+	end := strings.IndexByte(peerID[1:], '-')
+	if end < 1 || end > 5 {
+		return ClientInfo{}, false
+	}
+	parts := make([]string, 0, end)
+	for i := 1; i <= end; i++ {
+		v := strings.IndexByte(shadowAlphabet, peerID[i])
+		if v < 0 {
+			return ClientInfo{}, false
+		}
+		parts = append(parts, strconv.Itoa(v))
+	}
+	return ClientInfo{Name: c.Name, Version: strings.Join(parts, "."), Style: StyleShadow, Prefix: peerID[:end+2], Tags: c.Tags}, true
+}
+
+// decodeAzureusVersion decodes the four version characters of an
+// Azureus-style peer ID with the named decoder
+func decodeAzureusVersion(decoder, raw string) string {
+	switch decoder {
+	case "major_minor":
+		// "0213" -> 2.13
+		major := strings.TrimLeft(raw[:2], "0")
+		if major == "" {
+			major = "0"
+		}
+		return major + "." + raw[2:]
+
+	case "transmission":
+		// Before 1.0: "0072" -> 0.72; up to 3.x: "2940" -> 2.94 with a
+		// release flag last; from 4.0 one character per component
+		switch {
+		case raw[0] == '0':
+			return "0." + strings.TrimLeft(raw[1:], "0")
+		case raw[0] < '4':
+			return raw[:1] + "." + raw[1:3]
+		default:
+			return decodeDigits(raw[:3])
+		}
+
+	case "utorrent":
+		// Three components and a build type: "355B" -> 3.5.5
+		return decodeDigits(raw[:3])
+
+	default:
+		// One character per component: "5120" -> 5.1.2, "13D0" -> 1.3.13
+		return decodeDigits(raw)
+	}
+}
+
+// decodeDigits decodes one version component per character, with letters
+// counting from 10, and drops trailing zero components beyond major.minor
+func decodeDigits(raw string) string {
+	parts := make([]string, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		v := strings.IndexByte(shadowAlphabet, raw[i])
+		if v < 0 || v > 61 {
+			return raw
+		}
+		parts = append(parts, strconv.Itoa(v))
+	}
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+// joinNumbers joins numeric components with dots, dropping leading zeros
+func joinNumbers(parts []string) string {
+	out := make([]string, len(parts))
+	for i, p := range parts {
+		n, _ := strconv.Atoi(p)
+		out[i] = strconv.Itoa(n)
+	}
+	return strings.Join(out, ".")
+}
+
+// isAlnum reports whether c is an ASCII letter or digit
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,212 @@
+package peerid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed client version, one number per dotted component
+type Version []int
+
+// ParseVersion parses a dotted numeric version such as "2.13" or "4.3.9"
+func ParseVersion(s string) (Version, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty version")
+	}
+	parts := strings.Split(s, ".")
+	v := make(Version, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p[0] == '+' {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 as v is lower than, equal to or higher than
+// o. Missing components count as 0, so 4.3 equals 4.3.0.
+func (v Version) Compare(o Version) int {
+	for i := 0; i < len(v) || i < len(o); i++ {
+		var x, y int
+		if i < len(v) {
+			x = v[i]
+		}
+		if i < len(o) {
+			y = o[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// String formats the version with dots
+func (v Version) String() string {
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// ParsedVersion returns the client version, false if the peer ID carries
+// none
+func (c ClientInfo) ParsedVersion() (Version, bool) {
+	v, err := ParseVersion(c.Version)
+	return v, err == nil
+}
+
+// constraint is a single comparison of a range expression
+type constraint struct {
+	op string // >=, >, <=, <, = 或 !=
+	v  Version
+}
+
+// match reports whether v satisfies the comparison
+func (c constraint) match(v Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// Range is a version range expression. Comparisons separated by spaces
+// must all hold; "||" separates alternatives:
+//
+//	>=4.0 <4.4       4.0 up to but excluding 4.4
+//	2.x              any 2.* version, same as >=2 <3
+//	=2.13 || >=3.1   exactly 2.13 (2.13.0), or 3.1 and later
+type Range struct {
+	expr string
+	alts [][]constraint
+}
+
+// rangeOps are the comparison operators, longest first
+var rangeOps = []string{">=", "<=", "!=", "==", ">", "<", "="}
+
+// ParseRange parses a version range expression
+func ParseRange(expr string) (Range, error) {
+	r := Range{expr: strings.TrimSpace(expr)}
+	if r.expr == "" {
+		return r, fmt.Errorf("empty version range")
+	}
+
+	for _, alt := range strings.Split(r.expr, "||") {
+		fields := strings.Fields(alt)
+		if len(fields) == 0 {
+			return Range{}, fmt.Errorf("version range %q: empty alternative", expr)
+		}
+
+		var constraints []constraint
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// Allow a space between operator and version: ">= 4.0"
+			if isRangeOp(field) && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			c, err := parseConstraint(field)
+			if err != nil {
+				return Range{}, fmt.Errorf("version range %q: %w", expr, err)
+			}
+			constraints = append(constraints, c...)
+		}
+		r.alts = append(r.alts, constraints)
+	}
+	return r, nil
+}
+
+// parseConstraint parses "[op]version", where a bare version may end in
+// a wildcard component (x or *)
+func parseConstraint(s string) ([]constraint, error) {
+	op := "="
+	for _, o := range rangeOps {
+		if strings.HasPrefix(s, o) {
+			op, s = o, s[len(o):]
+			break
+		}
+	}
+	if op == "==" {
+		op = "="
+	}
+
+	// Wildcards: 2.x -> >=2 <3, x -> anything
+	if s == "x" || s == "*" || strings.HasSuffix(s, ".x") || strings.HasSuffix(s, ".*") {
+		if op != "=" {
+			return nil, fmt.Errorf("wildcard %q cannot follow %s", s, op)
+		}
+		if s == "x" || s == "*" {
+			return []constraint{{op: ">=", v: Version{0}}}, nil
+		}
+		lower, err := ParseVersion(s[:len(s)-2])
+		if err != nil {
+			return nil, err
+		}
+		upper := append(Version(nil), lower...)
+		upper[len(upper)-1]++
+		return []constraint{{op: ">=", v: lower}, {op: "<", v: upper}}, nil
+	}
+
+	v, err := ParseVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	return []constraint{{op: op, v: v}}, nil
+}
+
+// isRangeOp reports whether s is an operator on its own
+func isRangeOp(s string) bool {
+	for _, o := range rangeOps {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether v is within the range
+func (r Range) Match(v Version) bool {
+	for _, alt := range r.alts {
+		ok := true
+		for _, c := range alt {
+			if !c.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchString reports whether a version string is within the range. An
+// unparseable or missing version never matches.
+func (r Range) MatchString(version string) bool {
+	v, err := ParseVersion(version)
+	return err == nil && r.Match(v)
+}
+
+// String returns the expression the range was parsed from
+func (r Range) String() string {
+	return r.expr
+}
@@ -0,0 +1,246 @@
+// Package simulate replays recorded aria2 polls through the detector
+package simulate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+	"github.com/lbl1m/aria2bango/internal/detector"
+	"github.com/lbl1m/aria2bango/internal/peerid"
+	"github.com/lbl1m/aria2bango/internal/trace"
+)
+
+// Stale peer statistics are dropped like the daemon does
+const (
+	cleanupInterval = 5 * time.Minute
+	staleStatsAge   = 30 * time.Minute
+)
+
+// Snapshot is one aria2 poll: the active BT downloads and their peers
+type Snapshot struct {
+	Time     time.Time            `json:"time"`
+	Instance string               `json:"instance,omitempty"` // aria2实例名称
+	Torrents []aria2.TorrentPeers `json:"torrents"`
+}
+
+// ReadSnapshots reads a hand-written or converted trace of JSON lines, one
+// Snapshot per line, sorted by time
+func ReadSnapshots(r io.Reader) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snap); err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot on line %d: %w", line, err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// ReadTrace reads the snapshots of a trace recorded by the daemon,
+// including its rotated files
+func ReadTrace(path string) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	err := trace.ReadFiles(path, func(rec *trace.Record) error {
+		torrents, err := rec.Torrents()
+		if err != nil {
+			return fmt.Errorf("failed to decode poll at %s: %w", rec.Time.Format(time.RFC3339), err)
+		}
+		snapshots = append(snapshots, Snapshot{Time: rec.Time, Instance: rec.Instance, Torrents: torrents})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// Scenario is a named config to replay a trace with
+type Scenario struct {
+	Name   string
+	Config *config.Config
+}
+
+// Ban is a ban the detector would have issued
+type Ban struct {
+	Time       time.Time     `json:"time"`
+	IP         string        `json:"ip"`
+	PeerID     string        `json:"peer_id"`
+	ClientName string        `json:"client_name"`
+	Reason     string        `json:"reason"`
+	ShareRatio float64       `json:"share_ratio"`
+	Violations int           `json:"violations"`
+	Duration   time.Duration `json:"duration"`
+	Torrent    string        `json:"torrent"`
+	Instance   string        `json:"instance,omitempty"`
+}
+
+// MarshalJSON encodes the duration as a duration string
+func (b Ban) MarshalJSON() ([]byte, error) {
+	type plain Ban
+	return json.Marshal(struct {
+		plain
+		Duration string `json:"duration"`
+	}{plain(b), detector.FormatDuration(b.Duration)})
+}
+
+// Result is the outcome of replaying a trace with one scenario
+type Result struct {
+	Scenario  string `json:"scenario"`
+	Bans      []Ban  `json:"bans"`
+	Forgiven  int    `json:"forgiven"`  // 分享率恢复后清零的次数
+	Throttled int    `json:"throttled"` // 客户端规则限速次数
+	Watched   int    `json:"watched"`   // 客户端规则只记录的次数
+}
+
+// IPs returns the number of distinct banned IPs
+func (r *Result) IPs() int {
+	seen := make(map[string]bool)
+	for _, b := range r.Bans {
+		seen[b.IP] = true
+	}
+	return len(seen)
+}
+
+// BanTime returns the sum of all ban durations, detector.Permanent if
+// any ban is permanent
+func (r *Result) BanTime() time.Duration {
+	var total time.Duration
+	for _, b := range r.Bans {
+		total = AddBanTime(total, b.Duration)
+	}
+	return total
+}
+
+// AddBanTime adds a ban duration to a total, saturating at
+// detector.Permanent
+func AddBanTime(total, d time.Duration) time.Duration {
+	if d >= detector.Permanent-total {
+		return detector.Permanent
+	}
+	return total + d
+}
+
+// Run replays snapshots through a fresh detector configured by the
+// scenario. Time is taken from the snapshots, so a trace replays instantly.
+//
+// The trace records what aria2 reported under the config that was live at
+// the time, so peers banned then are missing from it while banned; peers
+// the scenario bans keep appearing and are skipped until the ban expires.
+func Run(snapshots []Snapshot, scenario Scenario) (*Result, error) {
+	result := &Result{Scenario: scenario.Name, Bans: []Ban{}}
+
+	rules, err := detector.NewClientRules(scenario.Config.Detection.ClientRules)
+	if err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", scenario.Name, err)
+	}
+
+	instances, err := scenario.Config.Instances()
+	if err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", scenario.Name, err)
+	}
+	for _, inst := range instances {
+		if err := detector.Check(inst.Detection); err != nil {
+			return nil, fmt.Errorf("scenario %s: %w", scenario.Name, err)
+		}
+	}
+
+	var now time.Time
+	det := detector.NewDetector(&scenario.Config.Detection)
+	det.SetClock(func() time.Time { return now })
+	det.SetClientRules(rules)
+
+	punishment, err := detector.NewPunishment(scenario.Config.Blocking.Punishment)
+	if err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", scenario.Name, err)
+	}
+	det.SetPunishment(punishment)
+
+	// Detectors of named instances, sharing the state of det
+	detectors := make(map[string]*detector.Detector, len(instances))
+	for i := range instances {
+		if instances[i].Name == "" {
+			det.SetPollInterval(instances[i].PollInterval)
+			continue
+		}
+		id := det.WithConfig(&instances[i].Detection)
+		id.SetPollInterval(instances[i].PollInterval)
+		detectors[instances[i].Name] = id
+	}
+
+	var lastCleanup time.Time
+	for _, snap := range snapshots {
+		now = snap.Time
+		if lastCleanup.IsZero() {
+			lastCleanup = now
+		} else if now.Sub(lastCleanup) >= cleanupInterval {
+			det.CleanupStaleStats(staleStatsAge)
+			det.PruneViolations()
+			lastCleanup = now
+		}
+
+		// Polls of named instances are judged by their own settings
+		d := det
+		if id, ok := detectors[snap.Instance]; ok {
+			d = id
+		}
+		totalUpload := aria2.TotalUploadSpeed(snap.Torrents)
+		for i := range snap.Torrents {
+			torrent := &snap.Torrents[i]
+			var results []*detector.DetectionResult
+			for _, peer := range torrent.Peers {
+				if res := d.Detect(peer, scenario.Config.Blocking.BaseDuration); res != nil {
+					results = append(results, res)
+				}
+			}
+			results = append(results, d.DetectSwarm(torrent, scenario.Config.Blocking.BaseDuration)...)
+			results = append(results, d.DetectReciprocity(torrent, scenario.Config.Blocking.BaseDuration)...)
+			results = append(results, d.DetectBurst(torrent, totalUpload, scenario.Config.Blocking.BaseDuration)...)
+
+			for _, res := range results {
+				peer := res.Peer
+				switch res.Action {
+				case detector.ActionBlock:
+					result.Bans = append(result.Bans, Ban{
+						Time:       now,
+						IP:         peer.IP,
+						PeerID:     peer.PeerID,
+						ClientName: peerid.GetNameWithVersion(peer.PeerID),
+						Reason:     res.Reason,
+						ShareRatio: res.ShareRatio,
+						Violations: res.Violations,
+						Duration:   res.BlockDuration,
+						Torrent:    torrent.Name(),
+						Instance:   snap.Instance,
+					})
+				case detector.ActionForgive:
+					result.Forgiven++
+				case detector.ActionThrottle:
+					result.Throttled++
+				case detector.ActionWatch:
+					result.Watched++
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
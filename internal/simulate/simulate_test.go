@@ -0,0 +1,79 @@
+package simulate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// leecherTrace has one peer taking 1MB/s while giving 50KB/s, polled every
+// 10s for 10 minutes
+func leecherTrace(start time.Time) []Snapshot {
+	var snapshots []Snapshot
+	for i := 0; i < 60; i++ {
+		torrent := aria2.TorrentPeers{Peers: []aria2.Peer{
+			{IP: "10.0.0.1", PeerID: "-XL0019-abcdefghijkl", UploadSpeed: 1 << 20, DownloadSpeed: 50 << 10},
+			{IP: "10.0.0.2", PeerID: "-qB4500-abcdefghijkl", UploadSpeed: 1 << 20, DownloadSpeed: 1 << 20},
+		}}
+		torrent.Download.InfoHash = "abcdef"
+		snapshots = append(snapshots, Snapshot{Time: start.Add(time.Duration(i) * 10 * time.Second), Torrents: []aria2.TorrentPeers{torrent}})
+	}
+	return snapshots
+}
+
+func TestRunScenarios(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := leecherTrace(start)
+
+	current := config.DefaultConfig()
+	result, err := Run(snapshots, Scenario{Name: "current", Config: current})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Default threshold 10MB is reached on the 10th poll; 5m, then 10m bans
+	if len(result.Bans) != 2 {
+		t.Fatalf("Expected 2 bans, got %+v", result.Bans)
+	}
+	if b := result.Bans[0]; b.IP != "10.0.0.1" || !b.Time.Equal(start.Add(90*time.Second)) || b.Duration != 5*time.Minute {
+		t.Errorf("Unexpected first ban: %+v", b)
+	}
+	if b := result.Bans[1]; b.Violations != 2 || b.Duration != 10*time.Minute || b.Torrent != "abcdef" {
+		t.Errorf("Unexpected second ban: %+v", b)
+	}
+	if result.IPs() != 1 || result.BanTime() != 15*time.Minute {
+		t.Errorf("Unexpected summary: %d IPs, %s", result.IPs(), result.BanTime())
+	}
+
+	lenient := config.DefaultConfig()
+	if err := lenient.Set("detection.behavior.min_share_ratio", "0.01"); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := Run(snapshots, Scenario{Name: "lenient", Config: lenient}); len(result.Bans) != 0 {
+		t.Errorf("Expected no bans with lenient ratio, got %+v", result.Bans)
+	}
+}
+
+func TestReadSnapshots(t *testing.T) {
+	trace := `{"time":"2024-01-01T00:00:10Z","torrents":[{"download":{"gid":"1","infoHash":"ab"},"peers":[{"ip":"10.0.0.1","port":"6881","downloadSpeed":"10","uploadSpeed":"20"}]}]}
+
+{"time":"2024-01-01T00:00:00Z","torrents":[]}
+`
+	snapshots, err := ReadSnapshots(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("ReadSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(snapshots))
+	}
+	if len(snapshots[1].Torrents) != 1 || snapshots[1].Torrents[0].Peers[0].UploadSpeed != 20 {
+		t.Errorf("Snapshots not sorted or peers not parsed: %+v", snapshots)
+	}
+
+	if _, err := ReadSnapshots(strings.NewReader("{broken\n")); err == nil {
+		t.Error("Expected error for malformed line")
+	}
+}
@@ -0,0 +1,171 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotTrace is returned when a file does not start with the trace header
+var ErrNotTrace = errors.New("not a trace file")
+
+// Reader reads records from a trace file
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+	gz  *gzip.Reader
+}
+
+// NewReader checks the trace header and returns a reader positioned at the
+// first record
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != magic {
+		return nil, ErrNotTrace
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one. A frame cut
+// short, e.g. by a crash while writing, is reported as io.ErrUnexpectedEOF.
+func (r *Reader) Next() (*Record, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("trace frame of %d bytes exceeds limit", n)
+	}
+
+	if cap(r.buf) < int(n) {
+		r.buf = make([]byte, n)
+	}
+	frame := r.buf[:n]
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	var err error
+	if r.gz == nil {
+		r.gz, err = gzip.NewReader(bytes.NewReader(frame))
+	} else {
+		err = r.gz.Reset(bytes.NewReader(frame))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress trace frame: %w", err)
+	}
+
+	var rec Record
+	if err := json.NewDecoder(r.gz).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("failed to decode trace record: %w", err)
+	}
+	return &rec, nil
+}
+
+// IsTrace reports whether the file at path is a trace file
+func IsTrace(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = NewReader(f)
+	return err == nil
+}
+
+// Files returns the rotated files of the trace at path, oldest first,
+// followed by the trace itself if it exists
+func Files(path string) ([]string, error) {
+	files, err := rotatedFiles(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// ReadFiles calls fn for every record of the trace at path and its rotated
+// files, oldest first. A truncated last frame of a file is skipped.
+func ReadFiles(path string, fn func(*Record) error) error {
+	files, err := Files(path)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no trace files at %s", path)
+	}
+	for _, file := range files {
+		if err := readFile(file, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile calls fn for every record of a single trace file
+func readFile(path string, fn func(*Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// rotatedFiles lists the rotated files of the trace at path, oldest first
+func rotatedFiles(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	prefix := filepath.Base(path) + "."
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if len(name)-len(prefix) != len(rotatedTimeFormat) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	// Timestamps sort lexically
+	sort.Strings(files)
+	return files, nil
+}
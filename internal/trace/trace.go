@@ -0,0 +1,39 @@
+// Package trace records raw aria2 polls to compact, size-capped trace files
+// and reads them back.
+//
+// A trace file starts with a magic header followed by frames. Each frame is
+// a 4-byte big-endian length and a gzip-compressed JSON Record. When a file
+// reaches its size cap it is renamed to <path>.<timestamp> and a new file is
+// started; the oldest rotated files beyond the backup count are removed.
+package trace
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/aria2"
+)
+
+// magic identifies trace files and their format version
+const magic = "A2BTRC1\n"
+
+// maxFrameSize guards against reading garbage as a huge frame length
+const maxFrameSize = 64 * 1024 * 1024
+
+// Record is one aria2 poll as it was returned by the RPC interface
+type Record struct {
+	Time     time.Time                  `json:"time"`
+	Instance string                     `json:"instance,omitempty"` // aria2实例名称
+	Active   json.RawMessage            `json:"tell_active"`
+	Peers    map[string]json.RawMessage `json:"get_peers"` // gid -> getPeers结果
+}
+
+// NewRecord creates a record from a poll of the named aria2 instance
+func NewRecord(instance string, poll *aria2.PollResult) Record {
+	return Record{Time: poll.Time, Instance: instance, Active: poll.Active, Peers: poll.Peers}
+}
+
+// Torrents decodes the active BT downloads and their peers
+func (r *Record) Torrents() ([]aria2.TorrentPeers, error) {
+	return aria2.ParseTorrentPeers(r.Active, r.Peers)
+}
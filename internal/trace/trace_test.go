@@ -0,0 +1,194 @@
+package trace
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+func testRecord(i int) Record {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	active := fmt.Sprintf(`[{"gid":"g%d","status":"active","infoHash":"abc","totalLength":"100"}]`, i)
+	peers := fmt.Sprintf(`[{"peerId":"-qB4500-abcdefghijkl","ip":"10.0.0.%d","port":"6881","downloadSpeed":"%d","uploadSpeed":"1024"}]`, i%250+1, i)
+	return Record{
+		Time:   start.Add(time.Duration(i) * 10 * time.Second),
+		Active: json.RawMessage(active),
+		Peers:  map[string]json.RawMessage{fmt.Sprintf("g%d", i): json.RawMessage(peers)},
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.bin")
+	w, err := NewWriter(config.TraceConfig{Path: path, MaxSize: 10, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.Write(testRecord(i)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	w.Close()
+
+	// Appending to an existing trace must not repeat the header
+	w, err = NewWriter(config.TraceConfig{Path: path, MaxSize: 10, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(testRecord(3))
+	w.Close()
+
+	var got []*Record
+	if err := ReadFiles(path, func(rec *Record) error {
+		got = append(got, rec)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadFiles failed: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 records, got %d", len(got))
+	}
+
+	torrents, err := got[2].Torrents()
+	if err != nil {
+		t.Fatalf("Torrents failed: %v", err)
+	}
+	if len(torrents) != 1 || torrents[0].Download.Gid != "g2" || torrents[0].Peers[0].DownloadSpeed != 2 {
+		t.Errorf("Unexpected torrents: %+v", torrents)
+	}
+	if !got[3].Time.Equal(testRecord(3).Time) {
+		t.Errorf("Unexpected time %s", got[3].Time)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.bin")
+	w, err := NewWriter(config.TraceConfig{Path: path, MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hardly compressible padding so a few records fill a 1MB file
+	rnd := rand.New(rand.NewSource(1))
+	pad := make([]byte, 300*1024)
+	written := 0
+	for i := 0; i < 12; i++ {
+		rnd.Read(pad)
+		rec := testRecord(i)
+		rec.Peers["pad"] = json.RawMessage(`"` + base64.StdEncoding.EncodeToString(pad) + `"`)
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		written++
+		time.Sleep(2 * time.Millisecond)
+	}
+	w.Close()
+
+	files, err := Files(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected 2 rotated files and the current one, got %v", files)
+	}
+	for _, f := range files {
+		info, _ := os.Stat(f)
+		if info.Size() > 1024*1024 {
+			t.Errorf("%s exceeds size cap: %d", f, info.Size())
+		}
+	}
+
+	// The oldest records were pruned, the rest are in order
+	var last time.Time
+	count := 0
+	ReadFiles(path, func(rec *Record) error {
+		if rec.Time.Before(last) {
+			t.Errorf("Records out of order: %s after %s", rec.Time, last)
+		}
+		last = rec.Time
+		count++
+		return nil
+	})
+	if count == 0 || count >= written {
+		t.Errorf("Expected some but not all of %d records, got %d", written, count)
+	}
+	if !last.Equal(testRecord(written - 1).Time) {
+		t.Errorf("Expected newest record last, got %s", last)
+	}
+}
+
+func TestTruncatedAndInvalid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "trace.bin")
+	w, err := NewWriter(config.TraceConfig{Path: path, MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(testRecord(0))
+	w.Write(testRecord(1))
+	w.Close()
+
+	// Simulate a crash halfway through the last frame
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	if err := ReadFiles(path, func(*Record) error { count++; return nil }); err != nil {
+		t.Fatalf("Expected truncated frame to be skipped, got %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 record, got %d", count)
+	}
+
+	// Reopening after the crash drops the partial frame before appending
+	w, err = NewWriter(config.TraceConfig{Path: path, MaxSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to reopen truncated trace: %v", err)
+	}
+	if err := w.Write(testRecord(2)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	var times []time.Time
+	if err := ReadFiles(path, func(rec *Record) error { times = append(times, rec.Time); return nil }); err != nil {
+		t.Fatalf("Failed to read recovered trace: %v", err)
+	}
+	if len(times) != 2 || !times[0].Equal(testRecord(0).Time) || !times[1].Equal(testRecord(2).Time) {
+		t.Errorf("Expected records 0 and 2 after recovery, got %v", times)
+	}
+
+	other := filepath.Join(dir, "peers.jsonl")
+	os.WriteFile(other, []byte(`{"time":"2024-01-01T00:00:00Z"}`+"\n"), 0644)
+	if IsTrace(other) || !IsTrace(path) {
+		t.Error("IsTrace misdetected files")
+	}
+	if _, err := NewReader(strings.NewReader("garbage")); err != ErrNotTrace {
+		t.Errorf("Expected ErrNotTrace, got %v", err)
+	}
+	if _, err := NewWriter(config.TraceConfig{Path: other}); !errors.Is(err, ErrNotTrace) {
+		t.Errorf("Expected NewWriter to refuse a non-trace file, got %v", err)
+	}
+
+	// A crash while writing the header leaves a file to start over
+	partial := filepath.Join(dir, "partial.bin")
+	os.WriteFile(partial, []byte(magic[:3]), 0640)
+	w, err = NewWriter(config.TraceConfig{Path: partial})
+	if err != nil {
+		t.Fatalf("Failed to reopen trace with partial header: %v", err)
+	}
+	w.Write(testRecord(0))
+	w.Close()
+	if err := ReadFiles(partial, func(*Record) error { return nil }); err != nil {
+		t.Errorf("Failed to read trace after partial header: %v", err)
+	}
+}
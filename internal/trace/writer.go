@@ -0,0 +1,212 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lbl1m/aria2bango/internal/config"
+)
+
+// rotatedTimeFormat is the timestamp suffix of rotated trace files
+const rotatedTimeFormat = "20060102-150405.000"
+
+// Writer appends records to a trace file, rotating it by size
+type Writer struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+	buf   bytes.Buffer
+	gz    *gzip.Writer
+}
+
+// NewWriter opens the trace file for appending, creating it if needed
+func NewWriter(cfg config.TraceConfig) (*Writer, error) {
+	w := &Writer{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.MaxSize) * 1024 * 1024,
+		maxBackups: cfg.MaxBackups,
+	}
+	w.gz = gzip.NewWriter(&w.buf)
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trace directory: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the current trace file and writes the header if it is new.
+// A frame cut short by a crash is truncated, so that new frames follow the
+// last complete one.
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat trace file: %w", err)
+	}
+	size, err := completeLength(f, info.Size())
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", w.path, err)
+	}
+	if size < info.Size() {
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to truncate partial trace frame: %w", err)
+		}
+	}
+	w.file = f
+	w.size = size
+
+	if w.size == 0 {
+		n, err := f.WriteString(magic)
+		w.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write trace header: %w", err)
+		}
+	}
+	return nil
+}
+
+// completeLength returns the length of the header and complete frames of a
+// trace file of the given size, 0 if the header itself is incomplete
+func completeLength(f *os.File, size int64) (int64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	header := make([]byte, len(magic))
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to read trace header: %w", err)
+	}
+	if !strings.HasPrefix(magic, string(header[:n])) {
+		return 0, ErrNotTrace
+	}
+	if n < len(magic) {
+		return 0, nil
+	}
+
+	// Frames are only walked by their lengths, a crash can only cut the
+	// last one short
+	offset := int64(len(magic))
+	var length [4]byte
+	for offset+int64(len(length)) <= size {
+		if _, err := f.ReadAt(length[:], offset); err != nil {
+			return 0, fmt.Errorf("failed to read trace frame: %w", err)
+		}
+		n := int64(binary.BigEndian.Uint32(length[:]))
+		if n > maxFrameSize || offset+int64(len(length))+n > size {
+			break
+		}
+		offset += int64(len(length)) + n
+	}
+	return offset, nil
+}
+
+// Write appends a record
+func (w *Writer) Write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace record: %w", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+
+	w.buf.Reset()
+	w.buf.Write(make([]byte, 4)) // length, filled in below
+	w.gz.Reset(&w.buf)
+	if _, err := w.gz.Write(data); err != nil {
+		return fmt.Errorf("failed to compress trace record: %w", err)
+	}
+	if err := w.gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress trace record: %w", err)
+	}
+	frame := w.buf.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	if w.maxSize > 0 && w.size > int64(len(magic)) && w.size+int64(len(frame)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(frame)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write trace record: %w", err)
+	}
+	return nil
+}
+
+// rotate renames the current file and starts a new one
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close trace file: %w", err)
+	}
+	w.file = nil
+
+	rotated := w.path + "." + time.Now().Format(rotatedTimeFormat)
+	if err := os.Rename(w.path, rotated); err != nil {
+		// Keep appending to the current file
+		if openErr := w.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rotate trace file: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.prune()
+}
+
+// prune removes the oldest rotated files beyond maxBackups
+func (w *Writer) prune() error {
+	rotated, err := rotatedFiles(w.path)
+	if err != nil {
+		return err
+	}
+	for len(rotated) > w.maxBackups {
+		if err := os.Remove(rotated[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old trace file: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Close closes the trace file
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}